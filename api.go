@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+//#region Web API
+
+const (
+	apiRecentDefault = 25
+	apiRecentMax     = 500
+)
+
+type apiStatus struct {
+	Project       string    `json:"project"`
+	Version       string    `json:"version"`
+	Started       time.Time `json:"started"`
+	Uptime        string    `json:"uptime"`
+	UptimeSeconds int64     `json:"uptimeSeconds"`
+	Connected     bool      `json:"connected"`
+	Latency       int64     `json:"latencyMs"`
+	Servers       int       `json:"servers"`
+	Database      string    `json:"database"`
+	QueueWaiting  int       `json:"queueWaiting"`
+	QueueRunning  int       `json:"queueRunning"`
+	HistoryJobs   int       `json:"historyJobs"`
+}
+
+type apiSource struct {
+	ChannelID   string `json:"channelID"`
+	ChannelName string `json:"channelName"`
+	ServerName  string `json:"serverName"`
+	Destination string `json:"destination"`
+}
+
+type apiHistoryJob struct {
+	ChannelID   string    `json:"channelID"`
+	ChannelName string    `json:"channelName"`
+	ServerName  string    `json:"serverName"`
+	Status      string    `json:"status"`
+	State       string    `json:"state"`
+	OriginUser  string    `json:"originUser"`
+	Before      string    `json:"before,omitempty"`
+	Since       string    `json:"since,omitempty"`
+	DryRun      bool      `json:"dryRun,omitempty"`
+	Downloads   int64     `json:"downloads"`
+	Bytes       int64     `json:"bytes"`
+	Added       time.Time `json:"added"`
+	Updated     time.Time `json:"updated"`
+
+	Segments []apiHistorySegment `json:"segments,omitempty"` // of the channel it's on, see history-segments.go
+}
+
+type apiHistorySegment struct {
+	Since     string    `json:"since,omitempty"`
+	Before    string    `json:"before"`
+	Messages  int64     `json:"messages"`
+	Downloads int64     `json:"downloads"`
+	Reached   time.Time `json:"reached,omitempty"`
+	Done      bool      `json:"done"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type apiDownload struct {
+	Time        time.Time `json:"time"`
+	URL         string    `json:"url"`
+	Path        string    `json:"path"`
+	ServerID    string    `json:"serverID,omitempty"`
+	ChannelID   string    `json:"channelID"`
+	MessageID   string    `json:"messageID,omitempty"`
+	UserID      string    `json:"userID"`
+	ContentType string    `json:"contentType,omitempty"`
+	Filesize    int64     `json:"filesize"`
+	Hash        string    `json:"hash,omitempty"`
+	Thumb       string    `json:"thumb,omitempty"` // dashboard link, when one was made
+	Status      string    `json:"status"`
+	Detail      string    `json:"detail"`
+}
+
+type apiTotals struct {
+	ServerID  string `json:"serverID,omitempty"`
+	Downloads int    `json:"downloads"`
+	Bytes     int64  `json:"bytes"`
+	Records   int    `json:"records"`
+	Queued    int    `json:"queued"`
+}
+
+func startAPI() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", apiDashboard)
+	mux.HandleFunc("/api/status", apiGet(apiHandleStatus))
+	mux.HandleFunc("/api/sources", apiGet(apiHandleSources))
+	mux.HandleFunc("/api/history", apiGet(apiHandleHistory))
+	mux.HandleFunc("/api/downloads/recent", apiGet(apiHandleRecent))
+	mux.HandleFunc("/api/totals", apiGet(apiHandleTotals))
+	mux.HandleFunc("/thumbs/", apiThumbnail)
+	mux.HandleFunc("/metrics", metricsHandler)
+
+	listener, err := net.Listen("tcp", config.APIAddress)
+	if err != nil {
+		log.Println(lg("API", "", color.HiRedString, "Failed to listen on %s:\t%s", config.APIAddress, err))
+		return
+	}
+	if host, _, err := net.SplitHostPort(listener.Addr().String()); err == nil {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			log.Println(lg("API", "", color.HiYellowString,
+				"WARNING: The API has no authentication and is reachable beyond this machine on %s", listener.Addr()))
+		}
+	}
+	log.Println(lg("API", "", color.HiGreenString, "Dashboard available at http://%s/", listener.Addr()))
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println(lg("API", "", color.HiRedString, "Server stopped:\t%s", err))
+		}
+	}()
+}
+
+// Read-only, everything else is refused.
+func apiGet(handler func(r *http.Request) (interface{}, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			apiWriteJSON(w, map[string]string{"error": "method not allowed"}, http.StatusMethodNotAllowed)
+			return
+		}
+		data, code := handler(r)
+		apiWriteJSON(w, data, code)
+	}
+}
+
+func apiWriteJSON(w http.ResponseWriter, data interface{}, code int) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil && config.Debug {
+		log.Println(lg("Debug", "API", color.YellowString, "Failed to write response:\t%s", err))
+	}
+}
+
+func apiHandleStatus(r *http.Request) (interface{}, int) {
+	status := apiStatus{
+		Project:       projectLabel,
+		Version:       projectVersion,
+		Started:       startTime,
+		Uptime:        timeSinceShort(startTime),
+		UptimeSeconds: int64(uptime().Seconds()),
+		HistoryJobs:   historyJobCnt,
+	}
+	if bot != nil {
+		status.Connected = bot.DataReady
+		status.Latency = bot.HeartbeatLatency().Milliseconds()
+		if bot.State != nil {
+			status.Servers = len(bot.State.Guilds)
+		}
+	}
+	if myDB != nil {
+		status.Database = myDB.Name()
+	}
+	status.QueueWaiting, status.QueueRunning = downloadQueue.counts()
+	return status, http.StatusOK
+}
+
+func apiHandleSources(r *http.Request) (interface{}, int) {
+	sources := make([]apiSource, 0)
+	for _, channel := range getAllRegisteredChannels() {
+		serverName, channelName := channelDisplay(channel.ChannelID)
+		sources = append(sources, apiSource{
+			ChannelID:   channel.ChannelID,
+			ChannelName: channelName,
+			ServerName:  serverName,
+			Destination: channel.Source.Destination,
+		})
+	}
+	return sources, http.StatusOK
+}
+
+func apiHistoryJobFrom(channelID string, job historyJob) apiHistoryJob {
+	serverName, channelName := channelDisplay(channelID)
+	history := apiHistoryJob{
+		ChannelID:   channelID,
+		ChannelName: channelName,
+		ServerName:  serverName,
+		Status:      historyStatusLabel(job.Status),
+		State:       historyStatusName(job.Status),
+		OriginUser:  job.OriginUser,
+		Before:      job.TargetBefore,
+		Since:       job.TargetSince,
+		DryRun:      job.DryRun,
+		Downloads:   job.DownloadCount,
+		Bytes:       job.DownloadSize,
+		Added:       job.Added,
+		Updated:     job.Updated,
+	}
+	for _, segment := range historySegmentsSnapshot(channelID) {
+		history.Segments = append(history.Segments, apiHistorySegment{
+			Since:     segment.Since,
+			Before:    segment.Before,
+			Messages:  segment.Messages,
+			Downloads: segment.Downloads,
+			Reached:   segment.Reached,
+			Done:      segment.Done,
+			Error:     segment.Error,
+		})
+	}
+	return history
+}
+
+func apiHandleHistory(r *http.Request) (interface{}, int) {
+	jobs := make([]apiHistoryJob, 0)
+	for _, entry := range historyJobsSnapshot() {
+		jobs = append(jobs, apiHistoryJobFrom(entry.ChannelID, entry.Job))
+	}
+	return jobs, http.StatusOK
+}
+
+func apiHandleRecent(r *http.Request) (interface{}, int) {
+	limit := apiRecentDefault
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return map[string]string{"error": "limit must be a positive number"}, http.StatusBadRequest
+		}
+		limit = min(parsed, apiRecentMax)
+	}
+	downloads := make([]apiDownload, 0)
+	for _, download := range dbRecentDownloads(limit) {
+		thumb := ""
+		if download.ThumbPath != "" {
+			thumb = "/thumbs/" + strconv.Itoa(download.ID)
+		}
+		downloads = append(downloads, apiDownload{
+			Time:        download.Time,
+			URL:         download.URL,
+			Path:        download.Destination,
+			ServerID:    download.GuildID,
+			ChannelID:   download.ChannelID,
+			MessageID:   download.MessageID,
+			UserID:      download.UserID,
+			ContentType: download.ContentType,
+			Filesize:    download.Filesize,
+			Hash:        download.Hash,
+			Thumb:       thumb,
+			Status:      getDownloadStatusShort(download.Status),
+			Detail:      getDownloadStatus(download.Status),
+		})
+	}
+	return downloads, http.StatusOK
+}
+
+func apiHandleTotals(r *http.Request) (interface{}, int) {
+	totals := apiTotals{ServerID: r.URL.Query().Get("server")}
+	if totals.ServerID != "" && !isNumeric(totals.ServerID) {
+		return map[string]string{"error": "server must be a server ID"}, http.StatusBadRequest
+	}
+	downloads := dbDownloadTotals(totals.ServerID)
+	totals.Downloads = downloads.Count
+	totals.Bytes = downloads.Bytes
+	totals.Records, _ = myDB.CountRecords()
+	waiting, running := downloadQueue.counts()
+	totals.Queued = waiting + running
+	return totals, http.StatusOK
+}
+
+// Thumbnails of downloads by record ID, only ones the bot made are recorded.
+func apiThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/thumbs/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if download := dbFindDownloadByID(id); download != nil && download.ThumbPath != "" {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		http.ServeFile(w, r, download.ThumbPath)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func apiDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(apiDashboardHTML))
+}
+
+// Plain page polling the endpoints above, no external assets so it works offline.
+const apiDashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Discord Downloader GO</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #1e1f22; color: #dbdee1; margin: 0; padding: 1.5em; }
+h1 { font-size: 1.3em; margin: 0 0 1em; }
+h2 { font-size: 1.05em; margin: 1.5em 0 .5em; color: #949cf7; }
+.cards { display: flex; flex-wrap: wrap; gap: .75em; }
+.card { background: #2b2d31; border-radius: 6px; padding: .75em 1em; min-width: 9em; }
+.card b { display: block; font-size: 1.3em; color: #fff; }
+table { border-collapse: collapse; width: 100%; font-size: .9em; }
+th, td { text-align: left; padding: .35em .6em; border-bottom: 1px solid #3f4147; white-space: nowrap; }
+td.wrap { white-space: normal; word-break: break-all; }
+th { color: #949ba4; font-weight: normal; }
+img.thumb { display: block; max-width: 64px; max-height: 48px; border-radius: 3px; }
+.bad { color: #f23f43; } .good { color: #23a55a; }
+</style>
+</head>
+<body>
+<h1>Discord Downloader GO <span id="version"></span></h1>
+<div class="cards" id="cards"></div>
+<h2>History Jobs</h2>
+<table><thead><tr><th>Status</th><th>Server</th><th>Channel</th><th>Downloads</th><th>Size</th><th>Updated</th></tr></thead><tbody id="history"></tbody></table>
+<h2>Recent Downloads</h2>
+<table><thead><tr><th></th><th>Time</th><th>Status</th><th>Size</th><th>Type</th><th>Path</th></tr></thead><tbody id="recent"></tbody></table>
+<h2>Sources</h2>
+<table><thead><tr><th>Server</th><th>Channel</th><th>Destination</th></tr></thead><tbody id="sources"></tbody></table>
+<script>
+function esc(s) { const d = document.createElement("div"); d.textContent = s == null ? "" : String(s); return d.innerHTML; }
+function size(n) {
+	if (n == null || n < 0) return "?";
+	const u = ["B", "KB", "MB", "GB", "TB"]; let i = 0;
+	while (n >= 1000 && i < u.length - 1) { n /= 1000; i++; }
+	return n.toFixed(i ? 1 : 0) + " " + u[i];
+}
+function when(t) { const d = new Date(t); return isNaN(d) || d.getFullYear() < 2000 ? "" : d.toLocaleString(); }
+function rows(id, items, fn) { document.getElementById(id).innerHTML = items.map(i => "<tr>" + fn(i).join("") + "</tr>").join(""); }
+function thumb(d) { return "<td>" + (d.thumb ? "<img class=\"thumb\" loading=\"lazy\" src=\"" + esc(d.thumb) + "\">" : "") + "</td>"; }
+function td(v, cls) { return "<td" + (cls ? " class=\"" + cls + "\"" : "") + ">" + esc(v) + "</td>"; }
+async function get(path) { const r = await fetch(path); if (!r.ok) throw new Error(path + ": " + r.status); return r.json(); }
+async function refresh() {
+	try {
+		const [status, totals, history, recent] = await Promise.all([
+			get("/api/status"), get("/api/totals"), get("/api/history"), get("/api/downloads/recent")]);
+		document.getElementById("version").textContent = "v" + status.version;
+		const cards = [
+			["Connection", status.connected ? "Online" : "Offline", status.connected ? "good" : "bad"],
+			["Uptime", status.uptime], ["Servers", status.servers], ["Latency", status.latencyMs + "ms"],
+			["Downloads", totals.downloads.toLocaleString()], ["Total Size", size(totals.bytes)],
+			["Queue", status.queueRunning + " running, " + status.queueWaiting + " waiting"],
+			["History Jobs", status.historyJobs], ["Database", status.database]];
+		document.getElementById("cards").innerHTML = cards.map(c =>
+			"<div class=\"card\">" + esc(c[0]) + "<b class=\"" + (c[2] || "") + "\">" + esc(c[1]) + "</b></div>").join("");
+		rows("history", history, j => [td(j.status), td(j.serverName), td(j.channelName), td(j.downloads), td(size(j.bytes)), td(when(j.updated))]);
+		rows("recent", recent, d => [thumb(d), td(when(d.time)), td(d.status, d.status == "DOWNLOADED" ? "good" : (d.status == "FAILED" ? "bad" : "")), td(size(d.filesize)), td(d.contentType), td(d.path || d.url, "wrap")]);
+	} catch (e) {
+		document.getElementById("cards").innerHTML = "<div class=\"card bad\">" + esc(e) + "</div>";
+	}
+}
+async function refreshSources() {
+	try { rows("sources", await get("/api/sources"), s => [td(s.serverName), td(s.channelName), td(s.destination, "wrap")]); } catch (e) {}
+}
+refresh(); refreshSources();
+setInterval(refresh, 5000); setInterval(refreshSources, 60000);
+</script>
+</body>
+</html>
+`
+
+//#endregion
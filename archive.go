@@ -0,0 +1,723 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+//#region Message Archive
+
+// Sources with an archive keep every message they see, edits included, one JSON line per version in
+// "<destination>/<channelID>.messages.jsonl". Exporting folds that into "<destination>/<channelID>.json",
+// laid out like DiscordChatExporter's JSON so its renderers & viewers can open it offline,
+// with attachments pointing at the files we saved wherever we have them.
+// Threads are archived under their own ID, their parent is the channel's category like the exporter does.
+
+type archiveGuild struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	IconURL string `json:"iconUrl"`
+}
+
+type archiveChannel struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	CategoryID string `json:"categoryId"`
+	Category   string `json:"category"`
+	Name       string `json:"name"`
+	Topic      string `json:"topic"`
+}
+
+type archiveDateRange struct {
+	After  *time.Time `json:"after"`
+	Before *time.Time `json:"before"`
+}
+
+type archiveExport struct {
+	Guild        archiveGuild       `json:"guild"`
+	Channel      archiveChannel     `json:"channel"`
+	DateRange    archiveDateRange   `json:"dateRange"`
+	ExportedAt   time.Time          `json:"exportedAt"`
+	Messages     []*archivedMessage `json:"messages"`
+	MessageCount int                `json:"messageCount"`
+}
+
+type archiveRole struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Color    *string `json:"color"`
+	Position int     `json:"position"`
+}
+
+type archiveUser struct {
+	ID            string        `json:"id"`
+	Name          string        `json:"name"`
+	Discriminator string        `json:"discriminator"`
+	Nickname      string        `json:"nickname"`
+	Color         *string       `json:"color"`
+	IsBot         bool          `json:"isBot"`
+	Roles         []archiveRole `json:"roles,omitempty"`
+	AvatarURL     string        `json:"avatarUrl,omitempty"`
+}
+
+type archiveAttachment struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"` // saved file relative to the export when we have it
+	FileName      string `json:"fileName"`
+	FileSizeBytes int    `json:"fileSizeBytes"`
+	RemoteURL     string `json:"remoteUrl,omitempty"`
+}
+
+type archiveEmbedAuthor struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`
+	IconURL string `json:"iconUrl,omitempty"`
+}
+
+type archiveEmbedImage struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+type archiveEmbedField struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	IsInline bool   `json:"isInline"`
+}
+
+type archiveEmbedFooter struct {
+	Text    string `json:"text"`
+	IconURL string `json:"iconUrl,omitempty"`
+}
+
+type archiveEmbed struct {
+	Title       string              `json:"title"`
+	URL         string              `json:"url,omitempty"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+	Description string              `json:"description"`
+	Color       *string             `json:"color"`
+	Author      *archiveEmbedAuthor `json:"author,omitempty"`
+	Thumbnail   *archiveEmbedImage  `json:"thumbnail,omitempty"`
+	Image       *archiveEmbedImage  `json:"image,omitempty"`
+	Video       *archiveEmbedImage  `json:"video,omitempty"`
+	Images      []archiveEmbedImage `json:"images"`
+	Fields      []archiveEmbedField `json:"fields"`
+	Footer      *archiveEmbedFooter `json:"footer,omitempty"`
+}
+
+type archiveSticker struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Format    string `json:"format"`
+	SourceURL string `json:"sourceUrl"`
+}
+
+type archiveEmoji struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Code       string `json:"code"`
+	IsAnimated bool   `json:"isAnimated"`
+	ImageURL   string `json:"imageUrl"`
+}
+
+type archiveReaction struct {
+	Emoji archiveEmoji  `json:"emoji"`
+	Count int           `json:"count"`
+	Users []archiveUser `json:"users"`
+}
+
+type archiveReference struct {
+	MessageID string `json:"messageId"`
+	ChannelID string `json:"channelId"`
+	GuildID   string `json:"guildId"`
+}
+
+type archiveThread struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Earlier versions of an edited message.
+type archiveEdit struct {
+	TimestampEdited *time.Time `json:"timestampEdited"`
+	Content         string     `json:"content"`
+}
+
+type archivedMessage struct {
+	ID                 string              `json:"id"`
+	Type               string              `json:"type"`
+	Timestamp          time.Time           `json:"timestamp"`
+	TimestampEdited    *time.Time          `json:"timestampEdited"`
+	CallEndedTimestamp *time.Time          `json:"callEndedTimestamp"`
+	IsPinned           bool                `json:"isPinned"`
+	Content            string              `json:"content"`
+	Author             archiveUser         `json:"author"`
+	Attachments        []archiveAttachment `json:"attachments"`
+	Embeds             []archiveEmbed      `json:"embeds"`
+	Stickers           []archiveSticker    `json:"stickers"`
+	Reactions          []archiveReaction   `json:"reactions"`
+	Mentions           []archiveUser       `json:"mentions"`
+	Reference          *archiveReference   `json:"reference,omitempty"`
+	InlineEmojis       []archiveEmoji      `json:"inlineEmojis"`
+	// Ours, renderers skip them
+	ChannelID   string         `json:"channelId"`
+	Thread      *archiveThread `json:"thread,omitempty"` // started from this message
+	EditHistory []archiveEdit  `json:"editHistory,omitempty"`
+}
+
+func archiveColor(color int) *string {
+	if color == 0 {
+		return nil
+	}
+	hex := fmt.Sprintf("#%06X", color)
+	return &hex
+}
+
+func archiveMessageType(messageType discordgo.MessageType) string {
+	switch messageType {
+	case discordgo.MessageTypeRecipientAdd:
+		return "RecipientAdd"
+	case discordgo.MessageTypeRecipientRemove:
+		return "RecipientRemove"
+	case discordgo.MessageTypeCall:
+		return "Call"
+	case discordgo.MessageTypeChannelNameChange:
+		return "ChannelNameChange"
+	case discordgo.MessageTypeChannelIconChange:
+		return "ChannelIconChange"
+	case discordgo.MessageTypeChannelPinnedMessage:
+		return "ChannelPinnedMessage"
+	case discordgo.MessageTypeGuildMemberJoin:
+		return "GuildMemberJoin"
+	case discordgo.MessageTypeThreadCreated:
+		return "ThreadCreated"
+	case discordgo.MessageTypeReply:
+		return "Reply"
+	}
+	return "Default"
+}
+
+func archiveChannelType(channelType discordgo.ChannelType) string {
+	switch channelType {
+	case discordgo.ChannelTypeDM:
+		return "DirectTextChat"
+	case discordgo.ChannelTypeGuildVoice:
+		return "GuildVoiceChat"
+	case discordgo.ChannelTypeGroupDM:
+		return "DirectGroupTextChat"
+	case discordgo.ChannelTypeGuildCategory:
+		return "GuildCategory"
+	case discordgo.ChannelTypeGuildNews:
+		return "GuildNews"
+	case discordgo.ChannelTypeGuildNewsThread:
+		return "GuildNewsThread"
+	case discordgo.ChannelTypeGuildPublicThread:
+		return "GuildPublicThread"
+	case discordgo.ChannelTypeGuildPrivateThread:
+		return "GuildPrivateThread"
+	case discordgo.ChannelTypeGuildStageVoice:
+		return "GuildStageVoice"
+	case discordgo.ChannelTypeGuildForum:
+		return "GuildForum"
+	}
+	return "GuildTextChat"
+}
+
+func newArchiveEmoji(emoji *discordgo.Emoji) archiveEmoji {
+	archived := archiveEmoji{ID: emoji.ID, Name: emoji.Name, Code: emoji.Name, IsAnimated: emoji.Animated}
+	if emoji.ID != "" {
+		extension := "png"
+		if emoji.Animated {
+			extension = "gif"
+		}
+		archived.ImageURL = fmt.Sprintf("https://cdn.discordapp.com/emojis/%s.%s", emoji.ID, extension)
+	} else {
+		var codepoints []string
+		for _, r := range emoji.Name {
+			if r != 0xFE0F {
+				codepoints = append(codepoints, fmt.Sprintf("%x", r))
+			}
+		}
+		archived.ImageURL = "https://cdn.jsdelivr.net/gh/twitter/twemoji@latest/assets/svg/" +
+			strings.Join(codepoints, "-") + ".svg"
+	}
+	return archived
+}
+
+func newArchiveUser(user *discordgo.User, member *discordgo.Member, guildID string) archiveUser {
+	archived := archiveUser{
+		ID:            user.ID,
+		Name:          user.Username,
+		Discriminator: user.Discriminator,
+		Nickname:      user.Username,
+		IsBot:         user.Bot,
+		AvatarURL:     user.AvatarURL(""),
+	}
+	if member != nil {
+		if member.Nick != "" {
+			archived.Nickname = member.Nick
+		}
+		if guild, err := bot.State.Guild(guildID); err == nil {
+			topPosition := -1
+			for _, role := range guild.Roles {
+				if !stringInSlice(role.ID, member.Roles) {
+					continue
+				}
+				archived.Roles = append(archived.Roles, archiveRole{
+					ID: role.ID, Name: role.Name, Color: archiveColor(role.Color), Position: role.Position,
+				})
+				if role.Color != 0 && role.Position > topPosition {
+					archived.Color = archiveColor(role.Color)
+					topPosition = role.Position
+				}
+			}
+		}
+	}
+	return archived
+}
+
+func newArchiveEmbedImage(url string, width int, height int) *archiveEmbedImage {
+	if url == "" {
+		return nil
+	}
+	return &archiveEmbedImage{URL: url, Width: width, Height: height}
+}
+
+func newArchivedMessage(m *discordgo.Message) *archivedMessage {
+	guildID := m.GuildID
+	if guildID == "" { // history messages don't include it
+		if channel, err := bot.State.Channel(m.ChannelID); err == nil {
+			guildID = channel.GuildID
+		}
+	}
+	message := &archivedMessage{
+		ID:              m.ID,
+		Type:            archiveMessageType(m.Type),
+		Timestamp:       m.Timestamp,
+		TimestampEdited: m.EditedTimestamp,
+		IsPinned:        m.Pinned,
+		Content:         m.Content,
+		Attachments:     []archiveAttachment{},
+		Embeds:          []archiveEmbed{},
+		Stickers:        []archiveSticker{},
+		Reactions:       []archiveReaction{},
+		Mentions:        []archiveUser{},
+		InlineEmojis:    []archiveEmoji{},
+		ChannelID:       m.ChannelID,
+	}
+	if m.Author != nil {
+		member := m.Member
+		if member == nil && guildID != "" {
+			member, _ = bot.State.Member(guildID, m.Author.ID)
+		}
+		message.Author = newArchiveUser(m.Author, member, guildID)
+	}
+	for _, attachment := range m.Attachments {
+		message.Attachments = append(message.Attachments, archiveAttachment{
+			ID:            attachment.ID,
+			URL:           attachment.URL,
+			FileName:      attachment.Filename,
+			FileSizeBytes: attachment.Size,
+		})
+	}
+	for _, embed := range m.Embeds {
+		archived := archiveEmbed{
+			Title:       embed.Title,
+			URL:         embed.URL,
+			Timestamp:   embed.Timestamp,
+			Description: embed.Description,
+			Color:       archiveColor(embed.Color),
+			Images:      []archiveEmbedImage{},
+			Fields:      []archiveEmbedField{},
+		}
+		if embed.Author != nil {
+			archived.Author = &archiveEmbedAuthor{Name: embed.Author.Name, URL: embed.Author.URL, IconURL: embed.Author.IconURL}
+		}
+		if embed.Thumbnail != nil {
+			archived.Thumbnail = newArchiveEmbedImage(embed.Thumbnail.URL, embed.Thumbnail.Width, embed.Thumbnail.Height)
+		}
+		if embed.Image != nil {
+			archived.Image = newArchiveEmbedImage(embed.Image.URL, embed.Image.Width, embed.Image.Height)
+			if archived.Image != nil {
+				archived.Images = append(archived.Images, *archived.Image)
+			}
+		}
+		if embed.Video != nil {
+			archived.Video = newArchiveEmbedImage(embed.Video.URL, embed.Video.Width, embed.Video.Height)
+		}
+		for _, field := range embed.Fields {
+			archived.Fields = append(archived.Fields, archiveEmbedField{Name: field.Name, Value: field.Value, IsInline: field.Inline})
+		}
+		if embed.Footer != nil {
+			archived.Footer = &archiveEmbedFooter{Text: embed.Footer.Text, IconURL: embed.Footer.IconURL}
+		}
+		message.Embeds = append(message.Embeds, archived)
+	}
+	for _, sticker := range m.StickerItems {
+		archived := archiveSticker{ID: sticker.ID, Name: sticker.Name, Format: "Png",
+			SourceURL: "https://media.discordapp.net/stickers/" + sticker.ID + ".png"}
+		switch sticker.FormatType {
+		case discordgo.StickerFormatTypeAPNG:
+			archived.Format = "Apng"
+		case discordgo.StickerFormatTypeLottie:
+			archived.Format = "Lottie"
+			archived.SourceURL = "https://discord.com/stickers/" + sticker.ID + ".json"
+		}
+		message.Stickers = append(message.Stickers, archived)
+	}
+	for _, reaction := range m.Reactions {
+		if reaction.Emoji == nil {
+			continue
+		}
+		message.Reactions = append(message.Reactions, archiveReaction{
+			Emoji: newArchiveEmoji(reaction.Emoji),
+			Count: reaction.Count,
+			Users: []archiveUser{}, // listing them is a request per reaction
+		})
+	}
+	for _, user := range m.Mentions {
+		mention := newArchiveUser(user, nil, guildID)
+		mention.AvatarURL = ""
+		message.Mentions = append(message.Mentions, mention)
+	}
+	for _, match := range archiveCustomEmojiRegex.FindAllStringSubmatch(m.Content, -1) {
+		message.InlineEmojis = append(message.InlineEmojis, newArchiveEmoji(&discordgo.Emoji{
+			ID: match[3], Name: match[2], Animated: match[1] == "a",
+		}))
+	}
+	if m.MessageReference != nil && m.MessageReference.MessageID != "" {
+		message.Reference = &archiveReference{
+			MessageID: m.MessageReference.MessageID,
+			ChannelID: m.MessageReference.ChannelID,
+			GuildID:   m.MessageReference.GuildID,
+		}
+	}
+	if m.Thread != nil {
+		message.Thread = &archiveThread{ID: m.Thread.ID, Name: m.Thread.Name}
+	}
+	return message
+}
+
+func archiveMessagesPath(destination string, channelID string) string {
+	return filepath.Join(destination, channelID+".messages.jsonl")
+}
+
+func archiveExportPath(destination string, channelID string) string {
+	return filepath.Join(destination, channelID+".json")
+}
+
+// Versions a channel's messages file holds are read in when it's written to & dropped once it goes quiet,
+// so only channels being archived right now are held.
+const (
+	archiveIndexChannels = 16 // held at once, the least recently written one is dropped
+	archiveIndexIdle     = 5 * time.Minute
+)
+
+type archiveIndexEntry struct {
+	versions map[[sha256.Size]byte]bool
+	used     time.Time
+}
+
+var (
+	archiveIndex            = make(map[string]*archiveIndexEntry) // by messages file
+	archiveMutex            sync.Mutex
+	archiveCustomEmojiRegex = regexp.MustCompile(`<(a?):(\w+):([0-9]+)>`)
+)
+
+// Called with archiveMutex held.
+func archiveVersions(messagesPath string) map[[sha256.Size]byte]bool {
+	if _, err := os.Stat(messagesPath); os.IsNotExist(err) {
+		delete(archiveIndex, messagesPath)
+	}
+	if index, exists := archiveIndex[messagesPath]; exists {
+		index.used = time.Now()
+		return index.versions
+	}
+	if len(archiveIndex) >= archiveIndexChannels {
+		var oldestPath string
+		for path, index := range archiveIndex {
+			if oldestPath == "" || index.used.Before(archiveIndex[oldestPath].used) {
+				oldestPath = path
+			}
+		}
+		delete(archiveIndex, oldestPath)
+	}
+	index := &archiveIndexEntry{versions: make(map[[sha256.Size]byte]bool), used: time.Now()}
+	archiveIndex[messagesPath] = index
+	var expire func()
+	expire = func() {
+		archiveMutex.Lock()
+		defer archiveMutex.Unlock()
+		if archiveIndex[messagesPath] != index {
+			return // dropped already
+		}
+		if idle := time.Since(index.used); idle < archiveIndexIdle {
+			time.AfterFunc(archiveIndexIdle-idle, expire)
+			return
+		}
+		delete(archiveIndex, messagesPath)
+	}
+	time.AfterFunc(archiveIndexIdle, expire)
+	readArchiveLines(messagesPath, func(existing []byte) {
+		var existingMessage archivedMessage
+		if json.Unmarshal(existing, &existingMessage) == nil {
+			index.versions[existingMessage.versionKey()] = true
+		}
+	})
+	return index.versions
+}
+
+// What makes a version, reactions & the author's member details change without the message being edited
+// and history doesn't include them anyway.
+func (message *archivedMessage) versionKey() [sha256.Size]byte {
+	fields := []string{message.ID, message.Content}
+	if message.TimestampEdited != nil {
+		fields = append(fields, message.TimestampEdited.UTC().Format(time.RFC3339Nano))
+	} else {
+		fields = append(fields, "")
+	}
+	for _, attachment := range message.Attachments {
+		fields = append(fields, attachment.ID, attachment.FileName)
+	}
+	return sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+}
+
+// Every version of the message goes in once, seeing it again unedited (history reruns) adds nothing.
+func archiveMessage(m *discordgo.Message, archive configurationSourceArchive) {
+	if archive.Destination == "" || m == nil {
+		return
+	}
+	message := newArchivedMessage(m)
+	line, err := json.Marshal(message)
+	if err != nil {
+		log.Println(lg("Archive", "", color.HiRedString, "Failed to encode message %s:\t%s", m.ID, err))
+		return
+	}
+	key := message.versionKey()
+	messagesPath := archiveMessagesPath(archive.Destination, m.ChannelID)
+
+	archiveMutex.Lock()
+	defer archiveMutex.Unlock()
+
+	keys := archiveVersions(messagesPath)
+	if keys[key] {
+		return
+	}
+
+	if err := os.MkdirAll(archive.Destination, 0755); err != nil {
+		log.Println(lg("Archive", "", color.HiRedString, "Error while creating archive folder \"%s\": %s",
+			archive.Destination, err))
+		return
+	}
+	f, err := os.OpenFile(messagesPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		log.Println(lg("Archive", "", color.HiRedString, "Failed to open \"%s\":\t%s", messagesPath, err))
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		log.Println(lg("Archive", "", color.HiRedString, "Failed to write \"%s\":\t%s", messagesPath, err))
+		return
+	}
+	keys[key] = true
+}
+
+func readArchiveLines(messagesPath string, fn func(line []byte)) error {
+	f, err := os.Open(messagesPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			fn(scanner.Bytes())
+		}
+	}
+	return scanner.Err()
+}
+
+// Discord's CDN links expire, the part before the query is what stays the same.
+func archiveURLKey(link string) string {
+	if parsedURL, err := url.Parse(link); err == nil {
+		if host := parsedURL.Hostname(); host == "cdn.discordapp.com" || host == "media.discordapp.net" {
+			parsedURL.RawQuery = ""
+			return parsedURL.String()
+		}
+	}
+	return link
+}
+
+// Where the channel's files were saved, by attachment ID & link.
+func archiveSavedFiles(channelID string) map[string]string {
+	saved := make(map[string]string)
+	if myDB == nil {
+		return saved
+	}
+	for _, download := range dbFindDownloadsByChannelID(channelID) {
+		if isDownloadComplete(download.Status) && download.Destination != "" {
+			if download.AttachmentID != "" {
+				saved[download.AttachmentID] = download.Destination
+			}
+			saved[archiveURLKey(download.URL)] = download.Destination
+		}
+	}
+	return saved
+}
+
+// Writes the exporter layout from everything archived for the channel, returning its path & message count.
+func exportArchive(archive configurationSourceArchive, channelID string) (string, int, error) {
+	messagesPath := archiveMessagesPath(archive.Destination, channelID)
+	exportPath := archiveExportPath(archive.Destination, channelID)
+
+	// Versions in the order they were archived, the last one is current
+	versions := make(map[string][]*archivedMessage)
+	archiveMutex.Lock()
+	err := readArchiveLines(messagesPath, func(line []byte) {
+		var message archivedMessage
+		if json.Unmarshal(line, &message) == nil && message.ID != "" {
+			versions[message.ID] = append(versions[message.ID], &message)
+		}
+	})
+	archiveMutex.Unlock()
+	if err != nil {
+		return exportPath, 0, err
+	}
+
+	saved := archiveSavedFiles(channelID)
+	exportDir, _ := filepath.Abs(archive.Destination)
+	localPath := func(remote string, ids ...string) string {
+		destination := ""
+		for _, id := range ids {
+			if destination = saved[id]; destination != "" {
+				break
+			}
+		}
+		if destination == "" {
+			destination = saved[archiveURLKey(remote)]
+		}
+		if destination == "" {
+			return ""
+		}
+		if absolute, err := filepath.Abs(destination); err == nil {
+			if relative, err := filepath.Rel(exportDir, absolute); err == nil {
+				return filepath.ToSlash(relative)
+			}
+			return filepath.ToSlash(absolute)
+		}
+		return filepath.ToSlash(destination)
+	}
+
+	messages := make([]*archivedMessage, 0, len(versions))
+	for _, list := range versions {
+		message := list[len(list)-1]
+		seenEdits := map[string]bool{}
+		for _, version := range list[:len(list)-1] {
+			if version.Content == message.Content {
+				continue
+			}
+			editKey := version.Content
+			if version.TimestampEdited != nil {
+				editKey += version.TimestampEdited.String()
+			}
+			if !seenEdits[editKey] {
+				seenEdits[editKey] = true
+				message.EditHistory = append(message.EditHistory, archiveEdit{version.TimestampEdited, version.Content})
+			}
+		}
+		sort.SliceStable(message.EditHistory, func(i, j int) bool {
+			a, b := message.EditHistory[i].TimestampEdited, message.EditHistory[j].TimestampEdited
+			return a == nil && b != nil || a != nil && b != nil && a.Before(*b)
+		})
+		for i, attachment := range message.Attachments {
+			if local := localPath(attachment.URL, attachment.ID); local != "" {
+				message.Attachments[i].RemoteURL = attachment.URL
+				message.Attachments[i].URL = local
+			}
+		}
+		for i, embed := range message.Embeds {
+			for _, image := range []*archiveEmbedImage{embed.Thumbnail, embed.Image, embed.Video} {
+				if image != nil {
+					if local := localPath(image.URL); local != "" {
+						image.URL = local
+					}
+				}
+			}
+			for j := range embed.Images {
+				if local := localPath(embed.Images[j].URL); local != "" {
+					message.Embeds[i].Images[j].URL = local
+				}
+			}
+		}
+		for i, sticker := range message.Stickers {
+			if local := localPath(sticker.SourceURL); local != "" {
+				message.Stickers[i].SourceURL = local
+			}
+		}
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool { // snowflakes, oldest first
+		a, b := messages[i].ID, messages[j].ID
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+
+	export := archiveExport{
+		Channel:      archiveChannel{ID: channelID, Type: "GuildTextChat", Name: channelID},
+		ExportedAt:   time.Now(),
+		Messages:     messages,
+		MessageCount: len(messages),
+	}
+	if channel, err := getChannel(channelID); err == nil && channel != nil {
+		export.Channel.Type = archiveChannelType(channel.Type)
+		export.Channel.Name = channel.Name
+		export.Channel.Topic = channel.Topic
+		if channel.ParentID != "" {
+			export.Channel.CategoryID = channel.ParentID
+			export.Channel.Category = getChannelLabel(channel.ParentID, nil)
+		}
+		if channel.GuildID != "" {
+			export.Guild.ID = channel.GuildID
+			export.Guild.Name = getServerLabel(channel.GuildID)
+			if guild, err := bot.State.Guild(channel.GuildID); err == nil {
+				export.Guild.IconURL = guild.IconURL("")
+			}
+		} else {
+			export.Guild = archiveGuild{ID: "0", Name: "Direct Messages"}
+		}
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return exportPath, 0, err
+	}
+	tempPath := exportPath + ".tmp"
+	if err = os.WriteFile(tempPath, data, 0644); err != nil {
+		return exportPath, 0, err
+	}
+	if err = os.Rename(tempPath, exportPath); err != nil {
+		os.Remove(tempPath)
+		return exportPath, 0, err
+	}
+	return exportPath, len(messages), nil
+}
+
+//#endregion
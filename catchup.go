@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+//#region History Catch-up
+
+// The newest live message handled in each bound channel is kept in cache/checkpoints.json. On startup & when the
+// gateway reconnects without resuming, history jobs run from those messages to the present for what was missed.
+// Off by default, and never reaching back further than historyCatchUpMaxAge so a long outage isn't a full backfill.
+
+const historyCatchUpOrigin = "CATCHUP"
+
+var (
+	checkpointsMutex  sync.Mutex
+	checkpoints       map[string]string // channel > newest message
+	checkpointsDirty  bool
+	gatewayDisconnect lockedTime // handlers run on their own goroutines
+)
+
+func snowflakeNewer(a string, b string) bool {
+	a64, _ := strconv.ParseInt(a, 10, 64)
+	b64, _ := strconv.ParseInt(b, 10, 64)
+	return a64 > b64
+}
+
+// Called with checkpointsMutex held.
+func loadCheckpoints() {
+	if checkpoints != nil {
+		return
+	}
+	checkpoints = make(map[string]string)
+	if data, err := os.ReadFile(pathCacheCheckpoints); err == nil {
+		if err = json.Unmarshal(data, &checkpoints); err != nil {
+			log.Println(lg("History", "Catch-up", color.HiRedString, "Failed to unmarshal json for checkpoints:\t%s", err))
+			checkpoints = make(map[string]string)
+		}
+	}
+}
+
+func recordCheckpoint(channelID string, messageID string) {
+	checkpointsMutex.Lock()
+	defer checkpointsMutex.Unlock()
+	loadCheckpoints()
+	if snowflakeNewer(messageID, checkpoints[channelID]) {
+		checkpoints[channelID] = messageID
+		checkpointsDirty = true
+	}
+}
+
+func saveCheckpoints() {
+	checkpointsMutex.Lock()
+	defer checkpointsMutex.Unlock()
+	if !checkpointsDirty {
+		return
+	}
+	data, err := json.Marshal(checkpoints)
+	if err != nil {
+		log.Println(lg("History", "Catch-up", color.HiRedString, "Failed to format checkpoints into json:\t%s", err))
+		return
+	}
+	if err = os.MkdirAll(pathCache, 0755); err != nil {
+		log.Println(lg("History", "Catch-up", color.HiRedString, "Error while creating cache folder \"%s\": %s", pathCache, err))
+		return
+	}
+	tmpPath := pathCacheCheckpoints + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0644); err == nil {
+		err = os.Rename(tmpPath, pathCacheCheckpoints)
+	}
+	if err != nil {
+		log.Println(lg("History", "Catch-up", color.HiRedString, "Failed to save checkpoints:\t%s", err))
+		return
+	}
+	checkpointsDirty = false
+}
+
+// Queues a history job from each checkpoint to the present. Channel info only says what's newer
+// at startup, after a reconnect it may be stale so every channel is checked.
+func queueCatchUpHistory(reason string, checkLatest bool) {
+	checkpointsMutex.Lock()
+	loadCheckpoints()
+	pending := make(map[string]string, len(checkpoints))
+	for channelID, messageID := range checkpoints {
+		pending[channelID] = messageID
+	}
+	checkpointsMutex.Unlock()
+
+	oldest := fmt.Sprint((time.Now().Add(-time.Duration(config.HistoryCatchUpMaxAge)*time.Hour).UnixMilli() - discordEpoch) << 22)
+	queued, capped := 0, 0
+	for channelID, messageID := range pending {
+		channel, err := bot.State.Channel(channelID)
+		if err != nil {
+			continue // gone or out of reach
+		}
+		sourceConfig := getSource(&discordgo.Message{ChannelID: channelID, GuildID: channel.GuildID})
+		if sourceConfig == emptySourceConfig || sourceConfig.HistoryCatchUp == nil || !*sourceConfig.HistoryCatchUp {
+			continue
+		}
+		if checkLatest && channel.LastMessageID != "" && !snowflakeNewer(channel.LastMessageID, messageID) {
+			continue // nothing was missed
+		}
+		if job, exists := getHistoryJob(channelID); exists &&
+			(job.Status == historyStatusWaiting || job.Status == historyStatusRunning || job.Status == historyStatusAbortRequested) {
+			continue // already covered
+		}
+		if snowflakeNewer(oldest, messageID) {
+			messageID = oldest
+			capped++
+		}
+		setHistoryJob(channelID, historyJob{
+			Status:          historyStatusWaiting,
+			OriginChannel:   historyCatchUpOrigin,
+			OriginUser:      historyCatchUpOrigin,
+			TargetChannelID: channelID,
+			TargetSince:     messageID,
+			Updated:         time.Now(),
+			Added:           time.Now(),
+		})
+		queued++
+	}
+	if queued > 0 {
+		log.Println(lg("History", "Catch-up", color.HiCyanString,
+			"Queued catch-up history for %d channel%s after %s", queued, pluralS(queued), reason))
+	}
+	if capped > 0 {
+		log.Println(lg("History", "Catch-up", color.HiYellowString,
+			"Catch-up for %d channel%s only goes back %d hours, older messages need a history command",
+			capped, pluralS(capped), config.HistoryCatchUpMaxAge))
+	}
+}
+
+//#region Gateway Events
+
+func gatewayDisconnected(s *discordgo.Session, d *discordgo.Disconnect) {
+	gatewayDisconnect.set(time.Now())
+}
+
+// A new session rather than a resumed one, so events during the outage weren't replayed.
+func gatewayReady(s *discordgo.Session, r *discordgo.Ready) {
+	disconnected := gatewayDisconnect.swap(time.Time{})
+	if disconnected.IsZero() {
+		return
+	}
+	outage := timeSinceShort(disconnected)
+	go queueCatchUpHistory("reconnecting ("+outage+" outage)", false)
+}
+
+//#endregion
+
+//#endregion
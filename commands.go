@@ -10,7 +10,9 @@ import (
 
 	"github.com/Necroforger/dgrouter/exrouter"
 	"github.com/bwmarrin/discordgo"
+	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
+	"github.com/hako/durafmt"
 )
 
 // TODO: Implement this for more?
@@ -124,6 +126,7 @@ func handleCommands() *exrouter.Route {
 			if !hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
 				log.Println(lg("Command", "Status", color.HiRedString, fmtBotSendPerm, ctx.Msg.ChannelID))
 			} else {
+				queuePending, queueRunning := downloadQueue.counts()
 				message := fmt.Sprintf("• **Uptime —** %s\n"+
 					"• **Started at —** %s\n"+
 					"• **Joined Servers —** %d\n"+
@@ -132,6 +135,7 @@ func handleCommands() *exrouter.Route {
 					"• **Bound Servers —** %d\n"+
 					"• **Bound Users —** %d\n"+
 					"• **Admin Channels —** %d\n"+
+					"• **Download Queue —** %d running, %d waiting\n"+
 					"• **Heartbeat Latency —** %dms",
 					timeSince(startTime),
 					startTime.Format("03:04:05pm on Monday, January 2, 2006 (MST)"),
@@ -141,6 +145,7 @@ func handleCommands() *exrouter.Route {
 					getBoundServersCount(),
 					getBoundUsersCount(),
 					len(config.AdminChannels),
+					queueRunning, queuePending,
 					bot.HeartbeatLatency().Milliseconds(),
 				)
 				if sourceConfig := getSource(ctx.Msg); sourceConfig != emptySourceConfig {
@@ -167,6 +172,11 @@ func handleCommands() *exrouter.Route {
 							formatNumber(int64(dbDownloadCount())),
 							formatNumber(int64(dbDownloadCountByChannel(ctx.Msg.ChannelID))),
 						)
+						if ctx.Msg.GuildID != "" {
+							serverTotals := dbDownloadTotals(ctx.Msg.GuildID)
+							content += fmt.Sprintf("\n• **Downloads in this Server —** %s (%s)",
+								formatNumber(int64(serverTotals.Count)), humanize.Bytes(uint64(serverTotals.Bytes)))
+						}
 						//TODO: Count in channel by users
 						if _, err := replyEmbed(ctx.Msg, "Command — Stats", content); err != nil {
 							log.Println(lg("Command", "Stats", color.HiRedString, cmderrSendFailure,
@@ -199,6 +209,11 @@ func handleCommands() *exrouter.Route {
 			var beforeID string
 			var since string
 			var sinceID string
+			var dryRun bool = false
+
+			var scopeServers, scopeCategories, scopeUsers []string
+			var confirming bool = false
+			var userSource string
 
 			if len(bot.State.Guilds) == 0 {
 				log.Println(lg("Command", "History", color.HiRedString, "WARNING: Something is wrong with your Discord cache. This can result in missed channels..."))
@@ -209,6 +224,40 @@ func handleCommands() *exrouter.Route {
 				if argKey == 0 { // skip head
 					continue
 				}
+				//SUBCOMMAND: schedule list, the rest of the args are its own
+				if strings.ToLower(argValue) == "schedule" || strings.ToLower(argValue) == "schedules" {
+					shouldProcess = false
+					//MARKER: history schedules list
+					schedules := historySchedulesSnapshot()
+					output := fmt.Sprintf("**HISTORY SCHEDULES** ~ `%d channel%s`\n", len(schedules), pluralS(len(schedules)))
+					for _, schedule := range schedules {
+						scheduleSourceName, scheduleChannelName := channelDisplay(schedule.ChannelID)
+						due := "_invalid: " + schedule.Error + "_"
+						if schedule.Error == "" {
+							due = "next in " + timeUntilShort(schedule.Next)
+							if schedule.Next.IsZero() {
+								due = "never due"
+							}
+							if !schedule.Last.IsZero() {
+								due += ", last " + timeSinceShort(schedule.Last) + " ago"
+							}
+						}
+						newline := fmt.Sprintf("• `%s` `%s - %s`, %s\n",
+							schedule.Spec, scheduleSourceName, scheduleChannelName, due)
+						if len(output)+len(newline) > limitMsg {
+							safeReply(ctx, output)
+							output = ""
+						}
+						output += newline
+					}
+					if len(schedules) == 0 {
+						output += "_No sources have a `historySchedule` set._"
+					}
+					safeReply(ctx, output)
+					log.Println(lg("Command", "History", color.HiCyanString, "%s requested history schedules.",
+						getUserIdentifier(*ctx.Msg.Author)))
+					break
+				}
 				//SUBCOMMAND: cancel
 				if strings.Contains(strings.ToLower(argValue), "cancel") ||
 					strings.Contains(strings.ToLower(argValue), "stop") {
@@ -271,13 +320,26 @@ func handleCommands() *exrouter.Route {
 
 					// Following
 					output = ""
-					for pair := historyJobs.Oldest(); pair != nil; pair = pair.Next() {
-						channelID := pair.Key
-						job := pair.Value
+					for _, entry := range historyJobsSnapshot() {
+						channelID := entry.ChannelID
+						job := entry.Job
 						jobSourceName, jobChannelName := channelDisplay(channelID)
+						jobStatus := historyStatusLabel(job.Status)
+						if job.DryRun {
+							jobStatus += " (Dry Run)"
+						}
+						if segments := historySegmentsSnapshot(channelID); job.Status == historyStatusRunning && len(segments) > 1 {
+							done := 0
+							for _, segment := range segments {
+								if segment.Done {
+									done++
+								}
+							}
+							jobStatus += fmt.Sprintf(" (%d/%d segments done)", done, len(segments))
+						}
 
 						newline := fmt.Sprintf("• _%s_ (%s) `%s - %s`, `updated %s ago, added %s ago`\n",
-							historyStatusLabel(job.Status), job.OriginUser, jobSourceName, jobChannelName,
+							jobStatus, job.OriginUser, jobSourceName, jobChannelName,
 							timeSinceShort(job.Updated),
 							timeSinceShort(job.Added))
 					redothismath: // bad way but dont care right now
@@ -290,7 +352,7 @@ func handleCommands() *exrouter.Route {
 						output += newline
 						log.Println(lg("Command", "History", color.HiCyanString,
 							fmt.Sprintf("%s (%s) %s - %s, updated %s ago, added %s ago",
-								historyStatusLabel(job.Status), job.OriginUser, jobSourceName, jobChannelName,
+								jobStatus, job.OriginUser, jobSourceName, jobChannelName,
 								timeSinceShort(job.Updated),
 								timeSinceShort(job.Added)))) // no batching
 					}
@@ -301,6 +363,16 @@ func handleCommands() *exrouter.Route {
 					// done
 					log.Println(lg("Command", "History", color.HiRedString, "%s requested statuses of history jobs.",
 						getUserIdentifier(*ctx.Msg.Author)))
+				} else if strings.ToLower(argValue) == "--dry-run" { // dry run key
+					dryRun = true
+				} else if strings.ToLower(argValue) == "confirm" { //SUBCOMMAND: confirm scoped targets
+					confirming = true
+				} else if strings.HasPrefix(strings.ToLower(argValue), "--server=") { // scoped targets, see history-targets.go
+					scopeServers = append(scopeServers, strings.Split(argValue[len("--server="):], ",")...)
+				} else if strings.HasPrefix(strings.ToLower(argValue), "--category=") {
+					scopeCategories = append(scopeCategories, strings.Split(argValue[len("--category="):], ",")...)
+				} else if strings.HasPrefix(strings.ToLower(argValue), "--user-source=") {
+					scopeUsers = append(scopeUsers, strings.Split(argValue[len("--user-source="):], ",")...)
 				} else if strings.Contains(strings.ToLower(argValue), "--before=") { // before key
 					before = strings.ReplaceAll(strings.ToLower(argValue), "--before=", "")
 					if isDate(before) {
@@ -433,6 +505,88 @@ func handleCommands() *exrouter.Route {
 			}
 			//#endregion
 
+			//#region Scoped Targets
+			if confirming {
+				pending, exists := takeHistoryPending(ctx.Msg.Author.ID)
+				if !exists {
+					safeReply(ctx, fmt.Sprintf("Nothing to confirm, scoped history targets expire after %s.",
+						shortenTime(durafmt.ParseShort(historyConfirmTimeout).String())))
+					return
+				}
+				channels = pending.Channels
+				userSource = pending.UserID
+				beforeID = pending.Before
+				sinceID = pending.Since
+				dryRun = pending.DryRun
+			} else if len(scopeServers) > 0 || len(scopeCategories) > 0 || len(scopeUsers) > 0 {
+				if !isBotAdmin(ctx.Msg) {
+					log.Println(lg("Command", "History", color.CyanString,
+						"%s tried to handle scoped history but lacked proper permission.", getUserIdentifier(*ctx.Msg.Author)))
+					if _, err := replyEmbed(ctx.Msg, "Command — History", cmderrLackingBotAdminPerms); err != nil {
+						log.Println(lg("Command", "History", color.HiRedString, cmderrSendFailure,
+							getUserIdentifier(*ctx.Msg.Author), err))
+					}
+					return
+				}
+				if len(scopeUsers) > 1 {
+					safeReply(ctx, "Only one `--user-source` at a time, jobs run for a single user source.")
+					return
+				}
+				if len(scopeUsers) == 1 {
+					userSource = scopeUsers[0]
+					if !isUserSource(userSource) {
+						safeReply(ctx, fmt.Sprintf("`%s` isn't a user source in the settings.", userSource))
+						return
+					}
+				}
+				scope := expandHistoryScope(scopeServers, scopeCategories, userSource)
+				channels = append(channels, scope.Channels...)
+				if shouldProcess && !shouldAbort { // queueing waits for confirmation
+					summary := scope.describe()
+					if len(scope.Errors) > 0 {
+						summary += "\n_" + strings.Join(scope.Errors, ", ") + "_"
+					}
+					if len(channels) == 0 {
+						safeReply(ctx, "**No channels to run history on.**\n"+summary)
+						return
+					}
+					estimate, sampled := estimateHistoryMessages(channels, beforeID, sinceID)
+					if sampled > 0 {
+						summary += fmt.Sprintf("\n~%s messages estimated, from %d sampled channel%s",
+							formatNumber(estimate), sampled, pluralS(sampled))
+					}
+					if userSource != "" {
+						summary += fmt.Sprintf("\nRunning for user source `%s`", userSource)
+					}
+					if dryRun {
+						summary += "\n**Dry Run**"
+					}
+					setHistoryPending(ctx.Msg.Author.ID, historyPendingTargets{
+						Channels: channels,
+						UserID:   userSource,
+						Before:   beforeID,
+						Since:    sinceID,
+						DryRun:   dryRun,
+					})
+					prefix := strings.SplitN(ctx.Msg.Content, ctx.Args.Get(0), 2)[0]
+					summary += fmt.Sprintf("\n\nSend `%s%s confirm` within %s to queue these.", prefix, ctx.Args.Get(0),
+						shortenTime(durafmt.ParseShort(historyConfirmTimeout).String()))
+					if _, err := replyEmbed(ctx.Msg, "Command — History", summary); err != nil {
+						log.Println(lg("Command", "History", color.HiRedString, cmderrSendFailure,
+							getUserIdentifier(*ctx.Msg.Author), err))
+					}
+					log.Println(lg("Command", "History", color.CyanString,
+						"%s requested history for %d scoped channels, waiting for confirmation...",
+						getUserIdentifier(*ctx.Msg.Author), len(channels)))
+					return
+				}
+				if len(channels) == 0 {
+					safeReply(ctx, "**No channels in scope.**")
+					return
+				}
+			}
+			//#endregion
+
 			// Local
 			if len(channels) == 0 {
 				channels = append(channels, ctx.Msg.ChannelID)
@@ -473,14 +627,17 @@ func handleCommands() *exrouter.Route {
 				} else { // IS BOT ADMIN
 					if shouldProcess { // PROCESS TREE; MARKER: history queue via cmd
 						if shouldAbort { // ABORT
-							if job, exists := historyJobs.Get(channel); exists &&
-								(job.Status == historyStatusRunning || job.Status == historyStatusWaiting) {
-								// DOWNLOADING, ABORTING
-								job.Status = historyStatusAbortRequested
-								if job.Status == historyStatusWaiting {
-									job.Status = historyStatusAbortCompleted
+							aborting := false
+							updateHistoryJob(channel, func(job *historyJob) {
+								if job.Status == historyStatusRunning || job.Status == historyStatusWaiting {
+									aborting = true
+									job.Status = historyStatusAbortRequested
+									if job.Status == historyStatusWaiting {
+										job.Status = historyStatusAbortCompleted
+									}
 								}
-								historyJobs.Set(channel, job)
+							})
+							if aborting { // DOWNLOADING, ABORTING
 								log.Println(lg("Command", "History", color.CyanString,
 									"%s cancelled history cataloging for \"%s\"",
 									getUserIdentifier(*ctx.Msg.Author), channel))
@@ -490,7 +647,7 @@ func handleCommands() *exrouter.Route {
 									getUserIdentifier(*ctx.Msg.Author), channel))
 							}
 						} else { // RUN
-							if job, exists := historyJobs.Get(channel); !exists ||
+							if job, exists := getHistoryJob(channel); !exists ||
 								(job.Status != historyStatusRunning && job.Status != historyStatusAbortRequested) {
 								job.Status = historyStatusWaiting
 								job.OriginChannel = ctx.Msg.ChannelID
@@ -499,9 +656,12 @@ func handleCommands() *exrouter.Route {
 								job.TargetChannelID = channel
 								job.TargetBefore = beforeID
 								job.TargetSince = sinceID
+								job.TargetUserID = userSource
+								job.DryRun = dryRun
+								job.DownloadCount, job.DownloadSize = 0, 0
 								job.Updated = time.Now()
 								job.Added = time.Now()
-								historyJobs.Set(channel, job)
+								setHistoryJob(channel, job)
 							} else { // ALREADY RUNNING
 								log.Println(lg("Command", "History", color.CyanString,
 									"%s tried using history command but history is already running for %s...",
@@ -513,8 +673,10 @@ func handleCommands() *exrouter.Route {
 						if all {
 							myDB.Close()
 							time.Sleep(1 * time.Second)
-							if _, err := os.Stat(pathDatabaseBase); err == nil {
-								err = os.RemoveAll(pathDatabaseBase)
+							if _, err := os.Stat(myDB.Path()); err == nil {
+								err = os.RemoveAll(myDB.Path())
+								os.Remove(myDB.Path() + "-wal") // sqlite
+								os.Remove(myDB.Path() + "-shm")
 								if err != nil {
 									log.Println(lg("Command", "History", color.HiRedString,
 										"Encountered error deleting database folder:\t%s", err))
@@ -571,11 +733,127 @@ func handleCommands() *exrouter.Route {
 				//#endregion
 			}
 			if shouldWipeDB {
-				cachedDownloadID = dbDownloadCount()
+				cachedDownloadID.Store(int64(dbDownloadCount()))
 			}
 		}
 	}).Cat("Admin").Alias("catalog", "cache").Desc("Catalogs history for this channel")
 
+	go router.On("archive", func(ctx *exrouter.Context) {
+		if isCommandableChannel(ctx.Msg) {
+			if !isBotAdmin(ctx.Msg) {
+				if !hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
+					log.Println(lg("Command", "Archive", color.HiRedString, fmtBotSendPerm, ctx.Msg.ChannelID))
+				} else {
+					if _, err := replyEmbed(ctx.Msg, "Command — Archive", cmderrLackingBotAdminPerms); err != nil {
+						log.Println(lg("Command", "Archive", color.HiRedString,
+							cmderrSendFailure, getUserIdentifier(*ctx.Msg.Author), err))
+					}
+				}
+				log.Println(lg("Command", "Archive", color.HiCyanString,
+					"%s tried to export an archive but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+				return
+			}
+			// Channel IDs, or this channel
+			var channels []string
+			for argKey, argValue := range ctx.Args {
+				if argKey == 0 { // skip head
+					continue
+				}
+				for _, target := range strings.Split(argValue, ",") {
+					if isNumeric(target) {
+						channels = append(channels, target)
+					}
+				}
+			}
+			if len(channels) == 0 {
+				channels = append(channels, ctx.Msg.ChannelID)
+			}
+			var output string
+			for _, channelID := range channels {
+				sourceConfig := getSource(&discordgo.Message{ChannelID: channelID})
+				if sourceConfig == emptySourceConfig || sourceConfig.Archive == nil {
+					output += fmt.Sprintf("• `%s` isn't archived\n", channelID)
+					continue
+				}
+				exportPath, count, err := exportArchive(*sourceConfig.Archive, channelID)
+				if err != nil {
+					log.Println(lg("Command", "Archive", color.HiRedString,
+						"Failed to export archive of %s to \"%s\":\t%s", channelID, exportPath, err))
+					output += fmt.Sprintf("• `%s` failed to export: `%s`\n", channelID, err)
+					continue
+				}
+				log.Println(lg("Command", "Archive", color.HiCyanString,
+					"%s exported %d archived messages of %s to \"%s\"",
+					getUserIdentifier(*ctx.Msg.Author), count, channelID, exportPath))
+				output += fmt.Sprintf("• `%s` exported %s messages to `%s`\n", channelID, formatNumber(int64(count)), exportPath)
+			}
+			if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
+				if _, err := replyEmbed(ctx.Msg, "Command — Archive", output); err != nil {
+					log.Println(lg("Command", "Archive", color.HiRedString,
+						cmderrSendFailure, getUserIdentifier(*ctx.Msg.Author), err))
+				}
+			} else {
+				log.Println(lg("Command", "Archive", color.HiRedString, fmtBotSendPerm, ctx.Msg.ChannelID))
+			}
+		}
+	}).Cat("Admin").Alias("export").Desc("Exports the message archive of this channel")
+
+	go router.On("gallery", func(ctx *exrouter.Context) {
+		if isCommandableChannel(ctx.Msg) {
+			if !isBotAdmin(ctx.Msg) {
+				if !hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
+					log.Println(lg("Command", "Gallery", color.HiRedString, fmtBotSendPerm, ctx.Msg.ChannelID))
+				} else {
+					if _, err := replyEmbed(ctx.Msg, "Command — Gallery", cmderrLackingBotAdminPerms); err != nil {
+						log.Println(lg("Command", "Gallery", color.HiRedString,
+							cmderrSendFailure, getUserIdentifier(*ctx.Msg.Author), err))
+					}
+				}
+				log.Println(lg("Command", "Gallery", color.HiCyanString,
+					"%s tried to build galleries but lacked bot admin perms.", getUserIdentifier(*ctx.Msg.Author)))
+				return
+			}
+			full := false
+			for argKey, argValue := range ctx.Args {
+				if argKey == 0 { // skip head
+					continue
+				}
+				if strings.ToLower(argValue) == "--full" {
+					full = true
+				}
+			}
+			targets := getGalleryTargets()
+			output := ""
+			if len(targets) == 0 {
+				output = "No sources have a gallery."
+			}
+			for _, target := range targets {
+				buildT := time.Now()
+				result, err := buildGallery(target, full)
+				if err != nil {
+					log.Println(lg("Command", "Gallery", color.HiRedString,
+						"Failed to build gallery \"%s\":\t%s", target.Gallery.Destination, err))
+					output += fmt.Sprintf("• `%s` failed to build: `%s`\n", target.Gallery.Destination, err)
+					continue
+				}
+				log.Println(lg("Command", "Gallery", color.HiCyanString,
+					"%s built gallery \"%s\", %d new, %d removed, %d total\t(took %s)",
+					getUserIdentifier(*ctx.Msg.Author), target.Gallery.Destination,
+					result.Added, result.Removed, result.Total, timeSinceShort(buildT)))
+				output += fmt.Sprintf("• `%s` — %s new, %s removed, %s total\n", target.Gallery.Destination,
+					formatNumber(int64(result.Added)), formatNumber(int64(result.Removed)), formatNumber(int64(result.Total)))
+			}
+			if hasPerms(ctx.Msg.ChannelID, discordgo.PermissionSendMessages) {
+				if _, err := replyEmbed(ctx.Msg, "Command — Gallery", output); err != nil {
+					log.Println(lg("Command", "Gallery", color.HiRedString,
+						cmderrSendFailure, getUserIdentifier(*ctx.Msg.Author), err))
+				}
+			} else {
+				log.Println(lg("Command", "Gallery", color.HiRedString, fmtBotSendPerm, ctx.Msg.ChannelID))
+			}
+		}
+	}).Cat("Admin").Alias("galleries").Desc("Builds the galleries of new downloads, --full to start over")
+
 	go router.On("exit", func(ctx *exrouter.Context) {
 		if isCommandableChannel(ctx.Msg) {
 			if isBotAdmin(ctx.Msg) {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hako/durafmt"
@@ -68,3 +69,34 @@ func timeSince(input time.Time) string {
 func timeSinceShort(input time.Time) string {
 	return shortenTime(durafmt.ParseShort(time.Since(input)).String())
 }
+
+func timeUntilShort(input time.Time) string {
+	return shortenTime(durafmt.ParseShort(max(time.Until(input), 0)).String())
+}
+
+// A time set & read from different goroutines, like download workers & presence updates.
+type lockedTime struct {
+	mutex sync.RWMutex
+	value time.Time
+}
+
+func (t *lockedTime) set(value time.Time) {
+	t.mutex.Lock()
+	t.value = value
+	t.mutex.Unlock()
+}
+
+func (t *lockedTime) get() time.Time {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.value
+}
+
+// Sets the value, returning the one it replaced.
+func (t *lockedTime) swap(value time.Time) time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	previous := t.value
+	t.value = value
+	return previous
+}
@@ -22,6 +22,9 @@ func properExit() {
 	// Not formatting string because I only want the exit message to be red.
 	log.Println(lg("Main", "", color.HiRedString, "[EXIT IN 15 SECONDS] Uptime was %s...", timeSince(startTime)))
 	log.Println(color.HiCyanString("----------------------------------------------------"))
+	saveCheckpoints()
+	saveHistoryJobs()
+	logPendingPostDownloadCommands()
 	time.Sleep(15 * time.Second)
 	os.Exit(1)
 }
@@ -82,6 +82,7 @@ var (
 	defConfig_DiscordTimeout   int = 180
 	defConfig_DownloadTimeout  int = 60
 	defConfig_DownloadRetryMax int = 2
+	defConfig_DownloadWorkers  int = 4
 
 	defConfig_HistoryManagerRate  int = 5
 	defConfig_CheckupRate         int = 30
@@ -91,7 +92,19 @@ var (
 	defConfig_FilenameDateFormat string = "2006-01-02_15-04-05"
 	defConfig_FilenameFormat     string = "{{date}} {{file}}"
 
-	defConfig_HistoryMaxJobs int = 3
+	defConfig_HistoryMaxJobs  int = 3
+	defConfig_HistorySegments int = 4
+	defConfig_HistoryPrefetch int = 2
+
+	defConfig_HistoryCatchUpMaxAge int = 72
+
+	defConfig_APIAddress string = "127.0.0.1:8420"
+
+	defConfig_WebhookRetryMax int = 5
+	defConfig_WebhookTimeout  int = 15
+
+	defConfig_PostDownloadTimeout     int = 60
+	defConfig_PostDownloadConcurrency int = 2
 )
 
 func defaultConfiguration() configuration {
@@ -115,6 +128,7 @@ func defaultConfiguration() configuration {
 		ProcessLimit:          defConfig_ProcessLimit,
 		Debug:                 defConfig_Debug,
 		BackupDatabaseOnStart: false,
+		DatabaseType:          databaseTypeTiedot,
 		WatchSettings:         true,
 		LogSettings:           true,
 		MessageOutput:         true,
@@ -124,9 +138,15 @@ func defaultConfiguration() configuration {
 		DiscordTimeout:       defConfig_DiscordTimeout,
 		DownloadTimeout:      defConfig_DownloadTimeout,
 		DownloadRetryMax:     defConfig_DownloadRetryMax,
+		DownloadWorkers:      defConfig_DownloadWorkers,
+		WebhookRetryMax:      defConfig_WebhookRetryMax,
+		WebhookTimeout:       defConfig_WebhookTimeout,
 		ExitOnBadConnection:  false,
 		GithubUpdateChecking: defConfig_GithubUpdateChecking,
 
+		PostDownloadTimeout:     defConfig_PostDownloadTimeout,
+		PostDownloadConcurrency: defConfig_PostDownloadConcurrency,
+
 		CommandPrefix:        defConfig_CommandPrefix,
 		CommandTagging:       true,
 		ScanOwnMessages:      defConfig_ScanOwnMessages,
@@ -171,12 +191,16 @@ func defaultConfiguration() configuration {
 		AutoHistoryExit:       false,
 		AutoHistoryBefore:     "",
 		AutoHistorySince:      "",
+		HistoryCatchUp:        false,
+		HistoryCatchUpMaxAge:  defConfig_HistoryCatchUpMaxAge,
 		SendHistoryStatus:     true,
 		SendAutoHistoryStatus: false,
 		OutputHistoryStatus:   true,
 		OutputHistoryErrors:   true,
 		HistoryRequestCount:   100,
 		HistoryRequestDelay:   0,
+		HistorySegments:       defConfig_HistorySegments,
+		HistoryPrefetch:       defConfig_HistoryPrefetch,
 
 		// Rules for Saving
 		Subfolders:             []string{"{{fileType}}"},
@@ -191,6 +215,7 @@ func defaultConfiguration() configuration {
 		SaveTextFiles:          false,
 		SaveOtherFiles:         false,
 		SavePossibleDuplicates: false,
+		DuplicateContent:       duplicateContentSave,
 		DelayHandling:          0,
 		DelayHandlingHistory:   0,
 		Filters: &configurationSourceFilters{
@@ -249,6 +274,7 @@ type configuration struct {
 	ExitOnBadConnection   bool   `json:"exitOnBadConnection" yaml:"exitOnBadConnection"`
 	WatchSettings         bool   `json:"watchSettings" yaml:"watchSettings"`
 	BackupDatabaseOnStart bool   `json:"backupDatabaseOnStart" yaml:"backupDatabaseOnStart"`
+	DatabaseType          string `json:"databaseType,omitempty" yaml:"databaseType,omitempty"` // tiedot, sqlite
 	CheckupRate           int    `json:"checkupRate,omitempty" yaml:"checkupRate,omitempty"`
 	ConnectionCheck       bool   `json:"connectionCheck,omitempty" yaml:"connectionCheck,omitempty"`
 	ConnectionCheckRate   int    `json:"connectionCheckRate,omitempty" yaml:"connectionCheckRate,omitempty"`
@@ -267,11 +293,32 @@ type configuration struct {
 	DiscordTimeout       int    `json:"discordTimeout" yaml:"discordTimeout"`
 	DownloadTimeout      int    `json:"downloadTimeout" yaml:"downloadTimeout"`
 	DownloadRetryMax     int    `json:"downloadRetryMax" yaml:"downloadRetryMax"`
+	DownloadResume       bool   `json:"downloadResume,omitempty" yaml:"downloadResume,omitempty"`
 	SendErrorMessages    bool   `json:"sendErrorMessages" yaml:"sendErrorMessages"`
 	IgnoreEmojis         bool   `json:"ignoreEmojis" yaml:"ignoreEmojis"`
 	IgnoreStickers       bool   `json:"ignoreStickers" yaml:"ignoreStickers"`
 	IgnoreEmojisWEBP     *bool  `json:"ignoreEmojisWEBP" yaml:"ignoreEmojisWEBP"`
 
+	// Download Queue
+	DownloadWorkers      int            `json:"downloadWorkers,omitempty" yaml:"downloadWorkers,omitempty"`
+	DownloadDomainLimits map[string]int `json:"downloadDomainLimits,omitempty" yaml:"downloadDomainLimits,omitempty"` // max concurrent per domain (and its subdomains)
+
+	// Web API & Dashboard, also serves Prometheus metrics on /metrics
+	APIEnabled bool   `json:"apiEnabled,omitempty" yaml:"apiEnabled,omitempty"`
+	APIAddress string `json:"apiAddress,omitempty" yaml:"apiAddress,omitempty"` // host:port, localhost unless you know what you're doing
+
+	// Webhook Delivery
+	WebhookRetryMax int `json:"webhookRetryMax,omitempty" yaml:"webhookRetryMax,omitempty"`
+	WebhookTimeout  int `json:"webhookTimeout,omitempty" yaml:"webhookTimeout,omitempty"` // seconds
+
+	// Post-Download Commands
+	PostDownloadTimeout     int `json:"postDownloadTimeout,omitempty" yaml:"postDownloadTimeout,omitempty"` // seconds
+	PostDownloadConcurrency int `json:"postDownloadConcurrency,omitempty" yaml:"postDownloadConcurrency,omitempty"`
+
+	// Dry Run, downloads are planned & reported instead of saved
+	DryRun       bool   `json:"dryRun,omitempty" yaml:"dryRun,omitempty"`
+	DryRunReport string `json:"dryRunReport,omitempty" yaml:"dryRunReport,omitempty"` // .csv or .jsonl
+
 	// Discord Emojis & Stickers
 	EmojisServers          *[]string `json:"emojisServers" yaml:"emojisServers"`
 	EmojisFilenameFormat   string    `json:"emojisFilenameFormat" yaml:"emojisFilenameFormat"`
@@ -309,12 +356,17 @@ type configuration struct {
 	AutoHistoryExit       bool   `json:"autoHistoryExit" yaml:"autoHistoryExit"`
 	AutoHistoryBefore     string `json:"autoHistoryBefore" yaml:"autoHistoryBefore"`
 	AutoHistorySince      string `json:"autoHistorySince" yaml:"autoHistorySince"`
+	HistorySchedule       string `json:"historySchedule,omitempty" yaml:"historySchedule,omitempty"`           // cron or interval, see history-schedule.go
+	HistoryCatchUp        bool   `json:"historyCatchUp" yaml:"historyCatchUp"`                                 // after downtime, see catchup.go
+	HistoryCatchUpMaxAge  int    `json:"historyCatchUpMaxAge,omitempty" yaml:"historyCatchUpMaxAge,omitempty"` // hours, catch-up goes no further back
 	SendAutoHistoryStatus bool   `json:"sendAutoHistoryStatus" yaml:"sendAutoHistoryStatus"`
 	SendHistoryStatus     bool   `json:"sendHistoryStatus" yaml:"sendHistoryStatus"`
 	OutputHistoryStatus   bool   `json:"outputHistoryStatus" yaml:"outputHistoryStatus"`
 	OutputHistoryErrors   bool   `json:"outputHistoryErrors" yaml:"outputHistoryErrors"`
 	HistoryRequestCount   int    `json:"historyRequestCount" yaml:"historyRequestCount"`
-	HistoryRequestDelay   int    `json:"historyRequestDelay" yaml:"historyRequestDelay"`
+	HistoryRequestDelay   int    `json:"historyRequestDelay" yaml:"historyRequestDelay"`             // seconds between a channel's requests, its segments share it
+	HistorySegments       int    `json:"historySegments,omitempty" yaml:"historySegments,omitempty"` // parts of a channel fetched at once, processed out of order across parts, 1 keeps order
+	HistoryPrefetch       int    `json:"historyPrefetch,omitempty" yaml:"historyPrefetch,omitempty"` // pages a segment fetches ahead
 
 	// Rules for Saving
 	Save                   bool                        `json:"save" yaml:"save"`
@@ -330,6 +382,7 @@ type configuration struct {
 	SaveTextFiles          bool                        `json:"saveTextFiles" yaml:"saveTextFiles"`
 	SaveOtherFiles         bool                        `json:"saveOtherFiles" yaml:"saveOtherFiles"`
 	SavePossibleDuplicates bool                        `json:"savePossibleDuplicates" yaml:"savePossibleDuplicates"`
+	DuplicateContent       string                      `json:"duplicateContent,omitempty" yaml:"duplicateContent,omitempty"`
 	DelayHandling          int                         `json:"delayHandling,omitempty" yaml:"delayHandling,omitempty"`
 	DelayHandlingHistory   int                         `json:"delayHandlingHistory,omitempty" yaml:"delayHandlingHistory,omitempty"`
 	Filters                *configurationSourceFilters `json:"filters" yaml:"filters"`
@@ -337,8 +390,18 @@ type configuration struct {
 	DuploThreshold         float64                     `json:"duploThreshold,omitempty" yaml:"duploThreshold,omitempty"`
 
 	// Misc Rules
-	LogLinks    *configurationSourceLog `json:"logLinks,omitempty" yaml:"logLinks,omitempty"`
-	LogMessages *configurationSourceLog `json:"logMessages,omitempty" yaml:"logMessages,omitempty"`
+	LogLinks    *configurationSourceLog        `json:"logLinks,omitempty" yaml:"logLinks,omitempty"`
+	LogMessages *configurationSourceLog        `json:"logMessages,omitempty" yaml:"logMessages,omitempty"`
+	Archive     *configurationSourceArchive    `json:"archive,omitempty" yaml:"archive,omitempty"`
+	Gallery     *configurationSourceGallery    `json:"gallery,omitempty" yaml:"gallery,omitempty"`
+	Thumbnails  *configurationSourceThumbnails `json:"thumbnails,omitempty" yaml:"thumbnails,omitempty"`
+	Metadata    *configurationSourceMetadata   `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Webhooks    []configurationWebhook         `json:"webhooks,omitempty" yaml:"webhooks,omitempty"`
+
+	PostDownloadCommand []string `json:"postDownloadCommand,omitempty" yaml:"postDownloadCommand,omitempty"`
+
+	Rules  []configurationRule  `json:"rules,omitempty" yaml:"rules,omitempty"`   // first match decides, filters apply when none do
+	Routes []configurationRoute `json:"routes,omitempty" yaml:"routes,omitempty"` // first match replaces the destination
 
 	// Sources
 	All                    *configurationSource  `json:"all,omitempty" yaml:"all,omitempty"`
@@ -406,6 +469,8 @@ type configurationSource struct {
 	AutoHistory           *bool   `json:"autoHistory" yaml:"autoHistory"`
 	AutoHistoryBefore     *string `json:"autoHistoryBefore" yaml:"autoHistoryBefore"`
 	AutoHistorySince      *string `json:"autoHistorySince" yaml:"autoHistorySince"`
+	HistorySchedule       *string `json:"historySchedule,omitempty" yaml:"historySchedule,omitempty"`
+	HistoryCatchUp        *bool   `json:"historyCatchUp,omitempty" yaml:"historyCatchUp,omitempty"`
 	SendAutoHistoryStatus *bool   `json:"sendAutoHistoryStatus" yaml:"sendAutoHistoryStatus"`
 	SendHistoryStatus     *bool   `json:"sendHistoryStatus" yaml:"sendHistoryStatus"`
 	OutputHistoryStatus   *bool   `json:"outputHistoryStatus" yaml:"outputHistoryStatus"`
@@ -424,6 +489,7 @@ type configurationSource struct {
 	SaveTextFiles          *bool                       `json:"saveTextFiles" yaml:"saveTextFiles"`
 	SaveOtherFiles         *bool                       `json:"saveOtherFiles" yaml:"saveOtherFiles"`
 	SavePossibleDuplicates *bool                       `json:"savePossibleDuplicates" yaml:"savePossibleDuplicates"`
+	DuplicateContent       *string                     `json:"duplicateContent,omitempty" yaml:"duplicateContent,omitempty"` // save, skip, hardlink, symlink
 	DelayHandling          *int                        `json:"delayHandling,omitempty" yaml:"delayHandling,omitempty"`
 	DelayHandlingHistory   *int                        `json:"delayHandlingHistory,omitempty" yaml:"delayHandlingHistory,omitempty"`
 	Filters                *configurationSourceFilters `json:"filters" yaml:"filters"`
@@ -431,8 +497,18 @@ type configurationSource struct {
 	DuploThreshold         *float64                    `json:"duploThreshold,omitempty" yaml:"duploThreshold,omitempty"`
 
 	// Misc Rules
-	LogLinks    *configurationSourceLog `json:"logLinks,omitempty" yaml:"logLinks,omitempty"`
-	LogMessages *configurationSourceLog `json:"logMessages,omitempty" yaml:"logMessages,omitempty"`
+	LogLinks    *configurationSourceLog        `json:"logLinks,omitempty" yaml:"logLinks,omitempty"`
+	LogMessages *configurationSourceLog        `json:"logMessages,omitempty" yaml:"logMessages,omitempty"`
+	Archive     *configurationSourceArchive    `json:"archive,omitempty" yaml:"archive,omitempty"`
+	Gallery     *configurationSourceGallery    `json:"gallery,omitempty" yaml:"gallery,omitempty"`
+	Thumbnails  *configurationSourceThumbnails `json:"thumbnails,omitempty" yaml:"thumbnails,omitempty"`
+	Metadata    *configurationSourceMetadata   `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Webhooks    *[]configurationWebhook        `json:"webhooks,omitempty" yaml:"webhooks,omitempty"` // replaces the global webhooks
+
+	PostDownloadCommand *[]string `json:"postDownloadCommand,omitempty" yaml:"postDownloadCommand,omitempty"` // executable & arguments, no shell
+
+	Rules  *[]configurationRule  `json:"rules,omitempty" yaml:"rules,omitempty"`   // replaces the global rules
+	Routes *[]configurationRoute `json:"routes,omitempty" yaml:"routes,omitempty"` // replaces the global routes
 }
 
 type configurationSourceFilters struct {
@@ -459,19 +535,36 @@ type configurationSourceFilters struct {
 
 	BlockedReactions *[]string `json:"blockedReactions,omitempty" yaml:"blockedReactions,omitempty"`
 	AllowedReactions *[]string `json:"allowedReactions,omitempty" yaml:"allowedReactions,omitempty"`
+
+	// Limits, checked once the file is downloaded (sizes also against Content-Length before)
+	MinSize     *int64 `json:"minSize,omitempty" yaml:"minSize,omitempty"`         // bytes
+	MaxSize     *int64 `json:"maxSize,omitempty" yaml:"maxSize,omitempty"`         // bytes
+	MinWidth    *int   `json:"minWidth,omitempty" yaml:"minWidth,omitempty"`       // pixels, images & videos
+	MinHeight   *int   `json:"minHeight,omitempty" yaml:"minHeight,omitempty"`     // pixels, images & videos
+	MaxDuration *int   `json:"maxDuration,omitempty" yaml:"maxDuration,omitempty"` // seconds, MP4/MOV & WebM/MKV
 }
 
 var (
-	defSourceLog_Subfolders            []string = []string{"{{year}}-{{monthNum}}-{{dayOfMonth}}"}
-	defSourceLog_SubfoldersFallback    []string = nil
-	defSourceLog_FilenameFormat        string   = "{{serverName}} - {{channelName}}.txt"
-	defSourceLog_FilepathNormalizeText bool     = false
-	defSourceLog_FilepathStripSymbols  bool     = false
-	defSourceLog_LinePrefix            string   = "[{{serverName}} / {{channelName}}] \"{{username}}\" @ {{timestamp}}: "
-	defSourceLog_LogDownloads          bool     = true
-	defSourceLog_LogFailures           bool     = true
-	defSourceLogMsg_LineContent        string   = "{{message}}"
-	defSourceLogLink_LineContent       string   = "{{link}}"
+	defSourceLog_Subfolders             []string = []string{"{{year}}-{{monthNum}}-{{dayOfMonth}}"}
+	defSourceLog_SubfoldersFallback     []string = nil
+	defSourceLog_FilenameFormat         string   = "{{serverName}} - {{channelName}}.txt"
+	defSourceLog_FilepathNormalizeText  bool     = false
+	defSourceLog_FilepathStripSymbols   bool     = false
+	defSourceLog_LinePrefix             string   = "[{{serverName}} / {{channelName}}] \"{{username}}\" @ {{timestamp}}: "
+	defSourceLog_LogDownloads           bool     = true
+	defSourceLog_LogFailures            bool     = true
+	defSourceLog_Format                 string   = sourceLogFormatText
+	defSourceArchive_ExportAfterHistory bool     = true
+	defSourceGallery_Title              string   = projectLabel + " Gallery"
+	defSourceGallery_BuildEvery         int      = 60
+	defSourceGallery_PageSize           int      = 200
+	defSourceGallery_ThumbnailSize      int      = 320
+	defSourceThumbnails_Location        string   = thumbnailLocationTree
+	defSourceThumbnails_Size            int      = 320
+	defSourceMetadata_Sidecar           bool     = true
+	defSourceMetadata_Embed             bool     = true
+	defSourceLogMsg_LineContent         string   = "{{message}}"
+	defSourceLogLink_LineContent        string   = "{{link}}"
 )
 
 type configurationSourceLog struct {
@@ -482,6 +575,8 @@ type configurationSourceLog struct {
 	FilepathNormalizeText *bool     `json:"filepathNormalizeText,omitempty" yaml:"filepathNormalizeText,omitempty"`
 	FilepathStripSymbols  *bool     `json:"filepathStripSymbols,omitempty" yaml:"filepathStripSymbols,omitempty"`
 
+	Format *string `json:"format,omitempty" yaml:"format,omitempty"` // text, jsonl or csv, see logs.go
+
 	LinePrefix  *string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
 	LineSuffix  *string `json:"suffix,omitempty" yaml:"suffix,omitempty"`
 	LineContent *string `json:"content,omitempty" yaml:"content,omitempty"`
@@ -492,6 +587,52 @@ type configurationSourceLog struct {
 	LogFailures  *bool `json:"logFailures" yaml:"logFailures"`   // links only
 }
 
+type configurationSourceArchive struct {
+	Destination        string `json:"destination" yaml:"destination"`
+	ExportAfterHistory *bool  `json:"exportAfterHistory,omitempty" yaml:"exportAfterHistory,omitempty"`
+}
+
+type configurationSourceGallery struct {
+	Destination   string  `json:"destination" yaml:"destination"`
+	Title         *string `json:"title,omitempty" yaml:"title,omitempty"`
+	BuildEvery    *int    `json:"buildEvery,omitempty" yaml:"buildEvery,omitempty"`       // minutes, 0 only builds by command
+	PageSize      *int    `json:"pageSize,omitempty" yaml:"pageSize,omitempty"`           // files per page
+	ThumbnailSize *int    `json:"thumbnailSize,omitempty" yaml:"thumbnailSize,omitempty"` // pixels, longest side
+}
+
+type configurationSourceThumbnails struct {
+	Location *string `json:"location,omitempty" yaml:"location,omitempty"` // tree (.thumbs in the destination) or sidecar
+	Size     *int    `json:"size,omitempty" yaml:"size,omitempty"`         // pixels, longest side
+}
+
+type configurationSourceMetadata struct {
+	Sidecar *bool `json:"sidecar,omitempty" yaml:"sidecar,omitempty"` // .json next to the file
+	Embed   *bool `json:"embed,omitempty" yaml:"embed,omitempty"`     // XMP in JPEG & PNG files
+}
+
+type configurationWebhook struct {
+	URL     string            `json:"url" yaml:"url"`
+	Secret  string            `json:"secret,omitempty" yaml:"secret,omitempty"` // signs deliveries with HMAC-SHA256
+	Events  []string          `json:"events,omitempty" yaml:"events,omitempty"` // every event when empty
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+type configurationRoute struct {
+	Destination  string    `json:"destination" yaml:"destination"`
+	ContentTypes *[]string `json:"contentTypes,omitempty" yaml:"contentTypes,omitempty"` // image, video/*, video/mp4
+	Extensions   *[]string `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+	Domains      *[]string `json:"domains,omitempty" yaml:"domains,omitempty"`
+	Users        *[]string `json:"users,omitempty" yaml:"users,omitempty"` // uploader IDs
+	Roles        *[]string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Phrases      *[]string `json:"phrases,omitempty" yaml:"phrases,omitempty"` // in the message
+}
+
+type configurationRule struct {
+	When        string `json:"when" yaml:"when"`                                   // expression, see rules.go
+	Action      string `json:"action" yaml:"action"`                               // save, skip, route
+	Destination string `json:"destination,omitempty" yaml:"destination,omitempty"` // route only
+}
+
 //#endregion
 
 //#region Config, Admin Channels
@@ -622,6 +763,9 @@ func loadConfig() error {
 		if config.DownloadRetryMax < 1 {
 			config.DownloadRetryMax = defConfig_DownloadRetryMax
 		}
+		if config.DownloadWorkers < 1 {
+			config.DownloadWorkers = defConfig_DownloadWorkers
+		}
 		if config.CheckupRate < 1 {
 			config.CheckupRate = defConfig_CheckupRate
 		}
@@ -640,6 +784,41 @@ func loadConfig() error {
 		if config.HistoryMaxJobs < 1 {
 			config.HistoryMaxJobs = defConfig_HistoryMaxJobs
 		}
+		if config.HistorySegments < 1 {
+			config.HistorySegments = defConfig_HistorySegments
+		}
+		if config.HistoryCatchUpMaxAge < 1 {
+			config.HistoryCatchUpMaxAge = defConfig_HistoryCatchUpMaxAge
+		}
+		config.DatabaseType = strings.ToLower(config.DatabaseType)
+		if config.DatabaseType != databaseTypeTiedot && config.DatabaseType != databaseTypeSqlite {
+			if config.DatabaseType != "" {
+				log.Println(lg("Settings", "", color.HiRedString,
+					"Unknown databaseType \"%s\", using %s...", config.DatabaseType, databaseTypeTiedot))
+			}
+			config.DatabaseType = databaseTypeTiedot
+		}
+		if config.APIAddress == "" {
+			config.APIAddress = defConfig_APIAddress
+		}
+		if config.WebhookRetryMax < 1 {
+			config.WebhookRetryMax = defConfig_WebhookRetryMax
+		}
+		if config.WebhookTimeout < 1 {
+			config.WebhookTimeout = defConfig_WebhookTimeout
+		}
+		if config.PostDownloadTimeout < 1 {
+			config.PostDownloadTimeout = defConfig_PostDownloadTimeout
+		}
+		if config.PostDownloadConcurrency < 1 {
+			config.PostDownloadConcurrency = defConfig_PostDownloadConcurrency
+		}
+		if flagDryRun {
+			config.DryRun = true
+		}
+		if config.DryRunReport == "" {
+			config.DryRunReport = pathCacheDryRun
+		}
 
 		// Log to File
 		if config.LogOutput != "" {
@@ -671,6 +850,9 @@ func loadConfig() error {
 			if config.LogLinks.LinePrefix == nil {
 				config.LogLinks.LinePrefix = &defSourceLog_LinePrefix
 			}
+			if config.LogLinks.Format == nil {
+				config.LogLinks.Format = &defSourceLog_Format
+			}
 			// vv unique vv
 			if config.LogLinks.LineContent == nil {
 				config.LogLinks.LineContent = &defSourceLogLink_LineContent
@@ -701,11 +883,69 @@ func loadConfig() error {
 			if config.LogMessages.LinePrefix == nil {
 				config.LogMessages.LinePrefix = &defSourceLog_LinePrefix
 			}
+			if config.LogMessages.Format == nil {
+				config.LogMessages.Format = &defSourceLog_Format
+			}
 			// vv unique vv
 			if config.LogMessages.LineContent == nil {
 				config.LogMessages.LineContent = &defSourceLogMsg_LineContent
 			}
 		}
+		if config.Archive != nil {
+			if config.Archive.ExportAfterHistory == nil {
+				config.Archive.ExportAfterHistory = &defSourceArchive_ExportAfterHistory
+			}
+		}
+		if config.Gallery != nil {
+			galleryDefault(config.Gallery)
+		}
+		if config.Thumbnails != nil {
+			thumbnailsDefault(config.Thumbnails)
+		}
+		if config.Metadata != nil {
+			metadataDefault(config.Metadata)
+		}
+
+		// Rules, Routes, Filter Patterns & Log Formats
+		validateRules("Global", config.Rules)
+		validateRoutes("Global", config.Routes)
+		validateFilters("Global", config.Filters)
+		validateSourceLog("Global", "logLinks", config.LogLinks)
+		validateSourceLog("Global", "logMessages", config.LogMessages)
+		validateMetadata("Global", config.Metadata, config.DuplicateContent)
+		sources := append(append(append(append([]configurationSource{},
+			config.Servers...), config.Categories...), config.Channels...), config.Users...)
+		if config.All != nil {
+			sources = append(sources, *config.All)
+		}
+		for _, source := range sources {
+			if source.Rules != nil && source.Rules != &config.Rules {
+				validateRules(sourceLabel(source), *source.Rules)
+			}
+			if source.Routes != nil && source.Routes != &config.Routes {
+				validateRoutes(sourceLabel(source), *source.Routes)
+			}
+			validateFilters(sourceLabel(source), source.Filters)
+			if source.LogLinks != config.LogLinks {
+				validateSourceLog(sourceLabel(source), "logLinks", source.LogLinks)
+			}
+			if source.LogMessages != config.LogMessages {
+				validateSourceLog(sourceLabel(source), "logMessages", source.LogMessages)
+			}
+		}
+		for _, source := range sources {
+			if source.Metadata == nil && source.DuplicateContent == nil {
+				continue // warned about as global
+			}
+			metadata, duplicateContent := config.Metadata, config.DuplicateContent
+			if source.Metadata != nil {
+				metadata = source.Metadata
+			}
+			if source.DuplicateContent != nil {
+				duplicateContent = *source.DuplicateContent
+			}
+			validateMetadata(sourceLabel(source), metadata, duplicateContent)
+		}
 
 		// Overwrite Paths
 		if config.OverwriteCachePath != "" {
@@ -1085,6 +1325,12 @@ func sourceDefault(source *configurationSource) {
 	if source.AutoHistorySince == nil {
 		source.AutoHistorySince = &config.AutoHistorySince
 	}
+	if source.HistorySchedule == nil {
+		source.HistorySchedule = &config.HistorySchedule
+	}
+	if source.HistoryCatchUp == nil {
+		source.HistoryCatchUp = &config.HistoryCatchUp
+	}
 	if source.SendAutoHistoryStatus == nil {
 		source.SendAutoHistoryStatus = &config.SendAutoHistoryStatus
 	}
@@ -1135,6 +1381,9 @@ func sourceDefault(source *configurationSource) {
 	if source.SavePossibleDuplicates == nil {
 		source.SavePossibleDuplicates = &config.SavePossibleDuplicates
 	}
+	if source.DuplicateContent == nil {
+		source.DuplicateContent = &config.DuplicateContent
+	}
 	if source.DelayHandling == nil && config.DelayHandling != 0 {
 		source.DelayHandling = &config.DelayHandling
 	}
@@ -1192,6 +1441,21 @@ func sourceDefault(source *configurationSource) {
 	if source.Filters.AllowedReactions == nil && config.Filters.AllowedReactions != nil {
 		source.Filters.AllowedReactions = config.Filters.AllowedReactions
 	}
+	if source.Filters.MinSize == nil && config.Filters.MinSize != nil {
+		source.Filters.MinSize = config.Filters.MinSize
+	}
+	if source.Filters.MaxSize == nil && config.Filters.MaxSize != nil {
+		source.Filters.MaxSize = config.Filters.MaxSize
+	}
+	if source.Filters.MinWidth == nil && config.Filters.MinWidth != nil {
+		source.Filters.MinWidth = config.Filters.MinWidth
+	}
+	if source.Filters.MinHeight == nil && config.Filters.MinHeight != nil {
+		source.Filters.MinHeight = config.Filters.MinHeight
+	}
+	if source.Filters.MaxDuration == nil && config.Filters.MaxDuration != nil {
+		source.Filters.MaxDuration = config.Filters.MaxDuration
+	}
 	if source.Duplo == nil && config.Duplo {
 		source.Duplo = &config.Duplo
 	}
@@ -1219,6 +1483,9 @@ func sourceDefault(source *configurationSource) {
 		if source.LogLinks.LinePrefix == nil {
 			source.LogLinks.LinePrefix = &defSourceLog_LinePrefix
 		}
+		if source.LogLinks.Format == nil {
+			source.LogLinks.Format = &defSourceLog_Format
+		}
 		// vv unique vv
 		if source.LogLinks.LineContent == nil {
 			source.LogLinks.LineContent = &defSourceLogLink_LineContent
@@ -1251,6 +1518,9 @@ func sourceDefault(source *configurationSource) {
 		if source.LogMessages.LinePrefix == nil {
 			source.LogMessages.LinePrefix = &defSourceLog_LinePrefix
 		}
+		if source.LogMessages.Format == nil {
+			source.LogMessages.Format = &defSourceLog_Format
+		}
 		// vv unique vv
 		if source.LogMessages.LineContent == nil {
 			source.LogMessages.LineContent = &defSourceLogMsg_LineContent
@@ -1258,6 +1528,40 @@ func sourceDefault(source *configurationSource) {
 	} else if config.LogMessages != nil {
 		source.LogMessages = config.LogMessages
 	}
+	if source.Archive != nil {
+		if source.Archive.ExportAfterHistory == nil {
+			source.Archive.ExportAfterHistory = &defSourceArchive_ExportAfterHistory
+		}
+	} else if config.Archive != nil {
+		source.Archive = config.Archive
+	}
+	if source.Gallery != nil {
+		galleryDefault(source.Gallery)
+	} else if config.Gallery != nil {
+		source.Gallery = config.Gallery
+	}
+	if source.Thumbnails != nil {
+		thumbnailsDefault(source.Thumbnails)
+	} else if config.Thumbnails != nil {
+		source.Thumbnails = config.Thumbnails
+	}
+	if source.Metadata != nil {
+		metadataDefault(source.Metadata)
+	} else if config.Metadata != nil {
+		source.Metadata = config.Metadata
+	}
+	if source.Webhooks == nil && len(config.Webhooks) > 0 {
+		source.Webhooks = &config.Webhooks
+	}
+	if source.PostDownloadCommand == nil && len(config.PostDownloadCommand) > 0 {
+		source.PostDownloadCommand = &config.PostDownloadCommand
+	}
+	if source.Rules == nil && len(config.Rules) > 0 {
+		source.Rules = &config.Rules
+	}
+	if source.Routes == nil && len(config.Routes) > 0 {
+		source.Routes = &config.Routes
+	}
 
 	// LAZY CHECKS
 	if source.Duplo != nil {
@@ -1284,6 +1588,39 @@ func adminChannelDefault(channel *configurationAdminChannel) {
 	}
 }
 
+func galleryDefault(gallery *configurationSourceGallery) {
+	if gallery.Title == nil {
+		gallery.Title = &defSourceGallery_Title
+	}
+	if gallery.BuildEvery == nil {
+		gallery.BuildEvery = &defSourceGallery_BuildEvery
+	}
+	if gallery.PageSize == nil || *gallery.PageSize < 1 {
+		gallery.PageSize = &defSourceGallery_PageSize
+	}
+	if gallery.ThumbnailSize == nil || *gallery.ThumbnailSize < 16 {
+		gallery.ThumbnailSize = &defSourceGallery_ThumbnailSize
+	}
+}
+
+func thumbnailsDefault(thumbnails *configurationSourceThumbnails) {
+	if thumbnails.Location == nil || *thumbnails.Location == "" {
+		thumbnails.Location = &defSourceThumbnails_Location
+	}
+	if thumbnails.Size == nil || *thumbnails.Size < 16 {
+		thumbnails.Size = &defSourceThumbnails_Size
+	}
+}
+
+func metadataDefault(metadata *configurationSourceMetadata) {
+	if metadata.Sidecar == nil {
+		metadata.Sidecar = &defSourceMetadata_Sidecar
+	}
+	if metadata.Embed == nil {
+		metadata.Embed = &defSourceMetadata_Embed
+	}
+}
+
 // Checks if message author is a specified bot admin.
 func isBotAdmin(m *discordgo.Message) bool {
 	// No Admins or Admin Channels
@@ -1307,6 +1644,35 @@ func isBotAdmin(m *discordgo.Message) bool {
 
 var emptySourceConfig configurationSource = configurationSource{}
 
+// Alias when the source has one, otherwise what it was bound by.
+func sourceLabel(source configurationSource) string {
+	switch {
+	case source == emptySourceConfig:
+		return "none"
+	case source.Alias != nil && *source.Alias != "":
+		return *source.Alias
+	case config.All != nil && source == *config.All:
+		return "all"
+	case source.ChannelID != "":
+		return "channel:" + source.ChannelID
+	case source.CategoryID != "":
+		return "category:" + source.CategoryID
+	case source.ServerID != "":
+		return "server:" + source.ServerID
+	case source.UserID != "":
+		return "user:" + source.UserID
+	case source.ChannelIDs != nil:
+		return "channels"
+	case source.CategoryIDs != nil:
+		return "categories"
+	case source.ServerIDs != nil:
+		return "servers"
+	case source.UserIDs != nil:
+		return "users"
+	}
+	return "unknown"
+}
+
 func getSource(m *discordgo.Message) configurationSource {
 	chinfo, err := bot.State.Channel(m.ChannelID)
 	if err != nil {
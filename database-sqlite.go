@@ -0,0 +1,373 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Single file database, pure Go (no cgo) so builds stay portable.
+type sqliteStore struct {
+	path string
+	db   *sql.DB
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS downloads (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	url         TEXT NOT NULL,
+	time        TEXT NOT NULL,
+	destination TEXT NOT NULL,
+	filename    TEXT NOT NULL,
+	channel_id  TEXT NOT NULL,
+	user_id     TEXT NOT NULL,
+	hash        TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS queue (
+	id   INTEGER PRIMARY KEY AUTOINCREMENT,
+	item TEXT NOT NULL
+);
+`
+
+// Columns added after the initial schema, added to existing databases on open.
+var sqliteDownloadColumns = [][2]string{
+	{"message_id", "TEXT NOT NULL DEFAULT ''"},
+	{"guild_id", "TEXT NOT NULL DEFAULT ''"},
+	{"attachment_id", "TEXT NOT NULL DEFAULT ''"},
+	{"content_type", "TEXT NOT NULL DEFAULT ''"},
+	{"filesize", "INTEGER NOT NULL DEFAULT 0"},
+	{"domain", "TEXT NOT NULL DEFAULT ''"},
+	{"status", "INTEGER NOT NULL DEFAULT 0"},
+	{"thumb_path", "TEXT NOT NULL DEFAULT ''"},
+}
+
+const sqliteIndexes = `
+CREATE INDEX IF NOT EXISTS downloads_url ON downloads (url);
+CREATE INDEX IF NOT EXISTS downloads_channel_id ON downloads (channel_id);
+CREATE INDEX IF NOT EXISTS downloads_user_id ON downloads (user_id);
+CREATE INDEX IF NOT EXISTS downloads_hash ON downloads (hash);
+CREATE INDEX IF NOT EXISTS downloads_message_id ON downloads (message_id);
+CREATE INDEX IF NOT EXISTS downloads_guild_id ON downloads (guild_id);
+CREATE INDEX IF NOT EXISTS downloads_status ON downloads (status);
+`
+
+func openSqliteStore(path string) (*sqliteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	sqlDB, err := sql.Open("sqlite", "file:"+filepath.ToSlash(path)+
+		"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		return nil, err
+	}
+	// Writes are serialized by SQLite anyway, one connection avoids SQLITE_BUSY between our own goroutines.
+	sqlDB.SetMaxOpenConns(1)
+	if _, err = sqlDB.Exec(sqliteSchema); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	if err = sqliteAddMissingColumns(sqlDB); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	if _, err = sqlDB.Exec(sqliteIndexes); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return &sqliteStore{path: path, db: sqlDB}, nil
+}
+
+func sqliteAddMissingColumns(sqlDB *sql.DB) error {
+	rows, err := sqlDB.Query("SELECT name FROM pragma_table_info('downloads')")
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	for _, column := range sqliteDownloadColumns {
+		if !existing[column[0]] {
+			if _, err = sqlDB.Exec("ALTER TABLE downloads ADD COLUMN " + column[0] + " " + column[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) Name() string { return databaseTypeSqlite }
+func (s *sqliteStore) Path() string { return s.path }
+func (s *sqliteStore) Close() error { return s.db.Close() }
+
+// Snapshots with VACUUM INTO so the copy is consistent while the bot keeps writing.
+func (s *sqliteStore) Backup(w *zip.Writer) error {
+	snapshot, err := os.CreateTemp(filepath.Dir(s.path), ".backup-*.sqlite")
+	if err != nil {
+		return err
+	}
+	snapshotPath := snapshot.Name()
+	snapshot.Close()
+	os.Remove(snapshotPath) // VACUUM INTO requires the target to not exist
+	defer os.Remove(snapshotPath)
+
+	if _, err = s.db.Exec("VACUUM INTO ?", snapshotPath); err != nil {
+		return err
+	}
+	file, err := os.Open(snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	f, err := w.Create(filepath.ToSlash(s.path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, file)
+	return err
+}
+
+//#region Downloads
+
+const (
+	sqliteDownloadColumnList = "url, time, destination, filename, channel_id, user_id, hash, " +
+		"message_id, guild_id, attachment_id, content_type, filesize, domain, status, thumb_path"
+	sqliteDownloadInsert = "INSERT INTO downloads (" + sqliteDownloadColumnList + ") " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	sqliteDownloadUpdate = "UPDATE downloads SET url = ?, time = ?, destination = ?, filename = ?, channel_id = ?, " +
+		"user_id = ?, hash = ?, message_id = ?, guild_id = ?, attachment_id = ?, content_type = ?, filesize = ?, " +
+		"domain = ?, status = ?, thumb_path = ? WHERE id = ?"
+)
+
+func sqliteDownloadValues(download *downloadItem) []interface{} {
+	return []interface{}{
+		download.URL, download.Time.Format(time.RFC3339Nano), download.Destination,
+		download.Filename, download.ChannelID, download.UserID, download.Hash,
+		download.MessageID, download.GuildID, download.AttachmentID, download.ContentType,
+		download.Filesize, download.Domain, int(download.Status), download.ThumbPath,
+	}
+}
+
+func sqliteScanDownload(rows *sql.Rows) (*downloadItem, error) {
+	download := &downloadItem{}
+	var timeString string
+	var status int
+	err := rows.Scan(&download.ID, &download.URL, &timeString, &download.Destination,
+		&download.Filename, &download.ChannelID, &download.UserID, &download.Hash,
+		&download.MessageID, &download.GuildID, &download.AttachmentID, &download.ContentType,
+		&download.Filesize, &download.Domain, &status, &download.ThumbPath)
+	download.Time, _ = time.Parse(time.RFC3339Nano, timeString)
+	download.Status = downloadStatus(status)
+	return download, err
+}
+
+func (s *sqliteStore) InsertDownload(download *downloadItem) error {
+	result, err := s.db.Exec(sqliteDownloadInsert, sqliteDownloadValues(download)...)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	download.ID = int(id)
+	return err
+}
+
+func (s *sqliteStore) UpdateDownload(download *downloadItem) error {
+	_, err := s.db.Exec(sqliteDownloadUpdate, append(sqliteDownloadValues(download), download.ID)...)
+	return err
+}
+
+// One transaction for the whole batch, inserting row by row outside of one is far slower.
+func (s *sqliteStore) InsertDownloads(downloads []*downloadItem) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	statement, err := tx.Prepare(sqliteDownloadInsert)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer statement.Close()
+	for _, download := range downloads {
+		if _, err = statement.Exec(sqliteDownloadValues(download)...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) queryDownloads(query string, args ...interface{}) ([]*downloadItem, error) {
+	rows, err := s.db.Query("SELECT id, "+sqliteDownloadColumnList+" FROM downloads "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	downloads := make([]*downloadItem, 0)
+	for rows.Next() {
+		download, err := sqliteScanDownload(rows)
+		if err != nil {
+			return downloads, err
+		}
+		downloads = append(downloads, download)
+	}
+	return downloads, rows.Err()
+}
+
+func (s *sqliteStore) FindDownloadsByURL(inputURL string) ([]*downloadItem, error) {
+	return s.queryDownloads("WHERE url = ?", inputURL)
+}
+
+func (s *sqliteStore) FindDownloadsByHash(contentHash string) ([]*downloadItem, error) {
+	return s.queryDownloads("WHERE hash = ?", contentHash)
+}
+
+func (s *sqliteStore) FindDownloadsByChannelID(channelID string) ([]*downloadItem, error) {
+	return s.queryDownloads("WHERE channel_id = ?", channelID)
+}
+
+func (s *sqliteStore) FindDownloadByID(id int) (*downloadItem, error) {
+	downloads, err := s.queryDownloads("WHERE id = ?", id)
+	if len(downloads) == 0 {
+		return nil, err
+	}
+	return downloads[0], err
+}
+
+func (s *sqliteStore) DeleteDownloadsByChannelID(channelID string) error {
+	_, err := s.db.Exec("DELETE FROM downloads WHERE channel_id = ?", channelID)
+	return err
+}
+
+// Pages through by id so callbacks never run while rows are held on our single connection.
+// Returns the last id handled.
+func (s *sqliteStore) forEachDownloadAfter(lastID int, fn func(download *downloadItem) bool) (int, error) {
+	const pageSize = 1000
+	for {
+		page, err := s.queryDownloads("WHERE id > ? ORDER BY id LIMIT ?", lastID, pageSize)
+		if err != nil {
+			return lastID, err
+		}
+		for _, download := range page {
+			if !fn(download) {
+				return lastID, nil
+			}
+			lastID = download.ID
+		}
+		if len(page) < pageSize {
+			return lastID, nil
+		}
+	}
+}
+
+func (s *sqliteStore) ForEachDownload(fn func(download *downloadItem) bool) error {
+	_, err := s.forEachDownloadAfter(0, fn)
+	return err
+}
+
+func (s *sqliteStore) ForEachDownloadAfter(cursor downloadCursor, fn func(download *downloadItem) bool) (downloadCursor, error) {
+	lastID, err := s.forEachDownloadAfter(cursor.ID, fn)
+	return downloadCursor{ID: lastID}, err
+}
+
+func (s *sqliteStore) RecentDownloads(limit int) ([]*downloadItem, error) {
+	return s.queryDownloads("ORDER BY id DESC LIMIT ?", limit)
+}
+
+func (s *sqliteStore) CountRecords() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM downloads").Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStore) CountDownloads() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM downloads WHERE status = ?", int(downloadSuccess)).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStore) CountDownloadsByChannel(channelID string) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM downloads WHERE channel_id = ? AND status = ?",
+		channelID, int(downloadSuccess)).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteStore) DownloadTotals(guildID string) (downloadTotals, error) {
+	var totals downloadTotals
+	query := "SELECT COUNT(*), COALESCE(SUM(MAX(filesize, 0)), 0) FROM downloads WHERE status = ?"
+	args := []interface{}{int(downloadSuccess)}
+	if guildID != "" {
+		query += " AND guild_id = ?"
+		args = append(args, guildID)
+	}
+	err := s.db.QueryRow(query, args...).Scan(&totals.Count, &totals.Bytes)
+	return totals, err
+}
+
+//#endregion
+
+//#region Queue
+
+func (s *sqliteStore) InsertQueueItem(itemJSON string) (int, error) {
+	result, err := s.db.Exec("INSERT INTO queue (item) VALUES (?)", itemJSON)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (s *sqliteStore) DeleteQueueItem(id int) error {
+	_, err := s.db.Exec("DELETE FROM queue WHERE id = ?", id)
+	return err
+}
+
+func (s *sqliteStore) ForEachQueueItem(fn func(id int, itemJSON string) bool) error {
+	rows, err := s.db.Query("SELECT id, item FROM queue ORDER BY id")
+	if err != nil {
+		return err
+	}
+	type queueRow struct {
+		id   int
+		item string
+	}
+	var queueRows []queueRow
+	for rows.Next() {
+		var row queueRow
+		if err = rows.Scan(&row.id, &row.item); err != nil {
+			rows.Close()
+			return err
+		}
+		queueRows = append(queueRows, row)
+	}
+	rows.Close()
+	// Callbacks run after the rows are released, they may write with our single connection
+	for _, row := range queueRows {
+		if !fn(row.id, row.item) {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) CountQueueItems() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM queue").Scan(&count)
+	return count, err
+}
+
+//#endregion
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HouzuoGuo/tiedot/db"
+	"github.com/HouzuoGuo/tiedot/dberr"
+	"github.com/fatih/color"
+)
+
+// The original document database, a folder of collections.
+
+const tiedotRecentMax = apiRecentMax // newest records kept at hand, older ones need a full read
+
+type tiedotStore struct {
+	path string
+	db   *db.DB
+
+	// Running tallies since tiedot can't aggregate, seeded by reading every document once
+	tallyMutex  sync.Mutex
+	tallySeeded bool
+	records     int
+	totals      map[string]downloadTotals // successes by server, "" for all of them
+	channels    map[string]int            // successes by channel
+	recent      []*downloadItem           // newest first, every outcome
+}
+
+func openTiedotStore(path string) (*tiedotStore, error) {
+	var createT time.Time
+	tiedotDB, err := db.OpenDB(path)
+	if err != nil {
+		return nil, err
+	}
+	store := &tiedotStore{path: path, db: tiedotDB}
+
+	if tiedotDB.Use("Downloads") == nil {
+		log.Println(lg("Database", "Setup", color.YellowString, "Creating database, please wait..."))
+		createT = time.Now()
+		if err := tiedotDB.Create("Downloads"); err != nil {
+			return nil, err
+		}
+		log.Println(lg("Database", "Setup", color.HiYellowString, "Created new database...\t(took %s)", timeSinceShort(createT)))
+		//
+		log.Println(lg("Database", "Setup", color.YellowString, "Structuring database, please wait..."))
+		createT = time.Now()
+		indexColumn := func(col string) {
+			if err := tiedotDB.Use("Downloads").Index([]string{col}); err != nil {
+				log.Println(lg("Database", "Setup", color.HiRedString, "Unable to create index for %s: %s", col, err))
+				return
+			}
+		}
+		indexColumn("URL")
+		indexColumn("ChannelID")
+		indexColumn("UserID")
+		indexColumn("Hash")
+		log.Println(lg("Database", "Setup", color.HiYellowString, "Created database structure...\t(took %s)", timeSinceShort(createT)))
+	}
+	// Content hashes were added later, older databases need the index
+	hashIndexed := false
+	for _, index := range tiedotDB.Use("Downloads").AllIndexes() {
+		if len(index) == 1 && index[0] == "Hash" {
+			hashIndexed = true
+		}
+	}
+	if !hashIndexed {
+		log.Println(lg("Database", "Setup", color.YellowString, "Indexing content hashes, please wait..."))
+		if err := tiedotDB.Use("Downloads").Index([]string{"Hash"}); err != nil {
+			log.Println(lg("Database", "Setup", color.HiRedString, "Unable to create index for Hash: %s", err))
+		}
+	}
+	if tiedotDB.Use("Queue") == nil {
+		if err := tiedotDB.Create("Queue"); err != nil {
+			log.Println(lg("Database", "Setup", color.HiRedString, "Error while trying to create download queue: %s", err))
+		}
+	}
+	return store, nil
+}
+
+func (s *tiedotStore) Name() string { return databaseTypeTiedot }
+func (s *tiedotStore) Path() string { return s.path }
+func (s *tiedotStore) Close() error { return s.db.Close() }
+
+func (s *tiedotStore) Backup(w *zip.Writer) error {
+	return filepath.Walk(s.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		// Ensure that `path` is not absolute; it should not start with "/".
+		// This snippet happens to work because I don't use
+		// absolute paths, but ensure your real-world code
+		// transforms path into a zip-root relative path.
+		f, err := w.Create(path)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(f, file)
+		return err
+	})
+}
+
+//#region Downloads
+
+func tiedotDownloadToDoc(download *downloadItem) map[string]interface{} {
+	return map[string]interface{}{
+		"URL":          download.URL,
+		"Time":         download.Time.String(),
+		"Destination":  download.Destination,
+		"Filename":     download.Filename,
+		"ChannelID":    download.ChannelID,
+		"UserID":       download.UserID,
+		"Hash":         download.Hash,
+		"MessageID":    download.MessageID,
+		"GuildID":      download.GuildID,
+		"AttachmentID": download.AttachmentID,
+		"ContentType":  download.ContentType,
+		"Filesize":     download.Filesize,
+		"Domain":       download.Domain,
+		"Status":       int(download.Status),
+		"ThumbPath":    download.ThumbPath,
+	}
+}
+
+// Writes hold tallyMutex so seeding can't count a document twice.
+func (s *tiedotStore) InsertDownload(download *downloadItem) error {
+	s.tallyMutex.Lock()
+	defer s.tallyMutex.Unlock()
+	id, err := s.db.Use("Downloads").Insert(tiedotDownloadToDoc(download))
+	if err == nil {
+		download.ID = id
+		if s.tallySeeded {
+			s.records++
+			s.tally(download, 1)
+			item := *download
+			s.recent = insertRecentDownload(s.recent, &item, tiedotRecentMax)
+		}
+	}
+	return err
+}
+
+func (s *tiedotStore) UpdateDownload(download *downloadItem) error {
+	s.tallyMutex.Lock()
+	defer s.tallyMutex.Unlock()
+	previous, _ := s.db.Use("Downloads").Read(download.ID)
+	err := s.db.Use("Downloads").Update(download.ID, tiedotDownloadToDoc(download))
+	if err == nil && s.tallySeeded && previous != nil {
+		s.tally(tiedotDocToDownload(download.ID, previous), -1)
+		s.tally(download, 1)
+		for i, recent := range s.recent {
+			if recent.ID == download.ID {
+				item := *download
+				s.recent[i] = &item
+			}
+		}
+	}
+	return err
+}
+
+func (s *tiedotStore) InsertDownloads(downloads []*downloadItem) error {
+	for _, download := range downloads {
+		if err := s.InsertDownload(download); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tiedotDocToDownload(id int, doc map[string]interface{}) *downloadItem {
+	download := &downloadItem{ID: id}
+	download.URL, _ = doc["URL"].(string)
+	download.Destination, _ = doc["Destination"].(string)
+	download.Filename, _ = doc["Filename"].(string)
+	download.ChannelID, _ = doc["ChannelID"].(string)
+	download.UserID, _ = doc["UserID"].(string)
+	// Everything below is missing from older entries
+	download.Hash, _ = doc["Hash"].(string)
+	download.MessageID, _ = doc["MessageID"].(string)
+	download.GuildID, _ = doc["GuildID"].(string)
+	download.AttachmentID, _ = doc["AttachmentID"].(string)
+	download.ContentType, _ = doc["ContentType"].(string)
+	download.Domain, _ = doc["Domain"].(string)
+	download.ThumbPath, _ = doc["ThumbPath"].(string)
+	if filesize, ok := doc["Filesize"].(float64); ok {
+		download.Filesize = int64(filesize)
+	}
+	if status, ok := doc["Status"].(float64); ok {
+		download.Status = downloadStatus(status) // otherwise success, only successes were stored
+	}
+	if timeString, ok := doc["Time"].(string); ok {
+		download.Time = tiedotParseTime(timeString)
+	}
+	return download
+}
+
+func tiedotParseTime(timeString string) time.Time {
+	// time.String() includes the monotonic clock reading, which can't be parsed back
+	timeString, _, _ = strings.Cut(timeString, " m=")
+	parsed, _ := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", timeString)
+	return parsed
+}
+
+func (s *tiedotStore) findDownloads(field string, value string) ([]*downloadItem, error) {
+	query := []interface{}{
+		map[string]interface{}{"eq": value, "in": []interface{}{field}},
+	}
+	queryResult := make(map[int]struct{})
+	if err := db.EvalQuery(query, s.db.Use("Downloads"), &queryResult); err != nil {
+		return nil, err
+	}
+
+	downloads := make([]*downloadItem, 0)
+	for id := range queryResult {
+		doc, err := s.db.Use("Downloads").Read(id)
+		if err != nil {
+			return downloads, err
+		}
+		downloads = append(downloads, tiedotDocToDownload(id, doc))
+	}
+	return downloads, nil
+}
+
+func (s *tiedotStore) FindDownloadsByURL(inputURL string) ([]*downloadItem, error) {
+	return s.findDownloads("URL", inputURL)
+}
+
+func (s *tiedotStore) FindDownloadsByHash(contentHash string) ([]*downloadItem, error) {
+	return s.findDownloads("Hash", contentHash)
+}
+
+func (s *tiedotStore) FindDownloadsByChannelID(channelID string) ([]*downloadItem, error) {
+	return s.findDownloads("ChannelID", channelID)
+}
+
+func (s *tiedotStore) FindDownloadByID(id int) (*downloadItem, error) {
+	doc, err := s.db.Use("Downloads").Read(id)
+	if dberr.Type(err) == dberr.ErrorNoDoc {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return tiedotDocToDownload(id, doc), nil
+}
+
+func (s *tiedotStore) DeleteDownloadsByChannelID(channelID string) error {
+	query := []interface{}{
+		map[string]interface{}{"eq": channelID, "in": []interface{}{"ChannelID"}},
+	}
+	queryResult := make(map[int]struct{})
+	if err := db.EvalQuery(query, s.db.Use("Downloads"), &queryResult); err != nil {
+		return err
+	}
+	for id := range queryResult {
+		s.db.Use("Downloads").Delete(id)
+	}
+	s.tallyMutex.Lock()
+	s.tallySeeded = false // rare, counted again when next needed
+	s.tallyMutex.Unlock()
+	return nil
+}
+
+func (s *tiedotStore) ForEachDownload(fn func(download *downloadItem) bool) error {
+	var decodeErr error
+	s.db.Use("Downloads").ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(docContent, &doc); err != nil {
+			decodeErr = err
+			return false
+		}
+		return fn(tiedotDocToDownload(id, doc))
+	})
+	return decodeErr
+}
+
+// IDs are random so what's new is told by time, only read in full for documents that are. Records get their
+// time just before they're stored, so the next pass starts a little before this one did.
+func (s *tiedotStore) ForEachDownloadAfter(cursor downloadCursor, fn func(download *downloadItem) bool) (downloadCursor, error) {
+	next := downloadCursor{Time: time.Now().Add(-time.Minute)}
+	if next.Time.Before(cursor.Time) {
+		next.Time = cursor.Time
+	}
+	var decodeErr error
+	s.db.Use("Downloads").ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		if !cursor.Time.IsZero() {
+			var stamp struct{ Time string }
+			if json.Unmarshal(docContent, &stamp) == nil && !tiedotParseTime(stamp.Time).After(cursor.Time) {
+				return true
+			}
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(docContent, &doc); err != nil {
+			decodeErr = err
+			return false
+		}
+		return fn(tiedotDocToDownload(id, doc))
+	})
+	if decodeErr != nil {
+		return cursor, decodeErr
+	}
+	return next, nil
+}
+
+// Places the download by time in a list of the newest, keeping at most limit.
+func insertRecentDownload(recent []*downloadItem, download *downloadItem, limit int) []*downloadItem {
+	i := sort.Search(len(recent), func(i int) bool { return recent[i].Time.Before(download.Time) })
+	if i < limit {
+		if len(recent) < limit {
+			recent = append(recent, nil)
+		}
+		copy(recent[i+1:], recent[i:])
+		recent[i] = download
+	}
+	return recent
+}
+
+// Document IDs are random, so the newest can only be found by time. Kept from seeding on up to tiedotRecentMax.
+func (s *tiedotStore) RecentDownloads(limit int) ([]*downloadItem, error) {
+	if limit > tiedotRecentMax {
+		recent := make([]*downloadItem, 0, limit)
+		err := s.ForEachDownload(func(download *downloadItem) bool {
+			recent = insertRecentDownload(recent, download, limit)
+			return true
+		})
+		return recent, err
+	}
+	s.tallyMutex.Lock()
+	defer s.tallyMutex.Unlock()
+	err := s.seedTallies()
+	return append([]*downloadItem{}, s.recent[:min(limit, len(s.recent))]...), err
+}
+
+// Called with tallyMutex held, sign is 1 to count the download & -1 to take it back.
+func (s *tiedotStore) tally(download *downloadItem, sign int) {
+	if download.Status != downloadSuccess {
+		return
+	}
+	add := func(key string) {
+		totals := s.totals[key]
+		totals.Count += sign
+		totals.Bytes += int64(sign) * max(download.Filesize, 0)
+		s.totals[key] = totals
+	}
+	add("")
+	if download.GuildID != "" {
+		add(download.GuildID)
+	}
+	s.channels[download.ChannelID] += sign
+}
+
+// No aggregation in tiedot, every document is read the first time & tallied from then on.
+func (s *tiedotStore) seedTallies() error {
+	if s.tallySeeded {
+		return nil
+	}
+	s.records = 0
+	s.totals = map[string]downloadTotals{}
+	s.channels = map[string]int{}
+	s.recent = nil
+	err := s.ForEachDownload(func(download *downloadItem) bool {
+		s.records++
+		s.tally(download, 1)
+		s.recent = insertRecentDownload(s.recent, download, tiedotRecentMax)
+		return true
+	})
+	s.tallySeeded = err == nil
+	return err
+}
+
+func (s *tiedotStore) CountRecords() (int, error) {
+	s.tallyMutex.Lock()
+	defer s.tallyMutex.Unlock()
+	err := s.seedTallies()
+	return s.records, err
+}
+
+func (s *tiedotStore) CountDownloads() (int, error) {
+	totals, err := s.DownloadTotals("")
+	return totals.Count, err
+}
+
+func (s *tiedotStore) CountDownloadsByChannel(channelID string) (int, error) {
+	s.tallyMutex.Lock()
+	defer s.tallyMutex.Unlock()
+	err := s.seedTallies()
+	return s.channels[channelID], err
+}
+
+func (s *tiedotStore) DownloadTotals(guildID string) (downloadTotals, error) {
+	s.tallyMutex.Lock()
+	defer s.tallyMutex.Unlock()
+	err := s.seedTallies()
+	return s.totals[guildID], err
+}
+
+//#endregion
+
+//#region Queue
+
+func (s *tiedotStore) InsertQueueItem(itemJSON string) (int, error) {
+	return s.db.Use("Queue").Insert(map[string]interface{}{
+		"Item": itemJSON,
+	})
+}
+
+func (s *tiedotStore) DeleteQueueItem(id int) error {
+	return s.db.Use("Queue").Delete(id)
+}
+
+func (s *tiedotStore) ForEachQueueItem(fn func(id int, itemJSON string) bool) error {
+	s.db.Use("Queue").ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(docContent, &doc); err != nil {
+			return true
+		}
+		itemJSON, ok := doc["Item"].(string)
+		if !ok {
+			return true
+		}
+		return fn(id, itemJSON)
+	})
+	return nil
+}
+
+func (s *tiedotStore) CountQueueItems() (int, error) {
+	i := 0
+	s.db.Use("Queue").ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
+		i++
+		return true
+	})
+	return i, nil
+}
+
+//#endregion
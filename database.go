@@ -2,55 +2,98 @@ package main
 
 import (
 	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"mime"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/HouzuoGuo/tiedot/db"
 	"github.com/fatih/color"
 	"github.com/rivo/duplo"
 )
 
+const (
+	databaseTypeTiedot = "tiedot"
+	databaseTypeSqlite = "sqlite"
+)
+
+// Storage backend for download records & the download queue, selected by the databaseType setting.
+type DownloadStore interface {
+	Name() string
+	Path() string
+	Close() error
+	Backup(w *zip.Writer) error
+
+	InsertDownload(download *downloadItem) error
+	InsertDownloads(downloads []*downloadItem) error
+	UpdateDownload(download *downloadItem) error
+	FindDownloadsByURL(inputURL string) ([]*downloadItem, error)
+	FindDownloadsByHash(contentHash string) ([]*downloadItem, error)
+	FindDownloadsByChannelID(channelID string) ([]*downloadItem, error)
+	FindDownloadByID(id int) (*downloadItem, error) // nil without an error when there's no such record
+	DeleteDownloadsByChannelID(channelID string) error
+	ForEachDownloadAfter(cursor downloadCursor, fn func(download *downloadItem) bool) (downloadCursor, error) // the cursor to pick up from next time
+	ForEachDownload(fn func(download *downloadItem) bool) error
+	RecentDownloads(limit int) ([]*downloadItem, error)    // newest first, every outcome
+	CountRecords() (int, error)                            // every outcome
+	CountDownloads() (int, error)                          // successes only
+	CountDownloadsByChannel(channelID string) (int, error) // successes only
+	DownloadTotals(guildID string) (downloadTotals, error) // successes only, all servers if guildID is empty
+
+	InsertQueueItem(itemJSON string) (int, error)
+	DeleteQueueItem(id int) error
+	ForEachQueueItem(fn func(id int, itemJSON string) bool) error
+	CountQueueItems() (int, error)
+}
+
+// Where a pass over the downloads left off, to only go over what was stored since.
+type downloadCursor struct {
+	ID   int       `json:"id,omitempty"`   // SQLite, ids only go up
+	Time time.Time `json:"time,omitempty"` // tiedot, ids are random
+}
+
+type downloadTotals struct {
+	Count int
+	Bytes int64
+}
+
+func databasePath(databaseType string) string {
+	if databaseType == databaseTypeSqlite {
+		return pathDatabaseBase + ".sqlite"
+	}
+	return pathDatabaseBase
+}
+
+func openDownloadStore(databaseType string) (DownloadStore, error) {
+	switch databaseType {
+	case databaseTypeSqlite:
+		return openSqliteStore(databasePath(databaseType))
+	case databaseTypeTiedot, "":
+		return openTiedotStore(databasePath(databaseType))
+	}
+	return nil, fmt.Errorf("unknown database type \"%s\"", databaseType)
+}
+
 func openDatabase() {
 	var openT time.Time
-	var createT time.Time
 	// Database
-	log.Println(lg("Database", "", color.YellowString, "Opening database...\t(this can take a bit...)"))
+	log.Println(lg("Database", "", color.YellowString, "Opening %s database...\t(this can take a bit...)", config.DatabaseType))
 	openT = time.Now()
-	myDB, err = db.OpenDB(pathDatabaseBase)
+	myDB, err = openDownloadStore(config.DatabaseType)
 	if err != nil {
 		log.Println(lg("Database", "", color.HiRedString, "Unable to open database: %s", err))
 		return
 	}
-	if myDB.Use("Downloads") == nil {
-		log.Println(lg("Database", "Setup", color.YellowString, "Creating database, please wait..."))
-		createT = time.Now()
-		if err := myDB.Create("Downloads"); err != nil {
-			log.Println(lg("Database", "Setup", color.HiRedString, "Error while trying to create database: %s", err))
-			return
-		}
-		log.Println(lg("Database", "Setup", color.HiYellowString, "Created new database...\t(took %s)", timeSinceShort(createT)))
-		//
-		log.Println(lg("Database", "Setup", color.YellowString, "Structuring database, please wait..."))
-		createT = time.Now()
-		indexColumn := func(col string) {
-			if err := myDB.Use("Downloads").Index([]string{col}); err != nil {
-				log.Println(lg("Database", "Setup", color.HiRedString, "Unable to create index for %s: %s", col, err))
-				return
-			}
-		}
-		indexColumn("URL")
-		indexColumn("ChannelID")
-		indexColumn("UserID")
-		log.Println(lg("Database", "Setup", color.HiYellowString, "Created database structure...\t(took %s)", timeSinceShort(createT)))
-	}
 	// Cache download tally
-	cachedDownloadID = dbDownloadCount()
-	log.Println(lg("Database", "", color.HiYellowString, "Database opened, contains %d entries...\t(took %s)", cachedDownloadID, timeSinceShort(openT)))
+	cachedDownloadID.Store(int64(dbDownloadCount()))
+	log.Println(lg("Database", "", color.HiYellowString, "Database opened, contains %d entries...\t(took %s)", cachedDownloadID.Load(), timeSinceShort(openT)))
 
 	// Duplo
 	if config.Duplo || sourceHasDuplo {
@@ -88,35 +131,7 @@ func backupDatabase() error {
 	w := zip.NewWriter(file)
 	defer w.Close()
 
-	err = filepath.Walk(pathDatabaseBase, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		// Ensure that `path` is not absolute; it should not start with "/".
-		// This snippet happens to work because I don't use
-		// absolute paths, but ensure your real-world code
-		// transforms path into a zip-root relative path.
-		f, err := w.Create(path)
-		if err != nil {
-			return err
-		}
-
-		_, err = io.Copy(f, file)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
+	err = myDB.Backup(w)
 	if err != nil {
 		return err
 	}
@@ -126,55 +141,83 @@ func backupDatabase() error {
 //#region Database Utility
 
 func dbInsertDownload(download *downloadItem) error {
-	_, err := myDB.Use("Downloads").Insert(map[string]interface{}{
-		"URL":         download.URL,
-		"Time":        download.Time.String(),
-		"Destination": download.Destination,
-		"Filename":    download.Filename,
-		"ChannelID":   download.ChannelID,
-		"UserID":      download.UserID,
-	})
-	return err
+	return myDB.InsertDownload(download)
 }
 
-func dbFindDownloadByID(id int) *downloadItem {
-	downloads := myDB.Use("Downloads")
-	readBack, err := downloads.Read(id)
+func dbFindDownloadByURL(inputURL string) []*downloadItem {
+	downloads, err := myDB.FindDownloadsByURL(inputURL)
 	if err != nil {
 		log.Println(lg("Database", "Downloads", color.HiRedString, "Failed to read database:\t%s", err))
 	}
-	timeT, _ := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", readBack["Time"].(string))
-	return &downloadItem{
-		URL:         readBack["URL"].(string),
-		Time:        timeT,
-		Destination: readBack["Destination"].(string),
-		Filename:    readBack["Filename"].(string),
-		ChannelID:   readBack["ChannelID"].(string),
-		UserID:      readBack["UserID"].(string),
+	return downloads
+}
+
+func dbFindDownloadsByHash(contentHash string) []*downloadItem {
+	downloads, err := myDB.FindDownloadsByHash(contentHash)
+	if err != nil {
+		log.Println(lg("Database", "Downloads", color.HiRedString, "Failed to read database:\t%s", err))
 	}
+	return downloads
 }
 
-func dbFindDownloadByURL(inputURL string) []*downloadItem {
-	var query interface{}
-	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["URL"]}]`, inputURL)), &query)
-	queryResult := make(map[int]struct{})
-	db.EvalQuery(query, myDB.Use("Downloads"), &queryResult)
+func dbFindDownloadsByChannelID(channelID string) []*downloadItem {
+	downloads, err := myDB.FindDownloadsByChannelID(channelID)
+	if err != nil {
+		log.Println(lg("Database", "Downloads", color.HiRedString, "Failed to read database:\t%s", err))
+	}
+	return downloads
+}
 
-	downloadedImages := make([]*downloadItem, 0)
-	for id := range queryResult {
-		downloadedImages = append(downloadedImages, dbFindDownloadByID(id))
+func dbFindDownloadByID(id int) *downloadItem {
+	download, err := myDB.FindDownloadByID(id)
+	if err != nil {
+		log.Println(lg("Database", "Downloads", color.HiRedString, "Failed to read database:\t%s", err))
 	}
-	return downloadedImages
+	return download
+}
+
+func dbUpdateDownload(download *downloadItem) error {
+	return myDB.UpdateDownload(download)
 }
 
 func dbDeleteByChannelID(channelID string) {
-	var query interface{}
-	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["ChannelID"]}]`, channelID)), &query)
-	queryResult := make(map[int]struct{})
-	db.EvalQuery(query, myDB.Use("Downloads"), &queryResult)
-	for id := range queryResult {
-		myDB.Use("Downloads").Delete(id)
+	if err := myDB.DeleteDownloadsByChannelID(channelID); err != nil {
+		log.Println(lg("Database", "Downloads", color.HiRedString, "Failed to delete from database:\t%s", err))
+	}
+}
+
+//#endregion
+
+//#region Download Queue
+
+func dbInsertQueueItem(itemJSON string) (int, error) {
+	return myDB.InsertQueueItem(itemJSON)
+}
+
+func dbDeleteQueueItem(id int) error {
+	return myDB.DeleteQueueItem(id)
+}
+
+func dbGetQueueItems() []*queuedDownload {
+	items := make([]*queuedDownload, 0)
+	err := myDB.ForEachQueueItem(func(id int, itemJSON string) bool {
+		var item queuedDownload
+		if err := json.Unmarshal([]byte(itemJSON), &item); err != nil {
+			log.Println(lg("Database", "Queue", color.HiRedString, "Failed to decode queue item %d:\t%s", id, err))
+			return true
+		}
+		item.DatabaseID = id
+		items = append(items, &item)
+		return true
+	})
+	if err != nil {
+		log.Println(lg("Database", "Queue", color.HiRedString, "Failed to read download queue:\t%s", err))
 	}
+	// Oldest first, document order isn't guaranteed
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Queued.Before(items[j].Queued)
+	})
+	return items
 }
 
 //#endregion
@@ -182,25 +225,199 @@ func dbDeleteByChannelID(channelID string) {
 //#region Statistics
 
 func dbDownloadCount() int {
-	i := 0
-	myDB.Use("Downloads").ForEachDoc(func(id int, docContent []byte) (willMoveOn bool) {
-		i++
+	count, err := myDB.CountDownloads()
+	if err != nil {
+		log.Println(lg("Database", "Downloads", color.HiRedString, "Failed to count downloads:\t%s", err))
+	}
+	return count
+}
+
+func dbDownloadCountByChannel(channelID string) int {
+	count, err := myDB.CountDownloadsByChannel(channelID)
+	if err != nil {
+		log.Println(lg("Database", "Downloads", color.HiRedString, "Failed to count downloads:\t%s", err))
+	}
+	return count
+}
+
+func dbDownloadTotals(guildID string) downloadTotals {
+	totals, err := myDB.DownloadTotals(guildID)
+	if err != nil {
+		log.Println(lg("Database", "Downloads", color.HiRedString, "Failed to total downloads:\t%s", err))
+	}
+	return totals
+}
+
+func dbRecentDownloads(limit int) []*downloadItem {
+	downloads, err := myDB.RecentDownloads(limit)
+	if err != nil {
+		log.Println(lg("Database", "Downloads", color.HiRedString, "Failed to read recent downloads:\t%s", err))
+	}
+	return downloads
+}
+
+//#endregion
+
+//#region Backfill
+
+// Fills in what can still be worked out for records stored before they held more than the basics.
+// Older records have a filesize of 0, anything unrecoverable is set to -1 so it isn't checked again.
+func dbBackfillDownloads() {
+	var pending []*downloadItem
+	err := myDB.ForEachDownload(func(download *downloadItem) bool {
+		if download.Status == downloadSuccess && download.Filesize == 0 && download.Hash == "" { // newer records have a hash
+			pending = append(pending, download)
+		}
 		return true
 	})
-	return i
+	if err != nil {
+		log.Println(lg("Database", "Backfill", color.HiRedString, "Failed to read database:\t%s", err))
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+	log.Println(lg("Database", "Backfill", color.YellowString,
+		"Backfilling details for %d older download record%s...", len(pending), pluralS(len(pending))))
+
+	backfillT := time.Now()
+	updated := 0
+	for _, download := range pending {
+		download.Filesize = -1
+		if info, err := os.Stat(download.Destination); err == nil && info.Mode().IsRegular() {
+			download.Filesize = info.Size()
+			if download.Filesize == 0 { // nothing to hash, but marks it as done
+				emptyHash := sha256.Sum256(nil)
+				download.Hash = hex.EncodeToString(emptyHash[:])
+			}
+		}
+		if download.Domain == "" {
+			if parsedURL, err := url.Parse(download.URL); err == nil {
+				download.Domain = parsedURL.Hostname()
+			}
+		}
+		if download.ContentType == "" {
+			download.ContentType = mime.TypeByExtension(strings.ToLower(filepath.Ext(download.Destination)))
+		}
+		if download.GuildID == "" && download.ChannelID != "" && download.ChannelID != "0" {
+			if channel, err := bot.State.Channel(download.ChannelID); err == nil {
+				download.GuildID = channel.GuildID
+			}
+		}
+		if err := dbUpdateDownload(download); err != nil {
+			log.Println(lg("Database", "Backfill", color.HiRedString, "Failed to update record %d:\t%s", download.ID, err))
+			continue
+		}
+		updated++
+	}
+	log.Println(lg("Database", "Backfill", color.HiYellowString,
+		"Backfilled %d download record%s\t(took %s)", updated, pluralS(updated), timeSinceShort(backfillT)))
 }
 
-func dbDownloadCountByChannel(channelID string) int {
-	var query interface{}
-	json.Unmarshal([]byte(fmt.Sprintf(`[{"eq": "%s", "in": ["ChannelID"]}]`, channelID)), &query)
-	queryResult := make(map[int]struct{})
-	db.EvalQuery(query, myDB.Use("Downloads"), &queryResult)
+//#endregion
+
+//#region Migration
 
-	downloadedImages := make([]*downloadItem, 0)
-	for id := range queryResult {
-		downloadedImages = append(downloadedImages, dbFindDownloadByID(id))
+// Copies everything from the tiedot database into a new SQLite one, then verifies the row counts match.
+func migrateDatabase() error {
+	const batchSize = 5000
+
+	log.Println(lg("Database", "Migrate", color.YellowString, "Opening %s database \"%s\"...",
+		databaseTypeTiedot, databasePath(databaseTypeTiedot)))
+	if _, err := os.Stat(databasePath(databaseTypeTiedot)); err != nil {
+		return fmt.Errorf("no %s database to migrate: %s", databaseTypeTiedot, err)
 	}
-	return len(downloadedImages)
+	source, err := openDownloadStore(databaseTypeTiedot)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	log.Println(lg("Database", "Migrate", color.YellowString, "Opening %s database \"%s\"...",
+		databaseTypeSqlite, databasePath(databaseTypeSqlite)))
+	target, err := openDownloadStore(databaseTypeSqlite)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	if existing, err := target.CountRecords(); err != nil {
+		return err
+	} else if existing > 0 {
+		return fmt.Errorf("%s database already contains %d downloads, move it aside to migrate again",
+			databaseTypeSqlite, existing)
+	}
+
+	// Downloads
+	migrateT := time.Now()
+	copied := 0
+	batch := make([]*downloadItem, 0, batchSize)
+	var insertErr error
+	flush := func() bool {
+		if insertErr = target.InsertDownloads(batch); insertErr != nil {
+			return false
+		}
+		copied += len(batch)
+		batch = batch[:0]
+		log.Println(lg("Database", "Migrate", color.CyanString, "Copied %d downloads...", copied))
+		return true
+	}
+	err = source.ForEachDownload(func(download *downloadItem) bool {
+		batch = append(batch, download)
+		if len(batch) == batchSize {
+			return flush()
+		}
+		return true
+	})
+	if err == nil && insertErr == nil && len(batch) > 0 {
+		flush()
+	}
+	if err != nil {
+		return err
+	}
+	if insertErr != nil {
+		return insertErr
+	}
+
+	// Queue
+	err = source.ForEachQueueItem(func(id int, itemJSON string) bool {
+		_, insertErr = target.InsertQueueItem(itemJSON)
+		return insertErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if insertErr != nil {
+		return insertErr
+	}
+
+	// Verify
+	sourceDownloads, err := source.CountRecords()
+	if err != nil {
+		return err
+	}
+	targetDownloads, err := target.CountRecords()
+	if err != nil {
+		return err
+	}
+	sourceQueue, err := source.CountQueueItems()
+	if err != nil {
+		return err
+	}
+	targetQueue, err := target.CountQueueItems()
+	if err != nil {
+		return err
+	}
+	if sourceDownloads != targetDownloads || sourceQueue != targetQueue {
+		return fmt.Errorf("row counts do not match after copying, downloads %d -> %d, queue %d -> %d",
+			sourceDownloads, targetDownloads, sourceQueue, targetQueue)
+	}
+
+	log.Println(lg("Database", "Migrate", color.HiGreenString,
+		"Migrated %d downloads & %d queued items, row counts match\t(took %s)",
+		targetDownloads, targetQueue, timeSinceShort(migrateT)))
+	log.Println(lg("Database", "Migrate", color.HiGreenString,
+		"Set \"databaseType\" to \"%s\" in your settings to use the new database", databaseTypeSqlite))
+	return nil
 }
 
 //#endregion
@@ -298,21 +298,21 @@ func dataKeys(input string) string {
 				fmt.Sprint(len(config.Admins))},
 			//TODO: redo time stuff
 			{"{{timeSavedShort}}",
-				timeLastUpdated.Format("3:04pm")},
+				timeLastUpdated.get().Format("3:04pm")},
 			{"{{timeSavedShortTZ}}",
-				timeLastUpdated.Format("3:04pm MST")},
+				timeLastUpdated.get().Format("3:04pm MST")},
 			{"{{timeSavedMid}}",
-				timeLastUpdated.Format("3:04pm MST 1/2/2006")},
+				timeLastUpdated.get().Format("3:04pm MST 1/2/2006")},
 			{"{{timeSavedLong}}",
-				timeLastUpdated.Format("3:04:05pm MST - January 2, 2006")},
+				timeLastUpdated.get().Format("3:04:05pm MST - January 2, 2006")},
 			{"{{timeSavedShort24}}",
-				timeLastUpdated.Format("15:04")},
+				timeLastUpdated.get().Format("15:04")},
 			{"{{timeSavedShortTZ24}}",
-				timeLastUpdated.Format("15:04 MST")},
+				timeLastUpdated.get().Format("15:04 MST")},
 			{"{{timeSavedMid24}}",
-				timeLastUpdated.Format("15:04 MST 2/1/2006")},
+				timeLastUpdated.get().Format("15:04 MST 2/1/2006")},
 			{"{{timeSavedLong24}}",
-				timeLastUpdated.Format("15:04:05 MST - 2 January, 2006")},
+				timeLastUpdated.get().Format("15:04:05 MST - 2 January, 2006")},
 			{"{{timeNowShort}}",
 				timeNow.Format("3:04pm")},
 			{"{{timeNowShortTZ}}",
@@ -425,6 +425,11 @@ func dataKeysDownload(input string, sourceConfig configurationSource, download d
 			filesize = humanize.Bytes(uint64(fileinfo.Size()))
 		}
 
+		thumbPath := ""
+		if download.Record != nil {
+			thumbPath = download.Record.ThumbPath
+		}
+
 		fmt_msg := download.Message.Content
 		if buildingFilename {
 			fmt_msg = clearPathIllegalChars(download.Message.Content)
@@ -439,6 +444,7 @@ func dataKeysDownload(input string, sourceConfig configurationSource, download d
 			{"{{file}}", download.Filename},
 			{"{{fileType}}", download.Extension},
 			{"{{fileSize}}", filesize},
+			{"{{thumbPath}}", thumbPath},
 			{"{{attachmentID}}", download.AttachmentID},
 			{"{{messageID}}", download.Message.ID},
 			{"{{userID}}", userID},
@@ -635,8 +641,8 @@ func updateDiscordPresence() {
 		countInt := int64(dbDownloadCount()) + *config.InflateDownloadCount
 		count := formatNumber(countInt)
 		countShort := formatNumberShort(countInt)
-		timeShort := timeLastUpdated.Format("3:04pm")
-		timeLong := timeLastUpdated.Format("3:04:05pm MST - January 2, 2006")
+		timeShort := timeLastUpdated.get().Format("3:04pm")
+		timeLong := timeLastUpdated.get().Format("3:04:05pm MST - January 2, 2006")
 
 		// Defaults
 		status := fmt.Sprintf("%s - %s files", timeShort, countShort)
@@ -1258,9 +1264,11 @@ do_discord_login:
 	botCommands = handleCommands()
 	bot.AddHandler(messageCreate)
 	bot.AddHandler(messageUpdate)
+	bot.AddHandler(gatewayDisconnected)
+	bot.AddHandler(gatewayReady)
 
 	// Start Presence
-	timeLastUpdated = time.Now()
+	timeLastUpdated.set(time.Now())
 	go updateDiscordPresence()
 
 	//(SV) Source Validation
@@ -1,8 +1,13 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash"
 	"image"
 	"io"
 	"io/fs"
@@ -16,6 +21,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -34,12 +40,22 @@ type downloadedItem struct {
 }
 
 type downloadItem struct {
-	URL         string
-	Time        time.Time
-	Destination string
-	Filename    string
-	ChannelID   string
-	UserID      string
+	ID           int // assigned by the database
+	URL          string
+	Time         time.Time
+	Destination  string
+	Filename     string
+	ChannelID    string
+	UserID       string
+	Hash         string // SHA-256 of the file content
+	MessageID    string
+	GuildID      string
+	AttachmentID string
+	ContentType  string
+	Filesize     int64 // -1 when unknown
+	Domain       string
+	Status       downloadStatus
+	ThumbPath    string // empty when none was made
 }
 
 type downloadStatus int
@@ -57,6 +73,9 @@ const (
 	downloadSkippedUnpermittedReaction
 	downloadSkippedUnpermittedType
 	downloadSkippedDetectedDuplicate
+	downloadSkippedDuplicateContent
+	downloadSkippedDuplicateContentHardlinked
+	downloadSkippedDuplicateContentSymlinked
 
 	downloadFailed
 	downloadFailedCode
@@ -71,6 +90,25 @@ const (
 	downloadFailedCreatingSubfolder
 	downloadFailedWritingFile
 	downloadFailedWritingDatabase
+
+	// Stored in the database, so newer statuses are appended rather than grouped
+	downloadSkippedRule
+	downloadSkippedUnpermittedSize
+	downloadSkippedUnpermittedDimensions
+	downloadSkippedUnpermittedDuration
+)
+
+// Failures are the block from downloadFailed, anything appended after it is a skip.
+func isDownloadFailed(status downloadStatus) bool {
+	return status >= downloadFailed && status <= downloadFailedWritingDatabase
+}
+
+// Source policies for files byte-identical to one already in the database
+const (
+	duplicateContentSave     = "save"
+	duplicateContentSkip     = "skip"
+	duplicateContentHardlink = "hardlink"
+	duplicateContentSymlink  = "symlink"
 )
 
 type downloadStatusStruct struct {
@@ -122,6 +160,20 @@ func getDownloadStatus(status downloadStatus) string {
 		return "Skipped - Unpermitted File Type"
 	case downloadSkippedDetectedDuplicate:
 		return "Skipped - Detected Duplicate"
+	case downloadSkippedDuplicateContent:
+		return "Skipped - Duplicate Content"
+	case downloadSkippedDuplicateContentHardlinked:
+		return "Skipped - Duplicate Content, Hard-Linked"
+	case downloadSkippedDuplicateContentSymlinked:
+		return "Skipped - Duplicate Content, Symlinked"
+	case downloadSkippedRule:
+		return "Skipped - Matched Skip Rule"
+	case downloadSkippedUnpermittedSize:
+		return "Skipped - Unpermitted File Size"
+	case downloadSkippedUnpermittedDimensions:
+		return "Skipped - Unpermitted Media Dimensions"
+	case downloadSkippedUnpermittedDuration:
+		return "Skipped - Unpermitted Media Duration"
 	//
 	case downloadFailed:
 		return "Failed"
@@ -153,8 +205,17 @@ func getDownloadStatus(status downloadStatus) string {
 	return "Unknown Error"
 }
 
+// Whether a recorded outcome means the link doesn't need downloading again.
+// Filter skips aren't, the filters may have changed since.
+func isDownloadComplete(status downloadStatus) bool {
+	return status == downloadSuccess ||
+		status == downloadSkippedDuplicateContent ||
+		status == downloadSkippedDuplicateContentHardlinked ||
+		status == downloadSkippedDuplicateContentSymlinked
+}
+
 func getDownloadStatusShort(status downloadStatus) string {
-	if status >= downloadFailed {
+	if isDownloadFailed(status) {
 		return "FAILED"
 	} else if status >= downloadSkipped {
 		return "SKIPPED"
@@ -205,7 +266,7 @@ func pruneCompletedLinks(linkList map[string]string, m *discordgo.Message) map[s
 		}
 
 		for _, downloadedFile := range dbFindDownloadByURL(testLink) {
-			if downloadedFile.ChannelID == m.ChannelID {
+			if downloadedFile.ChannelID == m.ChannelID && isDownloadComplete(downloadedFile.Status) {
 				alreadyDownloaded = true
 			}
 		}
@@ -560,6 +621,307 @@ func getLinksByMessage(m *discordgo.Message) []*fileItem {
 	return trimDuplicateLinks(fileItems)
 }
 
+// Bytes of a response held in memory for content sniffing, http.DetectContentType never reads more than this.
+const downloadSniffLen = 512
+
+// Wraps a response body so read failures can be told apart from write failures while streaming.
+type downloadBodyReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *downloadBodyReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// Streams into a hidden temporary file within folder (so the final rename stays on one filesystem).
+// The caller is responsible for renaming or removing the returned path.
+func streamToTempFile(source io.Reader, folder string) (string, error) {
+	f, err := os.CreateTemp(folder, ".ddg-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, source)
+	if err == nil { // temp files are owner-only, saved files are readable like before
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Renames into place, copying when that can't be done like across mounts.
+func moveFile(source string, destination string) error {
+	err := os.Rename(source, destination)
+	if err == nil {
+		return nil
+	}
+	in, openErr := os.Open(source)
+	if openErr != nil {
+		return err
+	}
+	defer in.Close()
+	out, createErr := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if createErr != nil {
+		return err
+	}
+	_, copyErr := io.Copy(out, in)
+	if closeErr := out.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr != nil {
+		os.Remove(destination)
+		return copyErr
+	}
+	in.Close()
+	os.Remove(source)
+	return nil
+}
+
+// Refuses images declaring more than imageDecodeMaxPixels, a small file can claim enough to run out of memory.
+func decodeImageFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	imgConfig, _, err := image.DecodeConfig(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	if pixels := int64(imgConfig.Width) * int64(imgConfig.Height); pixels > imageDecodeMaxPixels {
+		return nil, fmt.Errorf("%dx%d is too large to decode", imgConfig.Width, imgConfig.Height)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bufio.NewReader(f))
+	return img, err
+}
+
+func hashFileInto(h hash.Hash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// Finds a previously downloaded copy of identical content that still exists on disk (and isn't itself a link).
+func findExistingContent(contentHash string) *downloadItem {
+	for _, existing := range dbFindDownloadsByHash(contentHash) {
+		if info, err := os.Lstat(existing.Destination); err == nil && info.Mode().IsRegular() {
+			return existing
+		}
+	}
+	return nil
+}
+
+//#region Partial Downloads
+
+// Sidecar metadata kept next to a .part file so an interrupted download can be resumed.
+type partialDownloadMeta struct {
+	URL           string    `json:"url"`
+	ETag          string    `json:"etag,omitempty"`
+	LastModified  string    `json:"lastModified,omitempty"`
+	BytesReceived int64     `json:"bytesReceived"`
+	Updated       time.Time `json:"updated"`
+}
+
+type partialDownload struct {
+	Path     string
+	MetaPath string
+	Meta     partialDownloadMeta
+	unlock   func() // held from opening until release, workers fetching the same URL into the same folder take turns
+}
+
+// Locks by key across workers, for part files, final paths & content hashes. Returns the unlock.
+type downloadLock struct {
+	sync.Mutex
+	users int
+}
+
+var (
+	downloadLocksMutex sync.Mutex
+	downloadLocks      = map[string]*downloadLock{}
+)
+
+func lockDownload(key string) func() {
+	downloadLocksMutex.Lock()
+	lock := downloadLocks[key]
+	if lock == nil {
+		lock = &downloadLock{}
+		downloadLocks[key] = lock
+	}
+	lock.users++
+	downloadLocksMutex.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		downloadLocksMutex.Lock()
+		if lock.users--; lock.users == 0 {
+			delete(downloadLocks, key)
+		}
+		downloadLocksMutex.Unlock()
+	}
+}
+
+// Part files are keyed by URL within the base destination folder, since the final filename & subfolders
+// are not known until the response arrives. Routes may move the file elsewhere once it's complete.
+// Must be released when done with.
+func openPartialDownload(folder string, inputURL string) *partialDownload {
+	key := sha1.Sum([]byte(inputURL))
+	p := &partialDownload{
+		Path: filepath.Join(folder, ".ddg-"+hex.EncodeToString(key[:])+".part"),
+	}
+	p.MetaPath = p.Path + ".json"
+
+	p.unlock = lockDownload("part:" + p.Path)
+
+	p.Meta.URL = inputURL
+
+	metaBytes, err := os.ReadFile(p.MetaPath)
+	if err != nil {
+		os.Remove(p.Path) // no metadata, nothing to validate against
+		return p
+	}
+	var meta partialDownloadMeta
+	if err = json.Unmarshal(metaBytes, &meta); err != nil || meta.URL != inputURL {
+		p.reset()
+		return p
+	}
+	p.Meta = meta
+	// What's on disk is authoritative, the metadata may be stale if the program was killed mid-transfer.
+	p.Meta.BytesReceived = 0
+	if info, err := os.Stat(p.Path); err == nil {
+		p.Meta.BytesReceived = info.Size()
+	}
+	return p
+}
+
+// Only resume when there's a validator, otherwise a changed file could be silently spliced together.
+func (p *partialDownload) canResume() bool {
+	return p.Meta.BytesReceived > 0 && (p.ifRange() != "")
+}
+
+func (p *partialDownload) ifRange() string {
+	if p.Meta.ETag != "" && !strings.HasPrefix(p.Meta.ETag, "W/") {
+		return p.Meta.ETag
+	}
+	return p.Meta.LastModified
+}
+
+func (p *partialDownload) applyRange(request *http.Request) {
+	if p.canResume() {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", p.Meta.BytesReceived))
+		request.Header.Set("If-Range", p.ifRange())
+	}
+}
+
+// Checks whether a response continues the existing part file. Anything else means starting from zero.
+func (p *partialDownload) continuedBy(response *http.Response) bool {
+	if response.StatusCode != http.StatusPartialContent || !p.canResume() {
+		return false
+	}
+	if etag := response.Header.Get("ETag"); etag != "" && p.Meta.ETag != "" && etag != p.Meta.ETag {
+		return false
+	}
+	// Content-Range: bytes <start>-<end>/<total>
+	contentRange := strings.TrimPrefix(response.Header.Get("Content-Range"), "bytes ")
+	start, _, found := strings.Cut(contentRange, "-")
+	if !found {
+		return false
+	}
+	startByte, err := strconv.ParseInt(start, 10, 64)
+	return err == nil && startByte == p.Meta.BytesReceived
+}
+
+func (p *partialDownload) save() error {
+	p.Meta.Updated = time.Now()
+	metaBytes, err := json.Marshal(p.Meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.MetaPath, metaBytes, 0644)
+}
+
+func (p *partialDownload) release() {
+	p.unlock()
+}
+
+func (p *partialDownload) reset() {
+	os.Remove(p.Path)
+	os.Remove(p.MetaPath)
+	p.Meta = partialDownloadMeta{URL: p.Meta.URL}
+}
+
+// Reads the start of the existing part file, topped up from the body if the part is shorter than n.
+func (p *partialDownload) prefix(body *bufio.Reader, n int) ([]byte, error) {
+	f, err := os.Open(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, n)
+	read, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:read]
+	if read < n {
+		rest, err := body.Peek(n - read)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		head = append(head, rest...)
+	}
+	return head, nil
+}
+
+// Streams the body into the part file, appending when resuming.
+// Metadata is written before and after so the progress survives failures and restarts.
+func (p *partialDownload) stream(source io.Reader, response *http.Response, resuming bool) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resuming {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	} else {
+		p.Meta.BytesReceived = 0
+		p.Meta.ETag = response.Header.Get("ETag")
+		p.Meta.LastModified = response.Header.Get("Last-Modified")
+	}
+	if err := p.save(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p.Path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, source)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if info, statErr := os.Stat(p.Path); statErr == nil {
+		p.Meta.BytesReceived = info.Size()
+	}
+	if saveErr := p.save(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+//#endregion
+
 type downloadRequestStruct struct {
 	InputURL       string
 	Filename       string
@@ -573,23 +935,101 @@ type downloadRequestStruct struct {
 	ManualDownload bool
 	StartTime      time.Time
 	AttachmentID   string
+	FilteredOut    bool          // filters would've ignored it, left for rules to decide
+	DryRun         bool          // plan & report it instead, see dryrun.go
+	Record         *downloadItem `json:"-"` // database record, filled in as the download progresses
+}
+
+func (download downloadRequestStruct) newRecord() *downloadItem {
+	record := &downloadItem{
+		URL:          download.InputURL,
+		Filename:     download.Filename,
+		ChannelID:    "0",
+		AttachmentID: download.AttachmentID,
+		Filesize:     -1,
+	}
+	if botUser != nil {
+		record.UserID = botUser.ID
+	}
+	if parsedURL, err := url.Parse(download.InputURL); err == nil {
+		record.Domain = parsedURL.Hostname()
+	}
+	if !download.EmojiCmd && download.Message != nil {
+		record.ChannelID = download.Message.ChannelID
+		record.MessageID = download.Message.ID
+		record.GuildID = download.Message.GuildID
+		if record.GuildID == "" { // history messages don't include it
+			if channel, err := bot.State.Channel(download.Message.ChannelID); err == nil {
+				record.GuildID = channel.GuildID
+			}
+		}
+		if download.Message.Author != nil {
+			record.UserID = download.Message.Author.ID
+		}
+	}
+	return record
 }
 
 func (download downloadRequestStruct) handleDownload() (downloadStatusStruct, int64) {
 	status := mDownloadStatus(downloadFailed)
 	var tempfilesize int64 = -1
+	download.Record = download.newRecord()
+
+	// Dry runs only report, one attempt tells what would happen
+	if download.DryRun {
+		status, tempfilesize = download.tryDownload()
+		dryRunReportDownload(download, status)
+		return status, tempfilesize
+	}
+
 	for i := 0; i < config.DownloadRetryMax; i++ {
+		if i > 0 {
+			metrics.observeRetry()
+		}
+		attemptStart := time.Now()
 		status, tempfilesize = download.tryDownload()
+		metrics.observeAttempt(status.Status, time.Since(attemptStart))
 		// Success or Skip
-		if status.Status < downloadFailed || status.Status == downloadFailedCode404 {
+		if !isDownloadFailed(status.Status) || status.Status == downloadFailedCode404 {
 			break
 		} else {
 			time.Sleep(5 * time.Second)
 		}
 	}
 
+	metrics.observeDownload(download, status.Status, tempfilesize)
+	webhookDownloadEvent(download, status)
+
+	// Record other outcomes, successes & links were stored along with the file
+	if !download.EmojiCmd && status.Status != downloadIgnored && status.Status != downloadSuccess &&
+		status.Status != downloadSkippedDuplicateContentHardlinked &&
+		status.Status != downloadSkippedDuplicateContentSymlinked &&
+		status.Status != downloadFailedWritingDatabase {
+		alreadyRecorded := false // reruns of history would otherwise pile up identical records
+		for _, existing := range dbFindDownloadByURL(download.InputURL) {
+			if existing.ChannelID == download.Record.ChannelID && existing.Status == status.Status {
+				alreadyRecorded = true
+				break
+			}
+		}
+		if !alreadyRecorded {
+			download.Record.Time = time.Now()
+			download.Record.Status = status.Status
+			if err := dbInsertDownload(download.Record); err != nil {
+				log.Println(lg("Download", "", color.HiRedString, "Error writing to database: %s", err))
+			}
+		}
+	}
+
+	// Partial data is only kept for failures that may succeed on a later run
+	if config.DownloadResume && (!isDownloadFailed(status.Status) || status.Status == downloadFailedCode404) {
+		partial := openPartialDownload(download.Path, download.InputURL)
+		partial.reset()
+		partial.release()
+	}
+
 	// Any kind of failure
-	if status.Status >= downloadFailed && !download.HistoryCmd && !download.EmojiCmd {
+	if isDownloadFailed(status.Status) && !download.HistoryCmd && !download.EmojiCmd {
 		log.Println(lg("Download", "", color.RedString,
 			"Gave up on downloading %s after %d failed attempts...\t%s",
 			download.InputURL, config.DownloadRetryMax, getDownloadStatus(status.Status)))
@@ -692,18 +1132,15 @@ func (download downloadRequestStruct) handleDownload() (downloadStatusStruct, in
 								"Save path %s is invalid... %s", savePath, err))
 						} else {
 							// Format filename
-							filename := download.Message.ChannelID + ".txt"
+							filename := download.Message.ChannelID
 							if sourceConfig.LogLinks.FilenameFormat != nil {
 								if *sourceConfig.LogLinks.FilenameFormat != "" {
 									filename = dataKeys_DiscordMessage(
 										dataKeys_DownloadStatus(*sourceConfig.LogLinks.FilenameFormat, status, download),
 										download.Message)
-									// if extension presumed missing
-									if !strings.Contains(filename, ".") {
-										filename += ".txt"
-									}
 								}
 							}
+							filename = sourceConfig.LogLinks.filename(filename)
 
 							// Scrub filename
 							filename = clearSourceLogField(filename, *sourceConfig.LogLinks)
@@ -756,11 +1193,6 @@ func (download downloadRequestStruct) handleDownload() (downloadStatusStruct, in
 							}
 							newLine += "\n" + prefix + lineContent + suffix
 
-							// Read
-							currentLog := ""
-							if logfile, err := os.ReadFile(logPath); err == nil {
-								currentLog = string(logfile)
-							}
 							canLog := true
 							// Log Failures
 							if status.Status > downloadSuccess {
@@ -768,25 +1200,11 @@ func (download downloadRequestStruct) handleDownload() (downloadStatusStruct, in
 							} else if *sourceConfig.LogLinks.LogDownloads { // Log Downloads
 								canLog = true
 							}
-							// Filter Duplicates
-							if sourceConfig.LogLinks.FilterDuplicates != nil {
-								if *sourceConfig.LogLinks.FilterDuplicates {
-									if strings.Contains(currentLog, newLine) {
-										canLog = false
-									}
-								}
-							}
 
 							if canLog {
-								// Writer
-								f, err := os.OpenFile(logPath, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0600)
-								if err != nil {
-									log.Println(lg("Download", "LogLinks", color.RedString, "[sourceConfig.LogLinks] Failed to open log file:\t%s", err))
-									f.Close()
-								}
-								defer f.Close()
-
-								if _, err = f.WriteString(newLine); err != nil {
+								// Writer, duplicates are filtered in there
+								if err := appendSourceLog(logPath, *sourceConfig.LogLinks, newLine,
+									newSourceLogLinkRecord(download, status)); err != nil {
 									log.Println(lg("Download", "LogLinks", color.RedString, "[sourceConfig.LogLinks] Failed to append file:\t%s", err))
 								}
 							}
@@ -800,10 +1218,27 @@ func (download downloadRequestStruct) handleDownload() (downloadStatusStruct, in
 	return status, tempfilesize
 }
 
+// Swaps the base destination, for rules & routes.
+func (download *downloadRequestStruct) reroute(destination string) error {
+	download.Path = destination
+	if !strings.HasSuffix(download.Path, string(os.PathSeparator)) {
+		download.Path = download.Path + string(os.PathSeparator)
+	}
+	if download.DryRun {
+		return nil
+	}
+	if err := os.MkdirAll(download.Path, 0755); err != nil {
+		log.Println(lg("Download", "", color.HiRedString,
+			"Error while creating routed destination folder \"%s\": %s", download.Path, err))
+		return err
+	}
+	return nil
+}
+
 func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64) {
 	var err error
 
-	cachedDownloadID++
+	downloadID := cachedDownloadID.Add(1)
 
 	logPrefix := ""
 	if download.HistoryCmd {
@@ -812,6 +1247,10 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 
 	var fileinfo fs.FileInfo
 
+	if download.Record == nil {
+		download.Record = download.newRecord()
+	}
+
 	var sourceConfig configurationSource
 	sourceDefault(&sourceConfig)
 	sourceConfigNew := emptySourceConfig
@@ -822,6 +1261,8 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 		sourceConfig = sourceConfigNew
 	}
 	if sourceConfigNew != emptySourceConfig || download.EmojiCmd || download.ManualDownload {
+		hasRules := !download.EmojiCmd && download.Message != nil && sourceHasRules(sourceConfig)
+		domainFiltered := false // with rules, filters only decide when no rule matches
 
 		// Source validation
 		if _, err = url.ParseRequestURI(download.InputURL); err != nil {
@@ -842,18 +1283,20 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 				}
 
 				if sourceConfig.Filters.BlockedDomains != nil {
-					if stringInSlice(domain, *sourceConfig.Filters.BlockedDomains) {
+					if filterDomainAny(domain, *sourceConfig.Filters.BlockedDomains) {
 						shouldAbort = true
 					}
 				}
 				if sourceConfig.Filters.AllowedDomains != nil {
-					if stringInSlice(domain, *sourceConfig.Filters.AllowedDomains) {
+					if filterDomainAny(domain, *sourceConfig.Filters.AllowedDomains) {
 						shouldAbort = false
 					}
 				}
 
 				// Abort
-				if shouldAbort {
+				if shouldAbort && hasRules {
+					domainFiltered = true
+				} else if shouldAbort {
 					if !download.HistoryCmd {
 						log.Println(lg("Download", "Skip", color.GreenString,
 							"Unpermitted domain (%s) found at %s", domain, download.InputURL))
@@ -873,11 +1316,20 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 		}
 
 		// Create folder
-		if err = os.MkdirAll(download.Path, 0755); err != nil {
-			log.Println(lg("Download", "", color.HiRedString,
-				"Error while creating destination folder \"%s\": %s",
-				download.Path, err))
-			return mDownloadStatus(downloadFailedCreatingFolder, err), 0
+		if !download.DryRun {
+			if err = os.MkdirAll(download.Path, 0755); err != nil {
+				log.Println(lg("Download", "", color.HiRedString,
+					"Error while creating destination folder \"%s\": %s",
+					download.Path, err))
+				return mDownloadStatus(downloadFailedCreatingFolder, err), 0
+			}
+		}
+
+		// Resume
+		var partial *partialDownload
+		if config.DownloadResume && !download.DryRun {
+			partial = openPartialDownload(download.Path, download.InputURL)
+			defer partial.release()
 		}
 
 		// Request
@@ -892,6 +1344,9 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 			return mDownloadStatus(downloadFailedRequesting, err), 0
 		}
 		request.Header.Add("Accept-Encoding", "identity")
+		if partial != nil {
+			partial.applyRange(request)
+		}
 		response, err := client.Do(request)
 		if err != nil {
 			if !strings.Contains(err.Error(), "no such host") && !strings.Contains(err.Error(), "connection refused") {
@@ -903,13 +1358,27 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 		}
 		defer response.Body.Close()
 
-		// Read
-		bodyOfResp, err := io.ReadAll(response.Body)
-		if err != nil {
-			log.Println(lg("Download", "", color.HiRedString,
-				"Could not read response from \"%s\": %s",
-				download.InputURL, err))
-			return mDownloadStatus(downloadFailedReadResponse, err), 0
+		// Resume - Check Response
+		resuming := false
+		if partial != nil {
+			resuming = partial.continuedBy(response)
+			if !resuming && partial.Meta.BytesReceived > 0 {
+				if config.Debug {
+					log.Println(lg("Download", "Resume", color.YellowString,
+						"Could not resume from byte %d (%d %s), restarting: %s", partial.Meta.BytesReceived,
+						response.StatusCode, http.StatusText(response.StatusCode), download.InputURL))
+				}
+				partial.reset()
+				// Range was honored but doesn't line up with what we have, the body is useless
+				if response.StatusCode == http.StatusPartialContent ||
+					response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+					return mDownloadStatus(downloadFailedDownloadingResponse,
+						fmt.Errorf("unusable partial response (%d)", response.StatusCode)), 0
+				}
+			} else if resuming && config.Debug {
+				log.Println(lg("Download", "Resume", color.YellowString,
+					"Resuming from byte %d: %s", partial.Meta.BytesReceived, download.InputURL))
+			}
 		}
 
 		// Errors
@@ -933,11 +1402,27 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 			}
 		}
 
+		// Read (only a bounded prefix is held in memory, the rest is streamed to disk below)
+		bodyBuffered := bufio.NewReaderSize(response.Body, downloadSniffLen)
+		var bodyPrefix []byte
+		if resuming {
+			bodyPrefix, err = partial.prefix(bodyBuffered, downloadSniffLen)
+		} else {
+			bodyPrefix, err = bodyBuffered.Peek(downloadSniffLen)
+		}
+		if err != nil && err != io.EOF {
+			log.Println(lg("Download", "", color.HiRedString,
+				"Could not read response from \"%s\": %s",
+				download.InputURL, err))
+			return mDownloadStatus(downloadFailedReadResponse, err), 0
+		}
+
 		// Content Type
-		contentType := http.DetectContentType(bodyOfResp)
+		contentType := http.DetectContentType(bodyPrefix)
 		contentTypeParts := strings.Split(contentType, "/")
 		contentTypeBase := contentTypeParts[0]
 		isHtml := strings.Contains(contentType, "text/html")
+		download.Record.ContentType = contentType
 
 		// Filename
 		if download.Filename == "" {
@@ -957,25 +1442,65 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 			}
 		}
 
+		// Rules
+		ruleMatched, ruleRouted := false, false
+		if hasRules {
+			size := response.ContentLength
+			if resuming && size >= 0 {
+				size += partial.Meta.BytesReceived
+			}
+			rule, _ := evaluateRules(*sourceConfig.Rules, &ruleContext{
+				Message:     download.Message,
+				Link:        download.InputURL,
+				Domain:      domain,
+				History:     download.HistoryCmd,
+				Response:    true,
+				Filename:    download.Filename,
+				Extension:   ruleExtension(download.Filename, contentType),
+				ContentType: contentType,
+				Size:        size,
+			})
+			if rule != nil {
+				switch strings.ToLower(rule.Action) {
+				case ruleActionSkip:
+					if !download.HistoryCmd {
+						log.Println(lg("Download", "Skip", color.GreenString,
+							"Matched skip rule \"%s\" for %s", rule.When, download.InputURL))
+					}
+					return mDownloadStatus(downloadSkippedRule), 0
+				case ruleActionRoute:
+					if err = download.reroute(rule.Destination); err != nil {
+						return mDownloadStatus(downloadFailedCreatingFolder, err), 0
+					}
+					ruleRouted = true
+				}
+				ruleMatched = true
+			} else if download.FilteredOut {
+				return mDownloadStatus(downloadIgnored), 0
+			} else if domainFiltered {
+				if !download.HistoryCmd {
+					log.Println(lg("Download", "Skip", color.GreenString,
+						"Unpermitted domain (%s) found at %s", domain, download.InputURL))
+				}
+				return mDownloadStatus(downloadSkippedUnpermittedDomain), 0
+			}
+		}
+
 		// Check Filename
-		if sourceConfig.Filters.AllowedFilenames != nil || sourceConfig.Filters.BlockedFilenames != nil {
+		if !ruleMatched && (sourceConfig.Filters.AllowedFilenames != nil || sourceConfig.Filters.BlockedFilenames != nil) {
 			shouldAbort := false
 			if sourceConfig.Filters.AllowedFilenames != nil {
 				shouldAbort = true
 			}
 
 			if sourceConfig.Filters.BlockedFilenames != nil {
-				for _, phrase := range *sourceConfig.Filters.BlockedFilenames {
-					if phrase != "" && phrase != " " && strings.Contains(download.Filename, phrase) {
-						shouldAbort = true
-					}
+				if _, found := filterContainsAny(download.Filename, *sourceConfig.Filters.BlockedFilenames); found {
+					shouldAbort = true
 				}
 			}
 			if sourceConfig.Filters.AllowedFilenames != nil {
-				for _, phrase := range *sourceConfig.Filters.AllowedFilenames {
-					if phrase != "" && phrase != " " && strings.Contains(download.Filename, phrase) {
-						shouldAbort = false
-					}
+				if _, found := filterContainsAny(download.Filename, *sourceConfig.Filters.AllowedFilenames); found {
+					shouldAbort = false
 				}
 			}
 
@@ -990,7 +1515,7 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 		}
 
 		// Check Reactions
-		if sourceConfig.Filters.AllowedReactions != nil || sourceConfig.Filters.BlockedReactions != nil {
+		if !ruleMatched && (sourceConfig.Filters.AllowedReactions != nil || sourceConfig.Filters.BlockedReactions != nil) {
 			shouldAbort := false
 			if sourceConfig.Filters.AllowedReactions != nil {
 				shouldAbort = true
@@ -999,14 +1524,14 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 			if download.Message.Reactions != nil {
 				for _, reaction := range download.Message.Reactions {
 					if sourceConfig.Filters.BlockedReactions != nil {
-						if stringInSlice(reaction.Emoji.ID, *sourceConfig.Filters.BlockedReactions) ||
-							stringInSlice(reaction.Emoji.Name, *sourceConfig.Filters.BlockedReactions) {
+						if filterEqualsAny(reaction.Emoji.ID, *sourceConfig.Filters.BlockedReactions) ||
+							filterEqualsAny(reaction.Emoji.Name, *sourceConfig.Filters.BlockedReactions) {
 							shouldAbort = true
 						}
 					}
 					if sourceConfig.Filters.AllowedReactions != nil {
-						if stringInSlice(reaction.Emoji.ID, *sourceConfig.Filters.AllowedReactions) ||
-							stringInSlice(reaction.Emoji.Name, *sourceConfig.Filters.AllowedReactions) {
+						if filterEqualsAny(reaction.Emoji.ID, *sourceConfig.Filters.AllowedReactions) ||
+							filterEqualsAny(reaction.Emoji.Name, *sourceConfig.Filters.AllowedReactions) {
 							shouldAbort = false
 						}
 					}
@@ -1066,19 +1591,19 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 		}
 
 		// Check extension
-		if sourceConfig.Filters.AllowedExtensions != nil || sourceConfig.Filters.BlockedExtensions != nil {
+		if !ruleMatched && (sourceConfig.Filters.AllowedExtensions != nil || sourceConfig.Filters.BlockedExtensions != nil) {
 			shouldAbort := false
 			if sourceConfig.Filters.AllowedExtensions != nil {
 				shouldAbort = true
 			}
 
 			if sourceConfig.Filters.BlockedExtensions != nil {
-				if stringInSlice(download.Extension, *sourceConfig.Filters.BlockedExtensions) {
+				if filterEqualsAny(download.Extension, *sourceConfig.Filters.BlockedExtensions) {
 					shouldAbort = true
 				}
 			}
 			if sourceConfig.Filters.AllowedExtensions != nil {
-				if stringInSlice(download.Extension, *sourceConfig.Filters.AllowedExtensions) {
+				if filterEqualsAny(download.Extension, *sourceConfig.Filters.AllowedExtensions) {
 					shouldAbort = false
 				}
 			}
@@ -1106,27 +1631,33 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 			return mDownloadStatus(downloadSkippedUnpermittedType), 0
 		}
 
-		// Duplicate Image Filter
-		if config.Duplo && contentTypeBase == "image" && download.Extension != ".gif" && download.Extension != ".webp" {
-			img, _, err := image.Decode(bytes.NewReader(bodyOfResp))
-			if err != nil {
-				log.Println(lg("Duplo", "Download", color.HiRedString,
-					"Error converting buffer to image for hashing:\t%s", err))
-			} else {
-				hash, _ := duplo.CreateHash(img)
-				matches := duploCatalog.Query(hash)
-				sort.Sort(matches)
-				for _, match := range matches {
-					if match.Score < config.DuploThreshold {
-						log.Println(lg("Duplo", "Download", color.GreenString,
-							"Duplicate detected (Score of %f) found at %s", match.Score, download.InputURL))
-						return mDownloadStatus(downloadSkippedDetectedDuplicate), 0
-					}
+		// Check size, as announced
+		if !ruleMatched && response.ContentLength >= 0 {
+			announced := response.ContentLength
+			if resuming {
+				announced += partial.Meta.BytesReceived
+			}
+			if reason := filterSizeViolation(sourceConfig.Filters, announced); reason != "" {
+				if !download.HistoryCmd {
+					log.Println(lg("Download", "Skip", color.GreenString,
+						"Unpermitted file size (%s) at %s", reason, download.InputURL))
 				}
-				duploCatalog.Add(cachedDownloadID, hash)
+				return mDownloadStatus(downloadSkippedUnpermittedSize), 0
 			}
 		}
 
+		// Routes
+		if !ruleRouted && sourceConfig.Routes != nil && !download.EmojiCmd {
+			if route := matchRoute(*sourceConfig.Routes, download.Message, domain, download.Extension,
+				contentType, contentTypeBase); route != nil {
+				if err = download.reroute(route.Destination); err != nil {
+					return mDownloadStatus(downloadFailedCreatingFolder, err), 0
+				}
+			}
+		}
+
+		thumbRoot := download.Path // before subfolders, thumbnail trees mirror from here
+
 		sourceName := "UNKNOWN"
 		sourceChannelName := "UNKNOWN"
 		if !download.EmojiCmd {
@@ -1217,6 +1748,9 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 				subpath := ""
 				for _, subfolder := range subfolders {
 					subpath = subpath + subfolder + string(os.PathSeparator)
+					if download.DryRun {
+						continue
+					}
 					// Create folder
 					if err := os.MkdirAll(download.Path+subpath, 0755); err != nil {
 						log.Println(lg("Download", "", color.HiRedString,
@@ -1230,6 +1764,11 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 		}
 		completePath := filepath.Clean(download.Path + download.Filename)
 
+		// Held until the file's in place & stored, so workers saving the same filename can't both take it
+		if !download.DryRun {
+			defer lockDownload("path:" + completePath)()
+		}
+
 		// Check if filepath exists
 		if _, err := os.Stat(completePath); err == nil {
 			if *sourceConfig.SavePossibleDuplicates {
@@ -1258,13 +1797,188 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 			}
 		}
 
+		// Dry Run, the path is as far as it goes
+		if download.DryRun {
+			download.Record.Filename = download.Filename
+			download.Record.Destination = completePath
+			download.Record.Filesize = response.ContentLength
+			return mDownloadStatus(downloadSuccess), max(response.ContentLength, 0)
+		}
+
+		// Stream to a temporary file in the destination folder, renamed into place once complete
+		hasher := sha256.New()
+		if resuming {
+			if err = hashFileInto(hasher, partial.Path); err != nil {
+				partial.reset()
+				log.Println(lg("Download", "", color.HiRedString,
+					"Error while reading partial file \"%s\": %s", partial.Path, err))
+				return mDownloadStatus(downloadFailedWritingFile, err), 0
+			}
+		}
+		body := &downloadBodyReader{reader: io.TeeReader(bodyBuffered, hasher)}
+		var tempPath string
+		if partial != nil {
+			if err = partial.stream(body, response, resuming); err == nil {
+				tempPath = partial.Path
+			}
+		} else {
+			tempPath, err = streamToTempFile(body, filepath.Dir(completePath))
+		}
+		if err != nil {
+			if body.err != nil {
+				log.Println(lg("Download", "", color.HiRedString,
+					"Could not read response from \"%s\": %s", download.InputURL, body.err))
+				return mDownloadStatus(downloadFailedReadResponse, body.err), 0
+			}
+			log.Println(lg("Download", "", color.HiRedString,
+				"Error while writing file to disk \"%s\": %s", download.InputURL, err))
+			return mDownloadStatus(downloadFailedWritingFile, err), 0
+		}
+		savedPath := tempPath // where the file currently lives, the temp file is removed unless renamed
+		defer func() {
+			if savedPath == tempPath {
+				os.Remove(tempPath)
+			}
+		}()
+
+		download.Record.Hash = hex.EncodeToString(hasher.Sum(nil))
+		download.Record.Filename = download.Filename
+		if tempInfo, err := os.Stat(tempPath); err == nil {
+			download.Record.Filesize = tempInfo.Size()
+		}
+
+		// Check size & media, as received
+		if !ruleMatched {
+			if reason := filterSizeViolation(sourceConfig.Filters, download.Record.Filesize); reason != "" {
+				if !download.HistoryCmd {
+					log.Println(lg("Download", "Skip", color.GreenString,
+						"Unpermitted file size (%s) at %s", reason, download.InputURL))
+				}
+				return mDownloadStatus(downloadSkippedUnpermittedSize), 0
+			}
+			if status, reason := filterMediaViolation(sourceConfig.Filters, tempPath, contentTypeBase); reason != "" {
+				if !download.HistoryCmd {
+					log.Println(lg("Download", "Skip", color.GreenString,
+						"Unpermitted media (%s) at %s", reason, download.InputURL))
+				}
+				return mDownloadStatus(status), 0
+			}
+		}
+
+		storeDownload := func(destination string, status downloadStatus) error {
+			download.Record.Time = time.Now()
+			download.Record.Destination = destination
+			download.Record.Status = status
+			return dbInsertDownload(download.Record)
+		}
+
+		// Duplicate Content Filter
+		if policy := *sourceConfig.DuplicateContent; policy != "" && policy != duplicateContentSave && *sourceConfig.Save {
+			defer lockDownload("hash:" + download.Record.Hash)() // until stored, identical content saved at once is found
+			if existing := findExistingContent(download.Record.Hash); existing != nil {
+				linkStatus := downloadSkippedDuplicateContentHardlinked
+				switch policy {
+				case duplicateContentSkip:
+					if !download.HistoryCmd && !download.EmojiCmd {
+						log.Println(lg("Download", "Skip", color.GreenString,
+							"Identical content already saved at \"%s\", skipping %s", existing.Destination, download.InputURL))
+					}
+					download.Record.Destination = existing.Destination
+					download.Record.ThumbPath = existing.ThumbPath
+					return mDownloadStatus(downloadSkippedDuplicateContent), 0
+				case duplicateContentHardlink:
+					err = os.Link(existing.Destination, completePath)
+				case duplicateContentSymlink:
+					linkStatus = downloadSkippedDuplicateContentSymlinked
+					var target string
+					if target, err = filepath.Abs(existing.Destination); err == nil {
+						err = os.Symlink(target, completePath)
+					}
+				default:
+					err = fmt.Errorf("unknown duplicateContent policy \"%s\"", policy)
+				}
+				if err != nil {
+					log.Println(lg("Download", "", color.RedString,
+						"Could not link duplicate content to \"%s\", saving a copy instead: %s", existing.Destination, err))
+				} else {
+					if !download.HistoryCmd && !download.EmojiCmd {
+						log.Println(lg("Download", "Skip", color.GreenString,
+							"Identical content already saved, linked \"%s\" to \"%s\"", completePath, existing.Destination))
+					}
+					download.Record.ThumbPath = existing.ThumbPath
+					if sourceConfig.Metadata != nil && *sourceConfig.Metadata.Sidecar && !download.EmojiCmd {
+						// The content is shared, this message's context isn't
+						if err = writeMetadataSidecar(completePath, newFileMetadata(download, sourceName, sourceChannelName)); err != nil {
+							log.Println(lg("Download", "Metadata", color.RedString,
+								logPrefix+"Error while writing metadata sidecar of \"%s\": %s", completePath, err))
+						}
+					}
+					if err = storeDownload(completePath, linkStatus); err != nil {
+						log.Println(lg("Download", "", color.HiRedString, "Error writing to database: %s", err))
+						return mDownloadStatus(downloadFailedWritingDatabase, err), 0
+					}
+					return mDownloadStatus(linkStatus), 0
+				}
+			}
+		}
+
+		// Duplicate Image Filter
+		if config.Duplo && contentTypeBase == "image" && download.Extension != ".gif" && download.Extension != ".webp" {
+			img, err := decodeImageFile(tempPath)
+			if err != nil {
+				log.Println(lg("Duplo", "Download", color.HiRedString,
+					"Error converting file to image for hashing:\t%s", err))
+			} else {
+				hash, _ := duplo.CreateHash(img)
+				duploMutex.Lock() // so a duplicate can't slip in between the query & add
+				matches := duploCatalog.Query(hash)
+				sort.Sort(matches)
+				for _, match := range matches {
+					if match.Score < config.DuploThreshold {
+						duploMutex.Unlock()
+						log.Println(lg("Duplo", "Download", color.GreenString,
+							"Duplicate detected (Score of %f) found at %s", match.Score, download.InputURL))
+						return mDownloadStatus(downloadSkippedDetectedDuplicate), 0
+					}
+				}
+				duploCatalog.Add(downloadID, hash)
+				duploMutex.Unlock()
+			}
+		}
+
 		// Write
 		if *sourceConfig.Save {
-			if err = os.WriteFile(completePath, bodyOfResp, 0644); err != nil {
+			if err = moveFile(tempPath, completePath); err != nil {
 				log.Println(lg("Download", "", color.HiRedString,
 					"Error while writing file to disk \"%s\": %s", download.InputURL, err))
 				return mDownloadStatus(downloadFailedWritingFile, err), 0
 			}
+			savedPath = completePath
+
+			// Metadata, before the file time is set
+			if sourceConfig.Metadata != nil && !download.EmojiCmd {
+				metadata := newFileMetadata(download, sourceName, sourceChannelName)
+				if *sourceConfig.Metadata.Embed && !linksDuplicateContent(*sourceConfig.DuplicateContent) {
+					if err = embedMetadata(completePath, contentType, metadata); err != nil {
+						log.Println(lg("Download", "Metadata", color.RedString,
+							logPrefix+"Error while embedding metadata in \"%s\": %s", completePath, err))
+					} else if download.Record.Hash != "" {
+						// Identical downloads mustn't be linked to this message's copy
+						embedded := sha256.New()
+						if err = hashFileInto(embedded, completePath); err == nil {
+							download.Record.Hash = hex.EncodeToString(embedded.Sum(nil))
+						}
+					}
+				}
+				if *sourceConfig.Metadata.Sidecar {
+					if err = writeMetadataSidecar(completePath, metadata); err != nil {
+						log.Println(lg("Download", "Metadata", color.RedString,
+							logPrefix+"Error while writing metadata sidecar of \"%s\": %s", completePath, err))
+					} else {
+						os.Chtimes(completePath+".json", download.FileTime, download.FileTime)
+					}
+				}
+			}
 
 			// Change file time
 			if err = os.Chtimes(completePath, download.FileTime, download.FileTime); err != nil {
@@ -1272,6 +1986,17 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 					logPrefix+"Error while changing metadata date \"%s\": %s", download.InputURL, err))
 			}
 
+			// Thumbnail
+			if sourceConfig.Thumbnails != nil && canThumbnail(contentType) {
+				thumbPath := thumbnailPath(*sourceConfig.Thumbnails, thumbRoot, completePath)
+				if err = makeThumbnail(completePath, thumbPath, *sourceConfig.Thumbnails.Size); err != nil {
+					log.Println(lg("Download", "Thumbnail", color.RedString,
+						logPrefix+"Error while making thumbnail of \"%s\": %s", completePath, err))
+				} else {
+					download.Record.ThumbPath = thumbPath
+				}
+			}
+
 			filesize := "unknown"
 			speed := 0.0
 			speedlabel := "kB/s"
@@ -1311,30 +2036,17 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 			}
 		}
 
-		userID := botUser.ID
-		if !download.EmojiCmd {
-			if download.Message.Author != nil {
-				userID = download.Message.Author.ID
-			}
-		}
 		// Store in db
-		chID := "0"
-		if !download.EmojiCmd {
-			chID = download.Message.ChannelID
-		}
-		err = dbInsertDownload(&downloadItem{
-			URL:         download.InputURL,
-			Time:        time.Now(),
-			Destination: completePath,
-			Filename:    download.Filename,
-			ChannelID:   chID,
-			UserID:      userID,
-		})
-		if err != nil {
+		if err = storeDownload(completePath, downloadSuccess); err != nil {
 			log.Println(lg("Download", "", color.HiRedString, "Error writing to database: %s", err))
 			return mDownloadStatus(downloadFailedWritingDatabase, err), 0
 		}
 
+		// Post-Download Command
+		if *sourceConfig.Save && sourceConfig.PostDownloadCommand != nil && len(*sourceConfig.PostDownloadCommand) > 0 {
+			queuePostDownloadCommand(*sourceConfig.PostDownloadCommand, download)
+		}
+
 		// React
 		if !download.EmojiCmd {
 			shouldReact := config.ReactWhenDownloaded
@@ -1426,12 +2138,19 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 						msg = strings.ReplaceAll(msg, "\\n", "\n")
 						// File
 						if actualFile {
-							_, err := bot.ChannelMessageSendComplex(logChannel,
+							f, err := os.Open(savedPath)
+							if err != nil {
+								log.Println(lg("Download", "", color.HiRedString,
+									"File log message failed to open file:\t%s", err))
+								continue
+							}
+							_, err = bot.ChannelMessageSendComplex(logChannel,
 								&discordgo.MessageSend{
 									Content: msg,
-									File:    &discordgo.File{Name: download.Filename, Reader: bytes.NewReader(bodyOfResp)},
+									File:    &discordgo.File{Name: download.Filename, Reader: f},
 								},
 							)
+							f.Close()
 							if err != nil {
 								log.Println(lg("Download", "", color.HiRedString,
 									"File log message failed to send:\t%s", err))
@@ -1445,20 +2164,30 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 									Text:    fmt.Sprintf("%s v%s", projectName, projectVersion),
 								},
 							}
+							send := &discordgo.MessageSend{
+								Content: msg,
+								Embed:   embed,
+							}
+							var thumb *os.File
 							if contentTypeBase == "image" {
 								embed.Image = &discordgo.MessageEmbedImage{URL: download.InputURL}
+								// Attached thumbnail outlives the expiring link
+								if download.Record.ThumbPath != "" {
+									if thumb, err = os.Open(download.Record.ThumbPath); err == nil {
+										send.Files = []*discordgo.File{{Name: "thumb.jpg", ContentType: "image/jpeg", Reader: thumb}}
+										embed.Image.URL = "attachment://thumb.jpg"
+									}
+								}
 							} else if contentTypeBase == "video" {
 								embed.Video = &discordgo.MessageEmbedVideo{URL: download.InputURL}
 							} else {
 								embed.Description = fmt.Sprintf("Unsupported filetype: %s\n%s",
 									contentTypeBase, download.InputURL)
 							}
-							_, err := bot.ChannelMessageSendComplex(logChannel,
-								&discordgo.MessageSend{
-									Content: msg,
-									Embed:   embed,
-								},
-							)
+							_, err := bot.ChannelMessageSendComplex(logChannel, send)
+							if thumb != nil {
+								thumb.Close()
+							}
 							if err != nil {
 								log.Println(lg("Download", "", color.HiRedString,
 									"File log message failed to send:\t%s", err))
@@ -1471,13 +2200,13 @@ func (download downloadRequestStruct) tryDownload() (downloadStatusStruct, int64
 
 		// Update Presence
 		if !download.HistoryCmd {
-			timeLastUpdated = time.Now()
+			timeLastUpdated.set(time.Now())
 			if *sourceConfig.PresenceEnabled {
 				go updateDiscordPresence()
 			}
 		}
 
-		timeLastDownload = time.Now()
+		timeLastDownload.set(time.Now())
 		if *sourceConfig.Save {
 			return mDownloadStatus(downloadSuccess), fileinfo.Size()
 		} else {
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+//#region Dry Run
+
+// Dry runs go through link collection, filters, rules, routes & path formatting like any download, then report
+// where each file would've gone or why it wouldn't, instead of saving it. Responses are only read as far as
+// sniffing the content type, so checks needing the whole file (media limits, duplicate content) aren't made.
+// Nothing is written to the destination, database, history cache, reactions or log channels.
+
+type dryRunEntry struct {
+	Time        time.Time `json:"time"`
+	Result      string    `json:"result"` // planned, skipped, ignored, failed
+	Reason      string    `json:"reason,omitempty"`
+	URL         string    `json:"url"`
+	Path        string    `json:"path,omitempty"`
+	Filesize    int64     `json:"filesize"` // as announced, -1 when unknown
+	ContentType string    `json:"contentType,omitempty"`
+	ServerID    string    `json:"serverID,omitempty"`
+	ChannelID   string    `json:"channelID,omitempty"`
+	MessageID   string    `json:"messageID,omitempty"`
+	UserID      string    `json:"userID,omitempty"`
+	History     bool      `json:"history"`
+}
+
+var dryRunHeader = []string{"time", "result", "reason", "url", "path", "filesize", "contentType",
+	"serverID", "channelID", "messageID", "userID", "history"}
+
+func (entry dryRunEntry) csvRecord() []string {
+	return []string{
+		entry.Time.Format(time.RFC3339), entry.Result, entry.Reason, entry.URL, entry.Path,
+		strconv.FormatInt(entry.Filesize, 10), entry.ContentType,
+		entry.ServerID, entry.ChannelID, entry.MessageID, entry.UserID, strconv.FormatBool(entry.History),
+	}
+}
+
+var dryRunMutex sync.Mutex
+
+// Appends to the report, CSV when it ends in .csv, JSON lines otherwise.
+func dryRunReport(entry dryRunEntry) {
+	entry.Time = time.Now()
+	dryRunMutex.Lock()
+	defer dryRunMutex.Unlock()
+	reportPath := config.DryRunReport
+	if err := os.MkdirAll(filepath.Dir(reportPath), 0755); err != nil {
+		log.Println(lg("DryRun", "", color.HiRedString, "Failed to create report folder:\t%s", err))
+		return
+	}
+	_, statErr := os.Stat(reportPath)
+	f, err := os.OpenFile(reportPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Println(lg("DryRun", "", color.HiRedString, "Failed to open report \"%s\":\t%s", reportPath, err))
+		return
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(reportPath), ".csv") {
+		writer := csv.NewWriter(f)
+		if os.IsNotExist(statErr) {
+			writer.Write(dryRunHeader)
+		}
+		writer.Write(entry.csvRecord())
+		writer.Flush()
+		err = writer.Error()
+	} else {
+		var line []byte
+		if line, err = json.Marshal(entry); err == nil {
+			_, err = f.Write(append(line, '\n'))
+		}
+	}
+	if err != nil {
+		log.Println(lg("DryRun", "", color.HiRedString, "Failed to write report \"%s\":\t%s", reportPath, err))
+	}
+}
+
+// For links dropped before they reach the download queue.
+func dryRunReportSkip(m *discordgo.Message, link string, history bool, reason string) {
+	entry := dryRunEntry{
+		Result:    "skipped",
+		Reason:    reason,
+		URL:       link,
+		Filesize:  -1,
+		ServerID:  m.GuildID,
+		ChannelID: m.ChannelID,
+		MessageID: m.ID,
+		History:   history,
+	}
+	if entry.ServerID == "" { // history messages don't include it
+		if channel, err := bot.State.Channel(m.ChannelID); err == nil {
+			entry.ServerID = channel.GuildID
+		}
+	}
+	if m.Author != nil {
+		entry.UserID = m.Author.ID
+	}
+	dryRunReport(entry)
+}
+
+func dryRunReportDownload(download downloadRequestStruct, status downloadStatusStruct) {
+	entry := dryRunEntry{
+		Result:  strings.ToLower(getDownloadStatusShort(status.Status)),
+		URL:     download.InputURL,
+		History: download.HistoryCmd,
+	}
+	if status.Status == downloadSuccess {
+		entry.Result = "planned"
+	} else {
+		entry.Reason = getDownloadStatus(status.Status)
+		if status.Error != nil {
+			entry.Reason += ": " + status.Error.Error()
+		}
+	}
+	if record := download.Record; record != nil {
+		entry.Path = record.Destination
+		entry.Filesize = record.Filesize
+		entry.ContentType = record.ContentType
+		entry.ServerID = record.GuildID
+		entry.ChannelID = record.ChannelID
+		entry.MessageID = record.MessageID
+		entry.UserID = record.UserID
+	}
+	dryRunReport(entry)
+}
+
+//#endregion
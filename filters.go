@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+//#region Filter Patterns
+
+// Filter list entries are plain text unless prefixed:
+//	re:<regular expression>	searched for anywhere, anchor it yourself
+//	glob:<pattern>	* and ? wildcards, has to match the whole value
+// Domain lists also take "*.example.com" for any subdomain of example.com.
+// Lists matched exactly (users, roles, domains, extensions, reactions) ignore case for plain & glob entries,
+// the rest (phrases, link content, filenames) are case sensitive like they've always been.
+
+const (
+	filterPrefixRegex = "re:"
+	filterPrefixGlob  = "glob:"
+)
+
+type filterMode int
+
+const (
+	filterModeContains filterMode = iota
+	filterModeExact
+	filterModeDomain
+)
+
+type filterCompiled struct {
+	regex *regexp.Regexp
+	err   error
+}
+
+var (
+	filterCache      = make(map[string]filterCompiled)
+	filterCacheMutex sync.Mutex
+)
+
+// Regular expression equivalent of a glob, matching the whole value.
+func filterGlobRegex(glob string, ignoreCase bool) string {
+	var expression strings.Builder
+	if ignoreCase {
+		expression.WriteString("(?i)")
+	}
+	expression.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			expression.WriteString(".*")
+		case '?':
+			expression.WriteString(".")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expression.WriteString("$")
+	return expression.String()
+}
+
+// Nil without error for plain entries. Compiled once per entry & mode, errors included.
+func compileFilterEntry(entry string, mode filterMode) (*regexp.Regexp, error) {
+	var expression string
+	switch {
+	case strings.HasPrefix(entry, filterPrefixRegex):
+		expression = strings.TrimPrefix(entry, filterPrefixRegex)
+	case strings.HasPrefix(entry, filterPrefixGlob):
+		expression = filterGlobRegex(strings.TrimPrefix(entry, filterPrefixGlob), mode != filterModeContains)
+	default:
+		return nil, nil
+	}
+	key := fmt.Sprintf("%d:%s", mode, entry)
+	filterCacheMutex.Lock()
+	defer filterCacheMutex.Unlock()
+	if compiled, exists := filterCache[key]; exists {
+		return compiled.regex, compiled.err
+	}
+	regex, err := regexp.Compile(expression)
+	filterCache[key] = filterCompiled{regex, err}
+	return regex, err
+}
+
+func filterEntryMatches(value string, entry string, mode filterMode) bool {
+	regex, err := compileFilterEntry(entry, mode)
+	switch {
+	case err != nil: // reported when settings loaded
+		return false
+	case regex != nil:
+		return regex.MatchString(value)
+	case mode == filterModeContains:
+		return strings.TrimSpace(entry) != "" && strings.Contains(value, entry)
+	case mode == filterModeDomain && strings.HasPrefix(entry, "*."):
+		return strings.HasSuffix(strings.ToLower(value), strings.ToLower(entry[1:]))
+	}
+	return strings.EqualFold(value, entry)
+}
+
+// First entry matching the value, for phrase, link content & filename lists.
+func filterContainsAny(value string, entries []string) (string, bool) {
+	for _, entry := range entries {
+		if filterEntryMatches(value, entry, filterModeContains) {
+			return entry, true
+		}
+	}
+	return "", false
+}
+
+// For user, role, extension & reaction lists, like stringInSlice.
+func filterEqualsAny(value string, entries []string) bool {
+	for _, entry := range entries {
+		if filterEntryMatches(value, entry, filterModeExact) {
+			return true
+		}
+	}
+	return false
+}
+
+func filterDomainAny(domain string, entries []string) bool {
+	for _, entry := range entries {
+		if filterEntryMatches(domain, entry, filterModeDomain) {
+			return true
+		}
+	}
+	return false
+}
+
+type filterList struct {
+	name    string
+	entries *[]string
+	mode    filterMode
+}
+
+func (filters *configurationSourceFilters) lists() []filterList {
+	return []filterList{
+		{"blockedPhrases", filters.BlockedPhrases, filterModeContains},
+		{"allowedPhrases", filters.AllowedPhrases, filterModeContains},
+		{"blockedUsers", filters.BlockedUsers, filterModeExact},
+		{"allowedUsers", filters.AllowedUsers, filterModeExact},
+		{"blockedRoles", filters.BlockedRoles, filterModeExact},
+		{"allowedRoles", filters.AllowedRoles, filterModeExact},
+		{"blockedLinkContent", filters.BlockedLinkContent, filterModeContains},
+		{"allowedLinkContent", filters.AllowedLinkContent, filterModeContains},
+		{"blockedDomains", filters.BlockedDomains, filterModeDomain},
+		{"allowedDomains", filters.AllowedDomains, filterModeDomain},
+		{"blockedExtensions", filters.BlockedExtensions, filterModeExact},
+		{"allowedExtensions", filters.AllowedExtensions, filterModeExact},
+		{"blockedFilenames", filters.BlockedFilenames, filterModeContains},
+		{"allowedFilenames", filters.AllowedFilenames, filterModeContains},
+		{"blockedReactions", filters.BlockedReactions, filterModeExact},
+		{"allowedReactions", filters.AllowedReactions, filterModeExact},
+	}
+}
+
+// Compiles every pattern when settings load, logging the broken ones, they never match.
+// Lists inherited from the global filters are skipped, they've been checked already.
+func validateFilters(label string, filters *configurationSourceFilters) {
+	if filters == nil {
+		return
+	}
+	var inherited []filterList
+	if config.Filters != nil && filters != config.Filters {
+		inherited = config.Filters.lists()
+	}
+	for i, list := range filters.lists() {
+		if list.entries == nil || (inherited != nil && list.entries == inherited[i].entries) {
+			continue
+		}
+		for _, entry := range *list.entries {
+			if _, err := compileFilterEntry(entry, list.mode); err != nil {
+				log.Println(lg("Settings", "Filters", color.HiRedString,
+					"%s %s pattern \"%s\" is invalid and will be ignored:\t%s", label, list.name, entry, err))
+			}
+		}
+	}
+}
+
+//#endregion
@@ -0,0 +1,784 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+)
+
+//#region Gallery
+
+// Static HTML pages of the images & videos sources saved, with per-channel & per-user pages.
+// Entries come from download records & files found in the source destinations, gallery.json keeps what's been
+// handled & where the records and folders were left off, so builds only go over records stored since, files in
+// folders changed since & only redo the pages that changed.
+
+type galleryEntry struct {
+	RecordID    int       `json:"recordID,omitempty"` // 0 for files found without a record
+	Path        string    `json:"path"`
+	Thumb       string    `json:"thumb,omitempty"` // relative to the gallery, or where it was saved with the file
+	ContentType string    `json:"contentType,omitempty"`
+	Filesize    int64     `json:"filesize"`
+	Time        time.Time `json:"time"`
+	URL         string    `json:"url,omitempty"`
+	ServerID    string    `json:"serverID,omitempty"`
+	ServerName  string    `json:"serverName,omitempty"`
+	ChannelID   string    `json:"channelID,omitempty"`
+	ChannelName string    `json:"channelName,omitempty"`
+	UserID      string    `json:"userID,omitempty"`
+	Username    string    `json:"username,omitempty"`
+	MessageID   string    `json:"messageID,omitempty"`
+	Content     string    `json:"content,omitempty"`
+}
+
+type galleryState struct {
+	Built   time.Time                `json:"built"`
+	Records downloadCursor           `json:"records"`
+	Walked  time.Time                `json:"walked"`  // folders not modified since have nothing new or removed
+	Entries map[string]*galleryEntry `json:"entries"` // by absolute file path
+}
+
+// Leeway for folder modification times, filesystems keep them coarsely.
+const galleryWalkMargin = time.Minute
+
+func galleryStatePath(destination string) string {
+	return filepath.Join(destination, "gallery.json")
+}
+
+func loadGalleryState(destination string) (galleryState, error) {
+	state := galleryState{Entries: make(map[string]*galleryEntry)}
+	data, err := os.ReadFile(galleryStatePath(destination))
+	if err != nil {
+		return state, err
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		return state, err
+	}
+	if state.Entries == nil {
+		state.Entries = make(map[string]*galleryEntry)
+	}
+	return state, nil
+}
+
+func saveGalleryState(destination string, state galleryState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmpPath := galleryStatePath(destination) + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, galleryStatePath(destination))
+}
+
+// Sources sharing a gallery destination share the gallery.
+type galleryTarget struct {
+	Gallery      configurationSourceGallery
+	Destinations []string // of the sources, walked for files without records
+}
+
+func getGalleryTargets() []*galleryTarget {
+	var targets []*galleryTarget
+	byDestination := make(map[string]*galleryTarget)
+	add := func(source configurationSource) {
+		if source.Gallery == nil || source.Gallery.Destination == "" {
+			return
+		}
+		key := galleryAbs(source.Gallery.Destination)
+		target, exists := byDestination[key]
+		if !exists {
+			target = &galleryTarget{Gallery: *source.Gallery}
+			byDestination[key] = target
+			targets = append(targets, target)
+		}
+		if source.Destination != "" && !stringInSlice(source.Destination, target.Destinations) {
+			target.Destinations = append(target.Destinations, source.Destination)
+		}
+	}
+	for _, sources := range [][]configurationSource{config.Channels, config.Categories, config.Servers, config.Users} {
+		for _, source := range sources {
+			add(source)
+		}
+	}
+	if config.All != nil {
+		add(*config.All)
+	}
+	return targets
+}
+
+func galleryAbs(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		return absolute
+	}
+	return filepath.Clean(path)
+}
+
+func galleryKind(contentType string) string {
+	base := strings.SplitN(contentType, "/", 2)[0]
+	if base == "image" || base == "video" {
+		return base
+	}
+	return ""
+}
+
+func galleryContentType(contentType string, path string) string {
+	if contentType == "" || contentType == "application/octet-stream" {
+		if byExtension := mime.TypeByExtension(strings.ToLower(filepath.Ext(path))); byExtension != "" {
+			contentType = byExtension
+		}
+	}
+	return strings.TrimSpace(strings.Split(contentType, ";")[0])
+}
+
+var (
+	galleryMutex     sync.Mutex
+	galleryLastBuild = make(map[string]time.Time) // by absolute gallery path
+)
+
+type galleryBuildResult struct {
+	Added   int
+	Removed int
+	Total   int
+}
+
+// Full builds start over, otherwise only new files are looked into.
+func buildGallery(target *galleryTarget, full bool) (galleryBuildResult, error) {
+	galleryMutex.Lock()
+	defer galleryMutex.Unlock()
+
+	var result galleryBuildResult
+	gallery := target.Gallery
+	galleryPath := galleryAbs(gallery.Destination)
+	if err := os.MkdirAll(filepath.Join(galleryPath, "thumbs"), 0755); err != nil {
+		return result, err
+	}
+	state := galleryState{Entries: make(map[string]*galleryEntry)}
+	if !full {
+		var err error
+		if state, err = loadGalleryState(galleryPath); err != nil && !os.IsNotExist(err) {
+			log.Println(lg("Gallery", "", color.HiRedString,
+				"Failed to read \"%s\", starting over:\t%s", galleryStatePath(galleryPath), err))
+		}
+	}
+
+	dirtyChannels := make(map[string]bool)
+	dirtyUsers := make(map[string]bool)
+	touch := func(entry *galleryEntry) {
+		dirtyChannels[entry.ChannelID] = true
+		dirtyUsers[entry.UserID] = true
+	}
+
+	// Adding or removing a file modifies its folder, those that weren't can be passed over
+	walkStart := time.Now()
+	folderChanges := make(map[string]bool)
+	folderChanged := func(folder string) bool {
+		if changed, checked := folderChanges[folder]; checked {
+			return changed
+		}
+		changed := true
+		if info, err := os.Stat(folder); err == nil && !state.Walked.IsZero() {
+			changed = info.ModTime().After(state.Walked.Add(-galleryWalkMargin))
+		}
+		folderChanges[folder] = changed
+		return changed
+	}
+
+	// Removed files
+	for key, entry := range state.Entries {
+		if !folderChanged(filepath.Dir(entry.Path)) {
+			continue
+		}
+		if _, err := os.Stat(entry.Path); err != nil {
+			delete(state.Entries, key)
+			if entry.Thumb != "" && !filepath.IsAbs(entry.Thumb) {
+				os.Remove(filepath.Join(galleryPath, filepath.FromSlash(entry.Thumb)))
+			}
+			touch(entry)
+			result.Removed++
+		}
+	}
+
+	// New records
+	var pending []*galleryEntry
+	pendingPaths := make(map[string]bool)
+	isNew := func(path string, record bool) bool {
+		entry, known := state.Entries[path]
+		if known && record && entry.RecordID == 0 { // found before its record was
+			known = false
+		}
+		return !known && !pendingPaths[path]
+	}
+	destinations := make([]string, 0, len(target.Destinations))
+	for _, destination := range target.Destinations {
+		destinations = append(destinations, galleryAbs(destination)+string(os.PathSeparator))
+	}
+	channelGallery := make(map[string]bool) // whether the source of the channel uses this gallery
+	usesGallery := func(download *downloadItem) bool {
+		if uses, checked := channelGallery[download.ChannelID]; checked {
+			return uses
+		}
+		uses := false
+		if bot != nil && download.ChannelID != "" {
+			if _, err := bot.State.Channel(download.ChannelID); err == nil {
+				source := getSource(&discordgo.Message{ChannelID: download.ChannelID, Author: &discordgo.User{ID: download.UserID}})
+				uses = source.Gallery != nil && galleryAbs(source.Gallery.Destination) == galleryPath
+			}
+		}
+		channelGallery[download.ChannelID] = uses
+		return uses
+	}
+	if myDB != nil {
+		cursor, err := myDB.ForEachDownloadAfter(state.Records, func(download *downloadItem) bool {
+			if !isDownloadComplete(download.Status) || download.Destination == "" {
+				return true
+			}
+			path := galleryAbs(download.Destination)
+			contentType := galleryContentType(download.ContentType, path)
+			if galleryKind(contentType) == "" || !isNew(path, true) {
+				return true
+			}
+			held := false
+			for _, destination := range destinations {
+				if strings.HasPrefix(path, destination) {
+					held = true
+					break
+				}
+			}
+			if !held && !usesGallery(download) {
+				return true
+			}
+			pending = append(pending, &galleryEntry{
+				RecordID:    download.ID,
+				Path:        path,
+				ContentType: contentType,
+				Filesize:    download.Filesize,
+				Time:        download.Time,
+				URL:         download.URL,
+				ServerID:    download.GuildID,
+				ChannelID:   download.ChannelID,
+				UserID:      download.UserID,
+				MessageID:   download.MessageID,
+			})
+			if download.ThumbPath != "" {
+				pending[len(pending)-1].Thumb = galleryAbs(download.ThumbPath)
+			}
+			pendingPaths[path] = true
+			return true
+		})
+		if err != nil {
+			return result, err
+		}
+		state.Records = cursor
+	}
+
+	// Files without records
+	for _, destination := range target.Destinations {
+		filepath.WalkDir(destination, func(walkPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			path := galleryAbs(walkPath)
+			if d.IsDir() {
+				if path == galleryPath || d.Name() == thumbnailFolder {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(d.Name(), ".thumb.jpg") || !folderChanged(filepath.Dir(path)) {
+				return nil
+			}
+			contentType := galleryContentType("", path)
+			if galleryKind(contentType) == "" || !isNew(path, false) {
+				return nil
+			}
+			entry := &galleryEntry{Path: path, ContentType: contentType, Filesize: -1}
+			if info, err := d.Info(); err == nil {
+				entry.Filesize = info.Size()
+				entry.Time = info.ModTime()
+			}
+			pending = append(pending, entry)
+			pendingPaths[path] = true
+			return nil
+		})
+	}
+
+	// Describe & thumbnail what's new
+	lookup := newGalleryLookup()
+	for _, entry := range pending {
+		info, err := os.Stat(entry.Path)
+		if err != nil {
+			continue
+		}
+		if entry.Filesize < 0 {
+			entry.Filesize = info.Size()
+		}
+		if entry.Time.IsZero() {
+			entry.Time = info.ModTime()
+		}
+		lookup.describe(entry)
+		entry.Thumb = galleryThumbnail(galleryPath, entry, *gallery.ThumbnailSize)
+		state.Entries[entry.Path] = entry
+		touch(entry)
+		result.Added++
+	}
+	result.Total = len(state.Entries)
+	state.Walked = walkStart
+
+	// Pages, nothing to redo when nothing changed
+	if _, err := os.Stat(filepath.Join(galleryPath, "index.html")); err == nil && !full && result.Added == 0 && result.Removed == 0 {
+		state.Built = time.Now()
+		if err := saveGalleryState(galleryPath, state); err != nil {
+			return result, err
+		}
+		galleryLastBuild[galleryPath] = state.Built
+		return result, nil
+	}
+	entries := make([]*galleryEntry, 0, len(state.Entries))
+	for _, entry := range state.Entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].Time.After(entries[j].Time)
+	})
+	state.Built = time.Now()
+	renderer := galleryRenderer{gallery: gallery, path: galleryPath, built: state.Built}
+	if err := renderer.pages("", "index", *gallery.Title, entries); err != nil {
+		return result, err
+	}
+	channels := make(map[string][]*galleryEntry)
+	users := make(map[string][]*galleryEntry)
+	for _, entry := range entries {
+		if entry.ChannelID != "" {
+			channels[entry.ChannelID] = append(channels[entry.ChannelID], entry)
+		}
+		if entry.UserID != "" {
+			users[entry.UserID] = append(users[entry.UserID], entry)
+		}
+	}
+	for dir, groups := range map[string]map[string][]*galleryEntry{"channels": channels, "users": users} {
+		dirty := dirtyChannels
+		if dir == "users" {
+			dirty = dirtyUsers
+		}
+		var listing []galleryGroup
+		for id, group := range groups {
+			label := galleryGroupLabel(dir, group[0])
+			listing = append(listing, galleryGroup{
+				Label:  label,
+				Link:   id + ".html",
+				Count:  len(group),
+				Latest: group[0].Time,
+			})
+			if _, err := os.Stat(filepath.Join(galleryPath, dir, id+".html")); err == nil && !dirty[id] && !full {
+				continue
+			}
+			if err := renderer.pages(dir, id, label, group); err != nil {
+				return result, err
+			}
+		}
+		for id := range dirty { // emptied
+			if _, exists := groups[id]; !exists && id != "" {
+				renderer.pages(dir, id, "", nil)
+			}
+		}
+		sort.Slice(listing, func(i, j int) bool { return listing[i].Latest.After(listing[j].Latest) })
+		if err := renderer.listing(dir, listing); err != nil {
+			return result, err
+		}
+	}
+
+	if err := saveGalleryState(galleryPath, state); err != nil {
+		return result, err
+	}
+	galleryLastBuild[galleryPath] = state.Built
+	return result, nil
+}
+
+// Names, message text & authors, each looked up once per build.
+type galleryLookup struct {
+	servers  map[string]string
+	channels map[string]string
+	archives map[string]map[string]*archivedMessage // by channel, then message
+	messages map[string]*discordgo.Message
+}
+
+func newGalleryLookup() *galleryLookup {
+	return &galleryLookup{
+		servers:  make(map[string]string),
+		channels: make(map[string]string),
+		archives: make(map[string]map[string]*archivedMessage),
+		messages: make(map[string]*discordgo.Message),
+	}
+}
+
+func (lookup *galleryLookup) describe(entry *galleryEntry) {
+	if bot == nil || entry.ChannelID == "" {
+		return
+	}
+	if _, exists := lookup.channels[entry.ChannelID]; !exists {
+		if label := getChannelLabel(entry.ChannelID, nil); label != entry.ChannelID {
+			lookup.channels[entry.ChannelID] = label
+		} else {
+			lookup.channels[entry.ChannelID] = ""
+		}
+	}
+	entry.ChannelName = lookup.channels[entry.ChannelID]
+	channel, channelErr := bot.State.Channel(entry.ChannelID)
+	if entry.ServerID == "" && channelErr == nil {
+		entry.ServerID = channel.GuildID
+	}
+	if entry.ServerID != "" {
+		if _, exists := lookup.servers[entry.ServerID]; !exists {
+			lookup.servers[entry.ServerID] = getServerLabel(entry.ServerID)
+		}
+		entry.ServerName = lookup.servers[entry.ServerID]
+	}
+	if entry.MessageID == "" {
+		return
+	}
+
+	// Archived first, saves asking Discord
+	if _, loaded := lookup.archives[entry.ChannelID]; !loaded {
+		archived := make(map[string]*archivedMessage)
+		source := emptySourceConfig
+		if channelErr == nil {
+			source = getSource(&discordgo.Message{ChannelID: entry.ChannelID, Author: &discordgo.User{ID: entry.UserID}})
+		}
+		if source.Archive != nil {
+			readArchiveLines(archiveMessagesPath(source.Archive.Destination, entry.ChannelID), func(line []byte) {
+				var message archivedMessage
+				if json.Unmarshal(line, &message) == nil {
+					archived[message.ID] = &message // last version wins
+				}
+			})
+		}
+		lookup.archives[entry.ChannelID] = archived
+	}
+	if message, exists := lookup.archives[entry.ChannelID][entry.MessageID]; exists {
+		entry.Content = message.Content
+		entry.Username = message.Author.Name
+		if entry.UserID == "" {
+			entry.UserID = message.Author.ID
+		}
+		return
+	}
+
+	message, checked := lookup.messages[entry.MessageID]
+	if !checked {
+		var err error
+		if message, err = bot.State.Message(entry.ChannelID, entry.MessageID); err != nil {
+			message, _ = bot.ChannelMessage(entry.ChannelID, entry.MessageID)
+		}
+		lookup.messages[entry.MessageID] = message
+	}
+	if message != nil {
+		entry.Content = message.Content
+		if contentFmt, err := message.ContentWithMoreMentionsReplaced(bot); err == nil {
+			entry.Content = contentFmt
+		}
+		if message.Author != nil {
+			entry.Username = message.Author.Username
+			if entry.UserID == "" {
+				entry.UserID = message.Author.ID
+			}
+		}
+	}
+}
+
+func galleryGroupLabel(dir string, entry *galleryEntry) string {
+	if dir == "users" {
+		if entry.Username != "" {
+			return "@" + entry.Username
+		}
+		return entry.UserID
+	}
+	label := entry.ChannelID
+	if entry.ChannelName != "" {
+		label = "#" + entry.ChannelName
+	}
+	if entry.ServerName != "" {
+		label = entry.ServerName + " / " + label
+	}
+	return label
+}
+
+// Thumbnail made when the file was saved, otherwise a downscaled JPEG of JPEG, PNG & GIF images in the
+// gallery, relative to it. Other files show as they are.
+func galleryThumbnail(galleryPath string, entry *galleryEntry, size int) string {
+	if entry.Thumb != "" {
+		if _, err := os.Stat(entry.Thumb); err == nil {
+			return entry.Thumb
+		}
+	}
+	if !canThumbnail(entry.ContentType) {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(entry.Path))
+	name := hex.EncodeToString(sum[:8]) + ".jpg"
+	thumbPath := filepath.Join(galleryPath, "thumbs", name)
+	if _, err := os.Stat(thumbPath); err == nil {
+		return "thumbs/" + name
+	}
+	if err := makeThumbnail(entry.Path, thumbPath, size); err != nil {
+		log.Println(lg("Gallery", "Thumbnail", color.HiRedString, "Failed to make thumbnail of \"%s\":\t%s", entry.Path, err))
+		return ""
+	}
+	return "thumbs/" + name
+}
+
+//#endregion
+
+//#region Gallery Pages
+
+type galleryItem struct {
+	*galleryEntry
+	Kind    string
+	File    string // links relative to the page
+	Preview string
+	Channel string
+	User    string
+	Discord string
+	Size    string
+}
+
+type galleryGroup struct {
+	Label  string
+	Link   string
+	Count  int
+	Latest time.Time
+}
+
+type galleryPage struct {
+	Title   string
+	Gallery string
+	Root    string // from the page back to the gallery
+	Built   time.Time
+	Items   []galleryItem
+	Groups  []galleryGroup
+	Page    int
+	Pages   int
+	Prev    string
+	Next    string
+}
+
+type galleryRenderer struct {
+	gallery configurationSourceGallery
+	path    string
+	built   time.Time
+}
+
+func galleryPageName(base string, page int) string {
+	if page <= 1 {
+		return base + ".html"
+	}
+	return fmt.Sprintf("%s-%d.html", base, page)
+}
+
+// Path from a page in dir to the file, for href & src.
+func galleryLink(fromDir string, path string) string {
+	rel, err := filepath.Rel(fromDir, path)
+	if err != nil {
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel)}).String()
+}
+
+func (renderer galleryRenderer) page(dir string, name string, data galleryPage) error {
+	pageDir := filepath.Join(renderer.path, dir)
+	if err := os.MkdirAll(pageDir, 0755); err != nil {
+		return err
+	}
+	data.Gallery = *renderer.gallery.Title
+	data.Built = renderer.built
+	data.Root = ""
+	if dir != "" {
+		data.Root = "../"
+	}
+	tmpPath := filepath.Join(pageDir, name+".tmp")
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err = galleryTemplate.Execute(f, data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(pageDir, name))
+}
+
+// Writes base.html, base-2.html... removing pages left over from when there were more.
+func (renderer galleryRenderer) pages(dir string, base string, title string, entries []*galleryEntry) error {
+	pageDir := filepath.Join(renderer.path, dir)
+	pageSize := *renderer.gallery.PageSize
+	pages := (len(entries) + pageSize - 1) / pageSize
+	for page := 1; page <= pages; page++ {
+		data := galleryPage{Title: title, Page: page, Pages: pages}
+		if page > 1 {
+			data.Prev = galleryPageName(base, page-1)
+		}
+		if page < pages {
+			data.Next = galleryPageName(base, page+1)
+		}
+		for _, entry := range entries[(page-1)*pageSize : min(page*pageSize, len(entries))] {
+			item := galleryItem{
+				galleryEntry: entry,
+				Kind:         galleryKind(entry.ContentType),
+				File:         galleryLink(pageDir, entry.Path),
+				Size:         humanize.Bytes(uint64(max(entry.Filesize, 0))),
+			}
+			item.Preview = item.File
+			if filepath.IsAbs(entry.Thumb) {
+				item.Preview = galleryLink(pageDir, entry.Thumb)
+			} else if entry.Thumb != "" {
+				item.Preview = galleryLink(pageDir, filepath.Join(renderer.path, filepath.FromSlash(entry.Thumb)))
+			}
+			if entry.ChannelID != "" {
+				item.Channel = galleryLink(pageDir, filepath.Join(renderer.path, "channels", entry.ChannelID+".html"))
+			}
+			if entry.UserID != "" {
+				item.User = galleryLink(pageDir, filepath.Join(renderer.path, "users", entry.UserID+".html"))
+			}
+			if entry.ChannelID != "" && entry.MessageID != "" {
+				guild := entry.ServerID
+				if guild == "" {
+					guild = "@me"
+				}
+				item.Discord = fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guild, entry.ChannelID, entry.MessageID)
+			}
+			data.Items = append(data.Items, item)
+		}
+		if err := renderer.page(dir, galleryPageName(base, page), data); err != nil {
+			return err
+		}
+	}
+	for page := max(pages+1, 1); ; page++ {
+		if err := os.Remove(filepath.Join(pageDir, galleryPageName(base, page))); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (renderer galleryRenderer) listing(dir string, groups []galleryGroup) error {
+	title := "Channels"
+	if dir == "users" {
+		title = "Users"
+	}
+	return renderer.page(dir, "index.html", galleryPage{Title: title, Groups: groups})
+}
+
+var galleryTemplate = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} — {{.Gallery}}</title>
+<style>
+body { margin: 0; padding: 16px; background: #1e1f22; color: #dbdee1; font: 14px sans-serif; }
+a { color: #00a8fc; text-decoration: none; }
+a:hover { text-decoration: underline; }
+nav { margin-bottom: 16px; }
+nav a { margin-right: 12px; }
+.pager { margin: 16px 0; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(240px, 1fr)); gap: 12px; }
+.item { background: #2b2d31; border-radius: 6px; overflow: hidden; }
+.item img, .item video { display: block; width: 100%; height: 240px; object-fit: cover; background: #111214; }
+.info { padding: 8px; font-size: 12px; }
+.meta { color: #949ba4; }
+.content { margin-top: 6px; white-space: pre-wrap; word-wrap: break-word; max-height: 120px; overflow: auto; }
+table { border-collapse: collapse; }
+td, th { padding: 4px 12px; text-align: left; }
+</style>
+</head>
+<body>
+<nav><a href="{{.Root}}index.html">All</a><a href="{{.Root}}channels/index.html">Channels</a><a href="{{.Root}}users/index.html">Users</a></nav>
+<h1>{{.Title}}</h1>
+{{if .Groups}}<table>
+<tr><th></th><th>Files</th><th>Latest</th></tr>
+{{range .Groups}}<tr><td><a href="{{.Link}}">{{.Label}}</a></td><td>{{.Count}}</td><td>{{.Latest.Format "2006-01-02 15:04"}}</td></tr>
+{{end}}</table>{{end}}
+{{if gt .Pages 1}}<div class="pager">{{if .Prev}}<a href="{{.Prev}}">&larr; Newer</a> {{end}}Page {{.Page}} of {{.Pages}}{{if .Next}} <a href="{{.Next}}">Older &rarr;</a>{{end}}</div>{{end}}
+{{if .Items}}<div class="grid">
+{{range .Items}}<div class="item">
+{{if eq .Kind "video"}}<video src="{{.File}}" controls preload="metadata"></video>{{else}}<a href="{{.File}}"><img src="{{.Preview}}" loading="lazy" alt=""></a>{{end}}
+<div class="info">
+<div class="meta">{{.Time.Format "2006-01-02 15:04"}} · {{.Size}}{{if .Discord}} · <a href="{{.Discord}}">Discord</a>{{end}}</div>
+{{if .Channel}}<div><a href="{{.Channel}}">{{if .ChannelName}}#{{.ChannelName}}{{else}}{{.ChannelID}}{{end}}</a>{{if .ServerName}} <span class="meta">in {{.ServerName}}</span>{{end}}</div>{{end}}
+{{if .User}}<div><a href="{{.User}}">{{if .Username}}@{{.Username}}{{else}}{{.UserID}}{{end}}</a></div>{{end}}
+{{if .Content}}<div class="content">{{.Content}}</div>{{end}}
+</div>
+</div>
+{{end}}</div>{{end}}
+{{if gt .Pages 1}}<div class="pager">{{if .Prev}}<a href="{{.Prev}}">&larr; Newer</a> {{end}}Page {{.Page}} of {{.Pages}}{{if .Next}} <a href="{{.Next}}">Older &rarr;</a>{{end}}</div>{{end}}
+<p class="meta">Built {{.Built.Format "2006-01-02 15:04:05"}}</p>
+</body>
+</html>
+`))
+
+//#endregion
+
+//#region Gallery Schedule
+
+var galleryScheduleMutex sync.Mutex
+
+// Builds galleries whose buildEvery has passed since they were last built, checked every minute.
+func buildDueGalleries() {
+	if !galleryScheduleMutex.TryLock() {
+		return
+	}
+	defer galleryScheduleMutex.Unlock()
+	for _, target := range getGalleryTargets() {
+		if target.Gallery.BuildEvery == nil || *target.Gallery.BuildEvery <= 0 {
+			continue
+		}
+		galleryPath := galleryAbs(target.Gallery.Destination)
+		galleryMutex.Lock()
+		lastBuild, known := galleryLastBuild[galleryPath]
+		galleryMutex.Unlock()
+		if !known {
+			if state, err := loadGalleryState(galleryPath); err == nil {
+				lastBuild = state.Built
+			}
+		}
+		if time.Since(lastBuild) < time.Duration(*target.Gallery.BuildEvery)*time.Minute {
+			continue
+		}
+		buildT := time.Now()
+		result, err := buildGallery(target, false)
+		if err != nil {
+			log.Println(lg("Gallery", "", color.HiRedString, "Failed to build gallery \"%s\":\t%s", galleryPath, err))
+			continue
+		}
+		if result.Added > 0 || result.Removed > 0 {
+			log.Println(lg("Gallery", "", color.HiCyanString,
+				"Built gallery \"%s\", %d new, %d removed, %d total\t(took %s)",
+				galleryPath, result.Added, result.Removed, result.Total, timeSinceShort(buildT)))
+		}
+	}
+}
+
+//#endregion
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,7 +19,7 @@ var lastMessageID string
 
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if lastMessageID != m.ID {
-		handleMessage(m.Message, nil, false, false)
+		handleMessage(m.Message, nil, false, false, config.DryRun)
 	}
 	lastMessageID = m.ID
 }
@@ -26,13 +27,13 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 func messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
 	if lastMessageID != m.ID {
 		if m.EditedTimestamp != nil {
-			handleMessage(m.Message, nil, true, false)
+			handleMessage(m.Message, nil, true, false, config.DryRun)
 		}
 	}
 	lastMessageID = m.ID
 }
 
-func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, history bool) []downloadedItem {
+func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, history bool, dryRun bool) []downloadedItem {
 	shouldBail := false //TODO: this is messy, overlapped purpose with shouldAbort used for filters down below in this func.
 	shouldBailReason := ""
 	// Ignore own messages unless told not to
@@ -42,7 +43,7 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 	}
 
 	if !history && !edited {
-		timeLastMessage = time.Now()
+		timeLastMessage.set(time.Now())
 	}
 
 	// Admin Channel
@@ -67,11 +68,18 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 
 	// Registered Channel
 	if sourceConfig := getSource(m); sourceConfig != emptySourceConfig {
+		// Where catch-up picks up after downtime, dry runs mustn't move it past what wasn't downloaded
+		if !history && !edited && !dryRun {
+			recordCheckpoint(m.ChannelID, m.ID)
+		}
+
 		// Ignore bots if told to do so
 		if m.Author.Bot && *sourceConfig.IgnoreBots {
 			shouldBail = true
 			shouldBailReason = "config.IgnoreBots"
 		}
+		// Archived even when edits aren't scanned for downloads
+		shouldArchive := sourceConfig.Archive != nil && !dryRun && !shouldBail && (history || *sourceConfig.Enabled)
 		// Ignore if told so by config
 		if (!history && !*sourceConfig.Enabled) || (edited && !*sourceConfig.ScanEdits) {
 			shouldBail = true
@@ -84,11 +92,19 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 				log.Println(lg("Debug", "Message", color.YellowString,
 					"%s Ignoring message due to %s...", color.HiMagentaString("(CONFIG)"), shouldBailReason))
 			}
+			if shouldArchive {
+				archiveMessage(fixMessage(m), *sourceConfig.Archive)
+			}
 			return nil
 		}
 
 		m = fixMessage(m)
 
+		// Archive
+		if shouldArchive {
+			archiveMessage(m, *sourceConfig.Archive)
+		}
+
 		// Log
 		if config.MessageOutput {
 			sendLabel := fmt.Sprintf("%s in \"%s\"#%s",
@@ -115,7 +131,7 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 		}
 
 		// Log Messages to File
-		if sourceConfig.LogMessages != nil {
+		if sourceConfig.LogMessages != nil && !dryRun {
 			if sourceConfig.LogMessages.Destination != "" {
 				encounteredErrors := false
 				savePath := sourceConfig.LogMessages.Destination + string(os.PathSeparator)
@@ -168,16 +184,13 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 							"Save path %s is invalid... %s", savePath, err))
 					} else {
 						// Format filename
-						filename := m.ChannelID + ".txt"
+						filename := m.ChannelID
 						if sourceConfig.LogMessages.FilenameFormat != nil {
 							if *sourceConfig.LogMessages.FilenameFormat != "" {
 								filename = dataKeys_DiscordMessage(*sourceConfig.LogMessages.FilenameFormat, m)
-								// if extension presumed missing
-								if !strings.Contains(filename, ".") {
-									filename += ".txt"
-								}
 							}
 						}
+						filename = sourceConfig.LogMessages.filename(filename)
 
 						// Scrub filename
 						filename = clearSourceLogField(filename, *sourceConfig.LogMessages)
@@ -219,33 +232,11 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 						}
 						newLine += "\n" + prefix + lineContent + suffix
 
-						// Read
-						currentLog := ""
-						if logfile, err := os.ReadFile(logPath); err == nil {
-							currentLog = string(logfile)
-						}
-						canLog := true
-						// Filter Duplicates
-						if sourceConfig.LogMessages.FilterDuplicates != nil {
-							if *sourceConfig.LogMessages.FilterDuplicates {
-								if strings.Contains(currentLog, newLine) {
-									canLog = false
-								}
-							}
-						}
-
-						if canLog {
-							// Writer
-							f, err := os.OpenFile(logPath, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0600)
-							if err != nil {
-								log.Println(lg("LogMessages", "", color.RedString, "[sourceConfig.LogMessages] Failed to open log file:\t%s", err))
-								f.Close()
-							}
-							defer f.Close()
-
-							if _, err = f.WriteString(newLine); err != nil {
-								log.Println(lg("Message", "", color.RedString, "[sourceConfig.LogMessages] Failed to append file:\t%s", err))
-							}
+						// Writer, duplicates are filtered in there
+						record := newSourceLogRecord(m)
+						record.Edited = edited
+						if err := appendSourceLog(logPath, *sourceConfig.LogMessages, newLine, record); err != nil {
+							log.Println(lg("Message", "", color.RedString, "[sourceConfig.LogMessages] Failed to append file:\t%s", err))
 						}
 					}
 				}
@@ -253,6 +244,8 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 		}
 
 		// Filters
+		hasRules := sourceHasRules(sourceConfig)
+		messageFiltered := false // with rules, filters only decide when no rule matches
 		if sourceConfig.Filters != nil {
 			shouldAbort := false
 
@@ -268,34 +261,28 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 			}
 
 			if sourceConfig.Filters.BlockedPhrases != nil {
-				for _, phrase := range *sourceConfig.Filters.BlockedPhrases {
-					if strings.Contains(m.Content, phrase) && phrase != "" {
-						shouldAbort = true
-						if config.Debug {
-							log.Println(lg("Debug", "Message", color.YellowString,
-								"%s blockedPhrases found \"%s\" in message, planning to abort...",
-								color.HiMagentaString("(FILTER)"), phrase))
-						}
-						break
+				if phrase, found := filterContainsAny(m.Content, *sourceConfig.Filters.BlockedPhrases); found {
+					shouldAbort = true
+					if config.Debug {
+						log.Println(lg("Debug", "Message", color.YellowString,
+							"%s blockedPhrases found \"%s\" in message, planning to abort...",
+							color.HiMagentaString("(FILTER)"), phrase))
 					}
 				}
 			}
 			if sourceConfig.Filters.AllowedPhrases != nil {
-				for _, phrase := range *sourceConfig.Filters.AllowedPhrases {
-					if strings.Contains(m.Content, phrase) && phrase != "" {
-						shouldAbort = false
-						if config.Debug {
-							log.Println(lg("Debug", "Message", color.YellowString,
-								"%s allowedPhrases found \"%s\" in message, planning to process...",
-								color.HiMagentaString("(FILTER)"), phrase))
-						}
-						break
+				if phrase, found := filterContainsAny(m.Content, *sourceConfig.Filters.AllowedPhrases); found {
+					shouldAbort = false
+					if config.Debug {
+						log.Println(lg("Debug", "Message", color.YellowString,
+							"%s allowedPhrases found \"%s\" in message, planning to process...",
+							color.HiMagentaString("(FILTER)"), phrase))
 					}
 				}
 			}
 
 			if sourceConfig.Filters.BlockedUsers != nil {
-				if stringInSlice(m.Author.ID, *sourceConfig.Filters.BlockedUsers) {
+				if filterEqualsAny(m.Author.ID, *sourceConfig.Filters.BlockedUsers) {
 					shouldAbort = true
 					if config.Debug {
 						log.Println(lg("Debug", "Message", color.YellowString,
@@ -305,7 +292,7 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 				}
 			}
 			if sourceConfig.Filters.AllowedUsers != nil {
-				if stringInSlice(m.Author.ID, *sourceConfig.Filters.AllowedUsers) {
+				if filterEqualsAny(m.Author.ID, *sourceConfig.Filters.AllowedUsers) {
 					shouldAbort = false
 					if config.Debug {
 						log.Println(lg("Debug", "Message", color.YellowString,
@@ -322,7 +309,7 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 				}
 				if member != nil {
 					for _, role := range member.Roles {
-						if stringInSlice(role, *sourceConfig.Filters.BlockedRoles) {
+						if filterEqualsAny(role, *sourceConfig.Filters.BlockedRoles) {
 							shouldAbort = true
 							if config.Debug {
 								log.Println(lg("Debug", "Message", color.YellowString,
@@ -341,7 +328,7 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 				}
 				if member != nil {
 					for _, role := range member.Roles {
-						if stringInSlice(role, *sourceConfig.Filters.AllowedRoles) {
+						if filterEqualsAny(role, *sourceConfig.Filters.AllowedRoles) {
 							shouldAbort = false
 							if config.Debug {
 								log.Println(lg("Debug", "Message", color.YellowString,
@@ -355,12 +342,19 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 			}
 
 			// Abort
-			if shouldAbort {
+			if shouldAbort && hasRules {
+				messageFiltered = true
+			} else if shouldAbort {
 				if config.Debug {
 					log.Println(lg("Debug", "Message", color.YellowString,
 						"%s Filter decided to ignore message...",
 						color.HiMagentaString("(FILTER)")))
 				}
+				if dryRun {
+					for _, file := range getLinksByMessage(m) {
+						dryRunReportSkip(m, file.Link, history, "Filtered out by message filters")
+					}
+				}
 				return nil
 			}
 		}
@@ -385,6 +379,11 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 
 		// Process Collected Links
 		var downloadedItems []downloadedItem
+		type awaitedDownload struct {
+			link   string
+			result <-chan queueResult
+		}
+		var awaiting []awaitedDownload
 		files := getLinksByMessage(m)
 		for _, file := range files {
 			// Blank link?
@@ -398,45 +397,79 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 			// Filter Checks
 			shouldAbort := false
 			if sourceConfig.Filters.BlockedLinkContent != nil {
-				for _, phrase := range *sourceConfig.Filters.BlockedLinkContent {
-					if strings.Contains(file.Link, phrase) && phrase != "" {
-						shouldAbort = true
-						if config.Debug {
-							log.Println(lg("Debug", "Message", color.YellowString,
-								"%s blockedLinkContent found \"%s\" in link, planning to abort...",
-								color.HiMagentaString("(FILTER)"), phrase))
-						}
-						break
+				if phrase, found := filterContainsAny(file.Link, *sourceConfig.Filters.BlockedLinkContent); found {
+					shouldAbort = true
+					if config.Debug {
+						log.Println(lg("Debug", "Message", color.YellowString,
+							"%s blockedLinkContent found \"%s\" in link, planning to abort...",
+							color.HiMagentaString("(FILTER)"), phrase))
 					}
 				}
 			}
 			if sourceConfig.Filters.AllowedLinkContent != nil {
-				for _, phrase := range *sourceConfig.Filters.AllowedLinkContent {
-					if strings.Contains(file.Link, phrase) && phrase != "" {
-						shouldAbort = false
-						if config.Debug {
-							log.Println(lg("Debug", "Message", color.YellowString,
-								"%s allowedLinkContent found \"%s\" in link, planning to process...",
-								color.HiMagentaString("(FILTER)"), phrase))
-						}
-						break
+				if phrase, found := filterContainsAny(file.Link, *sourceConfig.Filters.AllowedLinkContent); found {
+					shouldAbort = false
+					if config.Debug {
+						log.Println(lg("Debug", "Message", color.YellowString,
+							"%s allowedLinkContent found \"%s\" in link, planning to process...",
+							color.HiMagentaString("(FILTER)"), phrase))
 					}
 				}
 			}
-			if shouldAbort {
+			filteredOut := shouldAbort || messageFiltered
+			if hasRules {
+				// Rules that don't need the file are settled here, the rest once it's requested
+				domain := ""
+				if parsedURL, err := url.Parse(file.Link); err == nil {
+					domain = parsedURL.Hostname()
+				}
+				rule, deferred := evaluateRules(*sourceConfig.Rules, &ruleContext{
+					Message: m,
+					Link:    file.Link,
+					Domain:  domain,
+					History: history,
+				})
+				if rule != nil && strings.ToLower(rule.Action) == ruleActionSkip {
+					if config.Debug {
+						log.Println(lg("Debug", "Message", color.YellowString,
+							"%s Rule \"%s\" decided to skip link...",
+							color.HiMagentaString("(RULE)"), rule.When))
+					}
+					if dryRun {
+						dryRunReportSkip(m, file.Link, history, "Matched skip rule: "+rule.When)
+					}
+					continue
+				}
+				if rule != nil {
+					filteredOut = false
+				} else if !deferred && filteredOut {
+					if config.Debug {
+						log.Println(lg("Debug", "Message", color.YellowString,
+							"%s No rule matched, filter decided to ignore link...",
+							color.HiMagentaString("(FILTER)")))
+					}
+					if dryRun {
+						dryRunReportSkip(m, file.Link, history, "Filtered out, no rule matched")
+					}
+					continue
+				}
+			} else if shouldAbort {
 				if config.Debug {
 					log.Println(lg("Debug", "Message", color.YellowString,
 						"%s Filter decided to ignore link...",
 						color.HiMagentaString("(FILTER)")))
 				}
+				if dryRun {
+					dryRunReportSkip(m, file.Link, history, "Filtered out by link filters")
+				}
 				continue
 			}
 			// Output
 			if config.Debug && (!history || config.MessageOutputHistory) {
 				log.Println(lg("Debug", "Message", color.HiCyanString, "FOUND FILE: "+file.Link+fmt.Sprintf(" \t<%s>", m.ID)))
 			}
-			// Handle Download
-			status, filesize := downloadRequestStruct{
+			// Queue Download
+			request := downloadRequestStruct{
 				InputURL:     file.Link,
 				Filename:     file.Filename,
 				Path:         sourceConfig.Destination,
@@ -447,14 +480,27 @@ func handleMessage(m *discordgo.Message, c *discordgo.Channel, edited bool, hist
 				EmojiCmd:     false,
 				StartTime:    time.Now(),
 				AttachmentID: file.AttachmentID,
-			}.handleDownload()
-			// Await Status
-			if status.Status == downloadSuccess {
-				domain, _ := getDomain(file.Link)
+				FilteredOut:  filteredOut,
+				DryRun:       dryRun,
+			}
+			if history { // history waits on results for its tallies, live messages jump ahead of it
+				awaiting = append(awaiting, awaitedDownload{
+					link:   file.Link,
+					result: downloadQueue.add(request, queuePriorityHistory, true),
+				})
+			} else {
+				downloadQueue.add(request, queuePriorityLive, false)
+			}
+		}
+		// Await Status
+		for _, download := range awaiting {
+			result := <-download.result
+			if result.Status.Status == downloadSuccess {
+				domain, _ := getDomain(download.link)
 				downloadedItems = append(downloadedItems, downloadedItem{
-					URL:      file.Link,
+					URL:      download.link,
 					Domain:   domain,
-					Filesize: filesize,
+					Filesize: result.Filesize,
 				})
 			}
 		}
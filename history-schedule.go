@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+//#region History Schedules
+
+// Sources with a historySchedule get incremental history jobs queued on it, picking up from the
+// channel's history cache checkpoint. Either a 5 field cron expression ("0 */6 * * *", local time)
+// or a plain interval ("6h"), intervals count from the channel's last run.
+
+const historyScheduleOrigin = "SCHEDULE"
+
+type historySchedule struct {
+	interval time.Duration
+
+	minutes, hours, days, months, weekdays uint64 // cron fields as bitsets
+	anyDay, anyWeekday                     bool
+}
+
+var historyScheduleMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+func parseHistorySchedule(spec string) (historySchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if macro, ok := historyScheduleMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval < time.Minute {
+			return historySchedule{}, errors.New("interval under a minute")
+		}
+		return historySchedule{interval: interval}, nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return historySchedule{}, errors.New("expected a duration or 5 cron fields")
+	}
+	var schedule historySchedule
+	var err error
+	for i, field := range []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&schedule.minutes, 0, 59},
+		{&schedule.hours, 0, 23},
+		{&schedule.days, 1, 31},
+		{&schedule.months, 1, 12},
+		{&schedule.weekdays, 0, 7},
+	} {
+		if *field.bits, err = parseCronField(fields[i], field.min, field.max); err != nil {
+			return historySchedule{}, fmt.Errorf("field %d \"%s\": %s", i+1, fields[i], err)
+		}
+	}
+	if schedule.weekdays&(1<<7) != 0 { // 7 is sunday too
+		schedule.weekdays |= 1
+	}
+	schedule.anyDay = fields[2] == "*" || strings.HasPrefix(fields[2], "*/")
+	schedule.anyWeekday = fields[4] == "*" || strings.HasPrefix(fields[4], "*/")
+	return schedule, nil
+}
+
+// Comma separated values, ranges & steps: 5 | 1-5 | */15 | 0-30/10 | 5/15
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, errors.New("bad step")
+			}
+			rangePart = part[:i]
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.New("bad value")
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.New("bad range")
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("out of range %d-%d", min, max)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func (schedule historySchedule) matchesDay(t time.Time) bool {
+	day := schedule.days&(1<<t.Day()) != 0
+	weekday := schedule.weekdays&(1<<int(t.Weekday())) != 0
+	switch {
+	case schedule.anyDay && schedule.anyWeekday:
+		return true
+	case schedule.anyDay:
+		return weekday
+	case schedule.anyWeekday:
+		return day
+	default: // either, like cron
+		return day || weekday
+	}
+}
+
+// First time the schedule is due after t, zero if never within a few years.
+func (schedule historySchedule) next(t time.Time) time.Time {
+	if schedule.interval > 0 {
+		return t.Add(schedule.interval)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case schedule.months&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !schedule.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case schedule.hours&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case schedule.minutes&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+//#region Queueing
+
+type historyScheduleState struct {
+	ChannelID string
+	Spec      string
+	Next      time.Time
+	Last      time.Time
+	Error     string
+}
+
+var (
+	historySchedulesMutex   sync.Mutex
+	historySchedules        = map[string]*historyScheduleState{} // by channel
+	historySchedulesChecked time.Time
+)
+
+// When the channel's history last finished or was interrupted, from its cache.
+func historyCacheUpdated(channelID string) time.Time {
+	var cache historyCache
+	if f, err := os.ReadFile(pathCacheHistory + string(os.PathSeparator) + channelID + ".json"); err == nil {
+		json.Unmarshal(f, &cache)
+	}
+	return cache.Updated
+}
+
+// Queues jobs for schedules that are due, called from the history manager.
+func checkHistorySchedules() {
+	now := time.Now()
+	if now.Truncate(time.Minute).Equal(historySchedulesChecked.Truncate(time.Minute)) { // once a minute is enough
+		return
+	}
+	historySchedulesChecked = now
+
+	historySchedulesMutex.Lock()
+	defer historySchedulesMutex.Unlock()
+	scheduled := map[string]bool{}
+	for _, channel := range getAllRegisteredChannels() {
+		sourceConfig := getSource(&discordgo.Message{ChannelID: channel.ChannelID})
+		if sourceConfig.HistorySchedule == nil || strings.TrimSpace(*sourceConfig.HistorySchedule) == "" {
+			continue
+		}
+		spec := strings.TrimSpace(*sourceConfig.HistorySchedule)
+		scheduled[channel.ChannelID] = true
+
+		state, exists := historySchedules[channel.ChannelID]
+		if !exists || state.Spec != spec { // new or changed in settings
+			state = &historyScheduleState{ChannelID: channel.ChannelID, Spec: spec}
+			historySchedules[channel.ChannelID] = state
+		}
+		schedule, err := parseHistorySchedule(spec)
+		if err != nil {
+			if state.Error == "" {
+				log.Println(lg("History", "Schedule", color.HiRedString,
+					"Invalid history schedule \"%s\" for %s:\t%s", spec, channel.ChannelID, err))
+			}
+			state.Error = err.Error()
+			continue
+		}
+		if state.Next.IsZero() {
+			from := now
+			if updated := historyCacheUpdated(channel.ChannelID); schedule.interval > 0 && !updated.IsZero() {
+				from = updated
+			}
+			state.Next = schedule.next(from)
+		}
+		if state.Next.IsZero() || now.Before(state.Next) {
+			continue
+		}
+
+		state.Next = schedule.next(now)
+		if job, exists := getHistoryJob(channel.ChannelID); exists &&
+			(job.Status == historyStatusWaiting || job.Status == historyStatusRunning || job.Status == historyStatusAbortRequested) {
+			continue // still going from last time, or queued some other way
+		}
+		state.Last = now
+		setHistoryJob(channel.ChannelID, historyJob{
+			Status:          historyStatusWaiting,
+			OriginChannel:   historyScheduleOrigin,
+			OriginUser:      historyScheduleOrigin,
+			TargetChannelID: channel.ChannelID,
+			Updated:         now,
+			Added:           now,
+		})
+		if config.Verbose {
+			log.Println(lg("Verbose", "History", color.HiBlueString,
+				"Queued scheduled history for %s, next due %s", channel.ChannelID, state.Next.Format("2006-01-02 15:04")))
+		}
+	}
+	for channelID := range historySchedules {
+		if !scheduled[channelID] {
+			delete(historySchedules, channelID)
+		}
+	}
+}
+
+func historySchedulesSnapshot() []historyScheduleState {
+	historySchedulesMutex.Lock()
+	defer historySchedulesMutex.Unlock()
+	ret := make([]historyScheduleState, 0, len(historySchedules))
+	for _, state := range historySchedules {
+		ret = append(ret, *state)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if !ret[i].Next.Equal(ret[j].Next) {
+			return ret[i].Next.Before(ret[j].Next)
+		}
+		return ret[i].ChannelID < ret[j].ChannelID
+	})
+	return ret
+}
+
+//#endregion
+
+//#endregion
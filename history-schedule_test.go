@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"30s",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+		"@yearly",
+	} {
+		if _, err := parseHistorySchedule(spec); err == nil {
+			t.Errorf("%q parsed, want an error", spec)
+		}
+	}
+}
+
+func TestHistoryScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"6h", "2026-01-01 10:30", "2026-01-01 16:30"},
+		{" 90m ", "2026-01-01 23:00", "2026-01-02 00:30"},
+		{"* * * * *", "2026-01-01 10:30", "2026-01-01 10:31"},
+		{"0 * * * *", "2026-01-01 10:30", "2026-01-01 11:00"},
+		{"@hourly", "2026-01-01 10:00", "2026-01-01 11:00"},
+		{"@DAILY", "2026-01-01 10:00", "2026-01-02 00:00"},
+		{"*/15 * * * *", "2026-01-01 10:31", "2026-01-01 10:45"},
+		{"0 */6 * * *", "2026-01-01 19:00", "2026-01-02 00:00"},
+		{"5/20 * * * *", "2026-01-01 10:26", "2026-01-01 10:45"},
+		{"0-30/10 9 * * *", "2026-01-01 09:30", "2026-01-02 09:00"},
+		{"0 9,17 * * *", "2026-01-01 09:00", "2026-01-01 17:00"},
+		{"0 0 1 * *", "2026-01-15 12:00", "2026-02-01 00:00"},
+		{"0 0 31 * *", "2026-02-01 00:00", "2026-03-31 00:00"},
+		{"0 0 29 2 *", "2026-01-01 00:00", "2028-02-29 00:00"},
+		{"0 12 * * 1-5", "2026-01-02 12:00", "2026-01-05 12:00"}, // friday to monday
+		{"0 0 * * 7", "2026-01-01 00:00", "2026-01-04 00:00"},    // 7 is sunday
+		{"@weekly", "2026-01-01 00:00", "2026-01-04 00:00"},
+		{"0 0 13 * 5", "2026-01-01 00:00", "2026-01-02 00:00"}, // day or weekday, like cron
+		{"0 0 1 6 *", "2026-07-01 00:00", "2027-06-01 00:00"},
+	}
+	for _, test := range tests {
+		schedule, err := parseHistorySchedule(test.spec)
+		if err != nil {
+			t.Errorf("%q: %s", test.spec, err)
+			continue
+		}
+		if got := schedule.next(at(test.from)); !got.Equal(at(test.want)) {
+			t.Errorf("%q from %s = %s, want %s", test.spec, test.from, got.Format("2006-01-02 15:04"), test.want)
+		}
+	}
+
+	impossible, err := parseHistorySchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := impossible.next(at("2026-01-01 00:00")); !got.IsZero() {
+		t.Errorf("february 31st came due at %s", got)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+//#region History Segments
+
+// A channel's snowflake range is split into segments fetched concurrently, each fetching pages ahead
+// while the ones before them are still downloading. Segments of a channel share its rate-limit bucket & request
+// delay. Messages are processed newest first within a segment but the segments interleave, so with more than one
+// which of two same-named files gets the -N suffix depends on timing.
+
+const (
+	historySegmentMinSpan = int64(24*time.Hour/time.Millisecond) << 22 // not worth splitting smaller
+	historyStatusInterval = 5 * time.Second
+)
+
+type historySegment struct {
+	Since     string    `json:"since,omitempty"` // oldest message covered, empty for the start of the channel
+	Before    string    `json:"before"`          // fetching continues below this
+	Done      bool      `json:"done,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Requests  int       `json:"-"`
+	Messages  int64     `json:"-"`
+	Downloads int64     `json:"-"`
+	Reached   time.Time `json:"-"` // oldest message processed so far
+}
+
+var (
+	historySegmentsMutex   sync.Mutex // guards segments while they run
+	historySegmentsRunning = map[string][]*historySegment{}
+)
+
+func snowflakeNow() string {
+	return fmt.Sprint((time.Now().UnixMilli() + 1 - discordEpoch) << 22)
+}
+
+// Splits [since, before) into up to count segments, newest first. The channel ID stands in for an open since.
+func splitHistorySegments(channelID string, since string, before string, count int) []*historySegment {
+	if before == "" {
+		before = snowflakeNow()
+	}
+	low := since
+	if low == "" {
+		low = channelID
+	}
+	low64, _ := strconv.ParseInt(low, 10, 64)
+	high64, err := strconv.ParseInt(before, 10, 64)
+	span := high64 - low64
+	if err != nil || span <= 0 || count < 1 {
+		return []*historySegment{{Since: since, Before: before}}
+	}
+	if maxCount := span / historySegmentMinSpan; int64(count) > maxCount {
+		count = int(max(maxCount, 1))
+	}
+	segments := make([]*historySegment, count)
+	for i := range segments {
+		segments[i] = &historySegment{
+			Before: fmt.Sprint(high64 - span*int64(i)/int64(count)),
+			Since:  fmt.Sprint(high64 - span*int64(i+1)/int64(count)),
+		}
+	}
+	segments[count-1].Since = since
+	return segments
+}
+
+func historySegmentsSnapshot(jobChannelID string) []historySegment {
+	historySegmentsMutex.Lock()
+	defer historySegmentsMutex.Unlock()
+	var ret []historySegment
+	for _, segment := range historySegmentsRunning[jobChannelID] {
+		ret = append(ret, *segment)
+	}
+	return ret
+}
+
+// Forgets the job's segments once its final state is written.
+func clearHistorySegments(jobChannelID string) {
+	historySegmentsMutex.Lock()
+	delete(historySegmentsRunning, jobChannelID)
+	historySegmentsMutex.Unlock()
+}
+
+func historySegmentLabel(segment historySegment) string {
+	switch {
+	case segment.Error != "":
+		return "failed: " + segment.Error
+	case segment.Done:
+		return "done"
+	case segment.Reached.IsZero():
+		return "starting"
+	default:
+		return "at " + segment.Reached.Format("2006-01-02")
+	}
+}
+
+// Waits out the channel's message bucket when it's spent rather than piling requests onto it.
+func historyRateLimitWait(channelID string) {
+	bucket := bot.Ratelimiter.GetBucket(discordgo.EndpointChannelMessages(channelID))
+	if wait := bot.Ratelimiter.GetWaitTime(bucket, 1); wait > 0 {
+		if config.Debug {
+			log.Println(lg("Debug", "History", color.YellowString,
+				"%s: waiting %s for the rate limit", channelID, wait))
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Fetches & processes the segments until they're done, stop returns true or their requests fail.
+// process handles a message & returns the files it downloaded, within a segment newest first.
+func runHistorySegments(jobChannelID string, channelID string, segments []*historySegment,
+	stop func() bool, process func(message *discordgo.Message) int64, logStatus bool, logPrefix string) {
+	historySegmentsMutex.Lock()
+	historySegmentsRunning[jobChannelID] = segments
+	historySegmentsMutex.Unlock()
+
+	// The request delay is for the channel, the segments take turns on one ticker
+	var requestTicks <-chan time.Time
+	if config.HistoryRequestDelay > 0 {
+		ticker := time.NewTicker(time.Second * time.Duration(config.HistoryRequestDelay))
+		defer ticker.Stop()
+		requestTicks = ticker.C
+	}
+
+	var wg sync.WaitGroup
+	for i, segment := range segments {
+		if segment.Done {
+			continue
+		}
+		label := fmt.Sprintf("%d/%d", i+1, len(segments))
+		pages := make(chan []*discordgo.Message, max(config.HistoryPrefetch, 0))
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			defer close(pages)
+			fetchHistorySegment(channelID, segment, pages, requestTicks, stop, logStatus, logPrefix+"Segment "+label+" ")
+		}()
+		go func() {
+			defer wg.Done()
+			stopped := false
+			for page := range pages { // drained even when stopped so the fetcher never blocks
+				for _, message := range page {
+					if stopped = stopped || stop(); stopped {
+						break
+					}
+					downloads := process(message)
+					historySegmentsMutex.Lock()
+					segment.Messages++
+					segment.Downloads += downloads
+					segment.Reached = message.Timestamp
+					historySegmentsMutex.Unlock()
+				}
+				if !stopped {
+					historySegmentsMutex.Lock()
+					segment.Before = page[len(page)-1].ID
+					historySegmentsMutex.Unlock()
+				}
+			}
+			historySegmentsMutex.Lock()
+			segment.Done = !stopped && segment.Error == ""
+			historySegmentsMutex.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+func fetchHistorySegment(channelID string, segment *historySegment, pages chan<- []*discordgo.Message,
+	requestTicks <-chan time.Time, stop func() bool, logStatus bool, logPrefix string) {
+	historySegmentsMutex.Lock()
+	before := segment.Before
+	since64, _ := strconv.ParseInt(segment.Since, 10, 64)
+	historySegmentsMutex.Unlock()
+
+	for !stop() {
+		historySegmentsMutex.Lock()
+		segment.Requests++
+		messages, downloads := segment.Messages, segment.Downloads
+		historySegmentsMutex.Unlock()
+		if logStatus {
+			log.Println(lg("History", "", color.CyanString,
+				logPrefix+"requesting more, \t%d downloaded, \t%d processed, \tsearching before %s",
+				downloads, messages, discordSnowflakeToTimestamp(before, "2006-01-02 15:04:05")))
+		}
+		if requestTicks != nil {
+			<-requestTicks
+		}
+		historyRateLimitWait(channelID)
+
+		page, err := bot.ChannelMessages(channelID, config.HistoryRequestCount, before, "", "")
+		if err != nil {
+			historySegmentsMutex.Lock()
+			segment.Error = err.Error()
+			historySegmentsMutex.Unlock()
+			log.Println(lg("History", "", color.HiRedString, logPrefix+"error requesting messages:\t%s", err))
+			return
+		}
+		if len(page) == 0 {
+			return
+		}
+		before = page[len(page)-1].ID
+
+		// Keep what's in the segment
+		inRange := len(page)
+		for inRange > 0 {
+			if id, _ := strconv.ParseInt(page[inRange-1].ID, 10, 64); id >= since64 {
+				break
+			}
+			inRange--
+		}
+		if inRange > 0 {
+			pages <- page[:inRange]
+		}
+		if inRange < len(page) { // reached the segment below
+			return
+		}
+	}
+}
+
+//#endregion
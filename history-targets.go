@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//#region History Targets
+
+// The history command's --server, --category & --user-source targets expand to every channel history can run on
+// in scope, threads come along with their channels. They're summarized & confirmed before anything is queued.
+
+const (
+	historyConfirmTimeout   = 5 * time.Minute
+	historyEstimateSamples  = 20
+	historyEstimatePageSize = 100
+	historyMemberLookups    = 8 // concurrent requests for a user's membership the state doesn't have
+)
+
+type historyScope struct {
+	Channels []string
+	Threads  int // active ones covered with their channels
+	Skipped  int // no permission to read history
+	Errors   []string
+	Types    map[string]int
+}
+
+func historyChannelKind(channelType discordgo.ChannelType) string {
+	switch channelType {
+	case discordgo.ChannelTypeGuildText:
+		return "text"
+	case discordgo.ChannelTypeGuildNews:
+		return "announcement"
+	case discordgo.ChannelTypeGuildForum:
+		return "forum"
+	case discordgo.ChannelTypeGuildVoice, discordgo.ChannelTypeGuildStageVoice:
+		return "voice"
+	case discordgo.ChannelTypeGuildNewsThread, discordgo.ChannelTypeGuildPublicThread, discordgo.ChannelTypeGuildPrivateThread:
+		return "thread"
+	}
+	return ""
+}
+
+func lookupChannel(channelID string) (*discordgo.Channel, error) {
+	channel, err := bot.State.Channel(channelID)
+	if err != nil {
+		channel, err = bot.Channel(channelID)
+	}
+	return channel, err
+}
+
+// With a user source alone its scope is every server the user is in, otherwise it only picks the source.
+func expandHistoryScope(serverIDs []string, categoryIDs []string, userID string) historyScope {
+	scope := historyScope{Types: map[string]int{}}
+	var guilds []*discordgo.Guild
+	guildsAdded := map[string]bool{}
+	addGuild := func(guild *discordgo.Guild) {
+		if !guildsAdded[guild.ID] {
+			guildsAdded[guild.ID] = true
+			guilds = append(guilds, guild)
+		}
+	}
+	for _, serverID := range serverIDs {
+		guild, err := bot.State.Guild(serverID)
+		if err != nil {
+			guild, err = bot.Guild(serverID)
+		}
+		if err != nil {
+			scope.Errors = append(scope.Errors, fmt.Sprintf("server `%s` not found", serverID))
+			continue
+		}
+		addGuild(guild)
+	}
+	categories := map[string]bool{}
+	for _, categoryID := range categoryIDs {
+		category, err := lookupChannel(categoryID)
+		if err != nil || category.Type != discordgo.ChannelTypeGuildCategory {
+			scope.Errors = append(scope.Errors, fmt.Sprintf("category `%s` not found", categoryID))
+			continue
+		}
+		categories[categoryID] = true
+		if guild, err := bot.State.Guild(category.GuildID); err == nil {
+			addGuild(guild)
+		}
+	}
+	if userID != "" && len(serverIDs) == 0 && len(categoryIDs) == 0 {
+		bot.State.RLock()
+		stateGuilds := append([]*discordgo.Guild{}, bot.State.Guilds...)
+		bot.State.RUnlock()
+		isMember := make([]bool, len(stateGuilds))
+		var wg sync.WaitGroup
+		lookups := make(chan struct{}, historyMemberLookups)
+		for i, guild := range stateGuilds {
+			if _, err := bot.State.Member(guild.ID, userID); err == nil {
+				isMember[i] = true
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lookups <- struct{}{}
+				defer func() { <-lookups }()
+				_, err := bot.GuildMember(guild.ID, userID)
+				isMember[i] = err == nil
+			}()
+		}
+		wg.Wait()
+		for i, guild := range stateGuilds {
+			if isMember[i] {
+				addGuild(guild)
+			}
+		}
+		if len(guilds) == 0 {
+			scope.Errors = append(scope.Errors, fmt.Sprintf("user `%s` shares no servers with the bot", userID))
+		}
+	}
+
+	added := map[string]bool{}
+	add := func(channel *discordgo.Channel) {
+		kind := historyChannelKind(channel.Type)
+		if kind == "" || added[channel.ID] {
+			return
+		}
+		if !hasPerms(channel.ID, discordgo.PermissionViewChannel|discordgo.PermissionReadMessageHistory) {
+			scope.Skipped++
+			return
+		}
+		added[channel.ID] = true
+		scope.Channels = append(scope.Channels, channel.ID)
+		scope.Types[kind]++
+	}
+	for _, guild := range guilds {
+		wholeServer := stringInSlice(guild.ID, serverIDs) || len(categories) == 0
+		for _, channel := range guild.Channels {
+			if wholeServer || categories[channel.ParentID] {
+				add(channel)
+			}
+		}
+		for _, thread := range guild.Threads {
+			if added[thread.ParentID] {
+				scope.Threads++
+				continue
+			}
+			if parent, err := lookupChannel(thread.ParentID); err == nil &&
+				(wholeServer || categories[parent.ParentID]) {
+				add(thread) // its channel was skipped but it's readable
+			}
+		}
+	}
+	return scope
+}
+
+func (scope historyScope) describe() string {
+	var kinds []string
+	for _, kind := range []string{"text", "announcement", "forum", "voice", "thread"} {
+		if count := scope.Types[kind]; count > 0 {
+			kinds = append(kinds, fmt.Sprintf("%d %s", count, kind))
+		}
+	}
+	ret := fmt.Sprintf("**%d channel%s**", len(scope.Channels), pluralS(len(scope.Channels)))
+	if len(kinds) > 0 {
+		ret += " (" + strings.Join(kinds, ", ") + ")"
+	}
+	if scope.Threads > 0 {
+		ret += fmt.Sprintf(", with %d active thread%s", scope.Threads, pluralS(scope.Threads))
+	}
+	if scope.Skipped > 0 {
+		ret += fmt.Sprintf("\n_%d skipped, no permission to read their history_", scope.Skipped)
+	}
+	return ret
+}
+
+// Extrapolates from the newest page of a sample of the channels, how long that page took to fill
+// against the range left to go back through. Returns the estimate & how many channels were sampled.
+func estimateHistoryMessages(channels []string, before string, since string) (int64, int) {
+	if len(channels) == 0 {
+		return 0, 0
+	}
+	step := max(len(channels)/historyEstimateSamples, 1)
+	var sampled int
+	var total float64
+	for i := 0; i < len(channels) && sampled < historyEstimateSamples; i += step {
+		channelID := channels[i]
+		page, err := bot.ChannelMessages(channelID, historyEstimatePageSize, before, "", "")
+		if err != nil {
+			continue
+		}
+		sampled++
+		low := channelID // nothing older than the channel
+		if snowflakeNewer(since, low) {
+			low = since
+		}
+		var inRange []*discordgo.Message
+		for _, message := range page {
+			if !snowflakeNewer(low, message.ID) {
+				inRange = append(inRange, message)
+			}
+		}
+		if len(inRange) < historyEstimatePageSize { // that's all of them
+			total += float64(len(inRange))
+			continue
+		}
+		newest, _ := strconv.ParseInt(inRange[0].ID, 10, 64)
+		oldest, _ := strconv.ParseInt(inRange[len(inRange)-1].ID, 10, 64)
+		low64, _ := strconv.ParseInt(low, 10, 64)
+		if newest <= oldest {
+			total += float64(len(inRange))
+			continue
+		}
+		total += float64(len(inRange)) * float64(newest-low64) / float64(newest-oldest)
+	}
+	if sampled == 0 {
+		return 0, 0
+	}
+	return int64(total / float64(sampled) * float64(len(channels))), sampled
+}
+
+func isUserSource(userID string) bool {
+	for _, source := range config.Users {
+		if source.UserID == userID || (source.UserIDs != nil && stringInSlice(userID, *source.UserIDs)) {
+			return true
+		}
+	}
+	return false
+}
+
+//#region Confirmation
+
+type historyPendingTargets struct {
+	Channels []string
+	UserID   string // user source the jobs run for
+	Before   string
+	Since    string
+	DryRun   bool
+	Expires  time.Time
+}
+
+var (
+	historyPendingMutex sync.Mutex
+	historyPending      = map[string]historyPendingTargets{} // by commanding user
+)
+
+func setHistoryPending(userID string, pending historyPendingTargets) {
+	historyPendingMutex.Lock()
+	defer historyPendingMutex.Unlock()
+	pending.Expires = time.Now().Add(historyConfirmTimeout)
+	historyPending[userID] = pending
+}
+
+func takeHistoryPending(userID string) (historyPendingTargets, bool) {
+	historyPendingMutex.Lock()
+	defer historyPendingMutex.Unlock()
+	pending, exists := historyPending[userID]
+	delete(historyPending, userID)
+	if !exists || time.Now().After(pending.Expires) {
+		return historyPendingTargets{}, false
+	}
+	return pending, true
+}
+
+//#endregion
+
+//#endregion
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -53,6 +54,32 @@ func historyStatusLabel(status historyStatus) string {
 	}
 }
 
+// Stable identifiers for anything machine-read (metrics, webhooks).
+func historyStatusName(status historyStatus) string {
+	switch status {
+	case historyStatusWaiting:
+		return "waiting"
+	case historyStatusRunning:
+		return "running"
+	case historyStatusAbortRequested:
+		return "abort_requested"
+	case historyStatusAbortCompleted:
+		return "aborted"
+	case historyStatusErrorReadMessageHistoryPerms:
+		return "error_permissions"
+	case historyStatusErrorRequesting:
+		return "error_requesting"
+	case historyStatusCompletedNoMoreMessages:
+		return "completed_no_more_messages"
+	case historyStatusCompletedToBeforeFilter:
+		return "completed_before_filter"
+	case historyStatusCompletedToSinceFilter:
+		return "completed_since_filter"
+	default:
+		return "unknown"
+	}
+}
+
 type historyJob struct {
 	Status                  historyStatus
 	OriginUser              string
@@ -61,6 +88,8 @@ type historyJob struct {
 	TargetChannelID         string
 	TargetBefore            string
 	TargetSince             string
+	TargetUserID            string // user source the job runs for, see history-targets.go
+	DryRun                  bool   // report what would be downloaded, see dryrun.go
 	DownloadCount           int64
 	DownloadSize            int64
 	Updated                 time.Time
@@ -77,12 +106,204 @@ var (
 	historyJobCntCompleted int
 )
 
+var historyJobsMutex sync.RWMutex // guards historyJobs, read through the functions below
+
+// Stores the job, announcing status changes to webhooks. The queue is saved on the manager's next tick.
+func setHistoryJob(channelID string, job historyJob) {
+	historyJobsMutex.Lock()
+	previous, existed := historyJobs.Get(channelID)
+	historyJobs.Set(channelID, job)
+	historyJobsDirty = true
+	historyJobsMutex.Unlock()
+	if !existed || previous.Status != job.Status {
+		go webhookHistoryEvent(channelID, job)
+	}
+}
+
+// Changes the stored job in place, so nothing set in between (like an abort) is overwritten.
+func updateHistoryJob(channelID string, update func(job *historyJob)) bool {
+	historyJobsMutex.Lock()
+	job, exists := historyJobs.Get(channelID)
+	if !exists {
+		historyJobsMutex.Unlock()
+		return false
+	}
+	previousStatus := job.Status
+	update(&job)
+	historyJobs.Set(channelID, job)
+	historyJobsDirty = true
+	historyJobsMutex.Unlock()
+	if previousStatus != job.Status {
+		go webhookHistoryEvent(channelID, job)
+	}
+	return true
+}
+
+func getHistoryJob(channelID string) (historyJob, bool) {
+	historyJobsMutex.RLock()
+	defer historyJobsMutex.RUnlock()
+	if historyJobs == nil {
+		return historyJob{}, false
+	}
+	return historyJobs.Get(channelID)
+}
+
+type historyJobEntry struct {
+	ChannelID string
+	Job       historyJob
+}
+
+// Copy of the jobs in the order they were queued.
+func historyJobsSnapshot() []historyJobEntry {
+	historyJobsMutex.RLock()
+	defer historyJobsMutex.RUnlock()
+	if historyJobs == nil {
+		return nil
+	}
+	ret := make([]historyJobEntry, 0, historyJobs.Len())
+	for pair := historyJobs.Oldest(); pair != nil; pair = pair.Next() {
+		ret = append(ret, historyJobEntry{pair.Key, pair.Value})
+	}
+	return ret
+}
+
+//#region Job Persistence
+
+// Unfinished jobs are kept in cache/history-jobs.json so a restart can pick up where it left off,
+// jobs resume from their channel's history cache. Saved from the history manager when anything changed.
+
+type historyJobRecord struct {
+	ChannelID     string        `json:"channelID"`
+	Status        historyStatus `json:"status"`
+	OriginUser    string        `json:"originUser,omitempty"`
+	OriginChannel string        `json:"originChannel,omitempty"`
+	Before        string        `json:"before,omitempty"`
+	Since         string        `json:"since,omitempty"`
+	UserID        string        `json:"userID,omitempty"`
+	DryRun        bool          `json:"dryRun,omitempty"`
+	DownloadCount int64         `json:"downloadCount,omitempty"`
+	DownloadSize  int64         `json:"downloadSize,omitempty"`
+	Added         time.Time     `json:"added"`
+}
+
+var (
+	historyJobsDirty     bool // guarded by historyJobsMutex
+	historyJobsSaveMutex sync.Mutex
+	historyJobsRestore   sync.Once
+)
+
+func saveHistoryJobs() {
+	historyJobsSaveMutex.Lock()
+	defer historyJobsSaveMutex.Unlock()
+	historyJobsMutex.Lock()
+	if !historyJobsDirty || historyJobs == nil {
+		historyJobsMutex.Unlock()
+		return
+	}
+	historyJobsDirty = false
+	records := []historyJobRecord{}
+	for pair := historyJobs.Oldest(); pair != nil; pair = pair.Next() {
+		job := pair.Value
+		if job.Status != historyStatusWaiting && job.Status != historyStatusRunning {
+			continue // finished, or aborting
+		}
+		records = append(records, historyJobRecord{
+			ChannelID:     pair.Key,
+			Status:        job.Status,
+			OriginUser:    job.OriginUser,
+			OriginChannel: job.OriginChannel,
+			Before:        job.TargetBefore,
+			Since:         job.TargetSince,
+			UserID:        job.TargetUserID,
+			DryRun:        job.DryRun,
+			DownloadCount: job.DownloadCount,
+			DownloadSize:  job.DownloadSize,
+			Added:         job.Added,
+		})
+	}
+	historyJobsMutex.Unlock()
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		log.Println(lg("History", "Jobs", color.HiRedString, "Failed to format history jobs into json:\t%s", err))
+		return
+	}
+	if err = os.MkdirAll(pathCache, 0755); err != nil {
+		log.Println(lg("History", "Jobs", color.HiRedString, "Error while creating cache folder \"%s\": %s", pathCache, err))
+		return
+	}
+	tmpPath := pathCacheHistoryJobs + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0644); err == nil {
+		err = os.Rename(tmpPath, pathCacheHistoryJobs)
+	}
+	if err != nil {
+		log.Println(lg("History", "Jobs", color.HiRedString, "Failed to save history jobs:\t%s", err))
+		historyJobsMutex.Lock()
+		historyJobsDirty = true // try again next time
+		historyJobsMutex.Unlock()
+	}
+}
+
+// Requeues jobs that were waiting or running when the bot last stopped.
+func restoreHistoryJobs() {
+	data, err := os.ReadFile(pathCacheHistoryJobs)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(lg("History", "Jobs", color.HiRedString, "Failed to read history jobs:\t%s", err))
+		}
+		return
+	}
+	var records []historyJobRecord
+	if err = json.Unmarshal(data, &records); err != nil {
+		log.Println(lg("History", "Jobs", color.HiRedString, "Failed to unmarshal json for history jobs:\t%s", err))
+		return
+	}
+	restored := 0
+	for _, record := range records {
+		if record.ChannelID == "" || (record.Status != historyStatusWaiting && record.Status != historyStatusRunning) {
+			continue
+		}
+		if _, exists := getHistoryJob(record.ChannelID); exists { // queued again since startup
+			continue
+		}
+		if _, err := bot.State.Channel(record.ChannelID); err != nil {
+			if _, err = bot.Channel(record.ChannelID); err != nil {
+				log.Println(lg("History", "Jobs", color.HiRedString,
+					"Dropped history job for %s, channel not found:\t%s", record.ChannelID, err))
+				continue
+			}
+		}
+		setHistoryJob(record.ChannelID, historyJob{
+			Status:          historyStatusWaiting, // running jobs start over from the history cache
+			OriginUser:      record.OriginUser,
+			OriginChannel:   record.OriginChannel,
+			TargetChannelID: record.ChannelID,
+			TargetBefore:    record.Before,
+			TargetSince:     record.Since,
+			TargetUserID:    record.UserID,
+			DryRun:          record.DryRun,
+			DownloadCount:   record.DownloadCount,
+			DownloadSize:    record.DownloadSize,
+			Updated:         time.Now(),
+			Added:           record.Added,
+		})
+		restored++
+	}
+	if restored > 0 {
+		log.Println(lg("History", "Jobs", color.HiCyanString,
+			"Restored %d history job%s from the last run", restored, pluralS(restored)))
+	}
+}
+
+//#endregion
+
 // TODO: cleanup
 type historyCache struct {
 	Updated        time.Time
 	Running        bool
-	RunningBefore  string // messageID for last before range attempted if interrupted
-	CompletedSince string // messageID for last message the bot has 100% assumed completion on (since start of channel)
+	RunningBefore  string           // messageID for last before range attempted if interrupted, from before segments
+	CompletedSince string           // messageID for last message the bot has 100% assumed completion on (since start of channel)
+	Segments       []historySegment `json:",omitempty"` // where each segment got to if interrupted
 }
 
 func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string, before string, since string) int {
@@ -102,10 +323,17 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 	logPrefix := fmt.Sprintf("%s/%s: ", subjectChannelID, commander)
 
 	// Skip Requested
-	if job, exists := historyJobs.Get(subjectChannelID); exists && job.Status != historyStatusWaiting {
+	if job, exists := getHistoryJob(subjectChannelID); exists && job.Status != historyStatusWaiting {
 		log.Println(lg("History", "", color.RedString, logPrefix+"History job skipped, Status: %s", historyStatusLabel(job.Status)))
 		return -1
 	}
+	defer clearHistorySegments(subjectChannelID)
+
+	// Dry runs cover the whole range and leave the cache alone
+	dryRun := config.DryRun
+	if job, exists := getHistoryJob(subjectChannelID); exists && job.DryRun {
+		dryRun = true
+	}
 
 	// Vars
 	baseChannelInfo, err := bot.State.Channel(subjectChannelID)
@@ -120,10 +348,29 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 	var totalDownloads int64 = 0
 	var totalFilesize int64 = 0
 	var messageRequestCount int = 0
+	var totalsMutex sync.Mutex // segments process concurrently
+
+	// Restored jobs carry on counting from the last run
+	var jobDownloadCount, jobDownloadSize int64
+	if job, exists := getHistoryJob(subjectChannelID); exists {
+		jobDownloadCount, jobDownloadSize = job.DownloadCount, job.DownloadSize
+	}
+	updateJobCounts := func() {
+		totalsMutex.Lock()
+		downloadCount, downloadSize := jobDownloadCount+totalDownloads, jobDownloadSize+totalFilesize
+		totalsMutex.Unlock()
+		updateHistoryJob(subjectChannelID, func(job *historyJob) {
+			job.DownloadCount, job.DownloadSize = downloadCount, downloadSize
+			job.Updated = time.Now()
+		})
+	}
 
 	sourceMessage := discordgo.Message{} // dummy message
 	sourceMessage.ChannelID = subjectChannelID
 	sourceMessage.GuildID = baseChannelInfo.GuildID
+	if job, exists := getHistoryJob(subjectChannelID); exists && job.TargetUserID != "" {
+		sourceMessage.Author = &discordgo.User{ID: job.TargetUserID}
+	}
 	sourceConfig := getSource(&sourceMessage)
 
 	var responseMsg *discordgo.Message = nil
@@ -133,12 +380,10 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 
 	subjectChannels := []discordgo.Channel{}
 
-	// Check channel type
+	// Check channel type, voice channels have their own text chat
 	baseChannelIsForum := true
 	if baseChannelInfo.Type != discordgo.ChannelTypeGuildCategory &&
 		baseChannelInfo.Type != discordgo.ChannelTypeGuildForum &&
-		baseChannelInfo.Type != discordgo.ChannelTypeGuildStageVoice &&
-		baseChannelInfo.Type != discordgo.ChannelTypeGuildVoice &&
 		baseChannelInfo.Type != discordgo.ChannelTypeGuildStore {
 		subjectChannels = append(subjectChannels, *baseChannelInfo)
 		baseChannelIsForum = false
@@ -191,24 +436,25 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 
 	// Check Read History perms
 	if !baseChannelIsForum && !hasPerms(subjectChannelID, discordgo.PermissionReadMessageHistory) {
-		if job, exists := historyJobs.Get(subjectChannelID); exists {
+		updateHistoryJob(subjectChannelID, func(job *historyJob) {
 			job.Status = historyStatusRunning
 			job.Updated = time.Now()
-			historyJobs.Set(subjectChannelID, job)
-		}
+		})
 		log.Println(lg("History", "", color.HiRedString, logPrefix+"BOT DOES NOT HAVE PERMISSION TO READ MESSAGE HISTORY!!!"))
 	}
 
 	// Update Job Status to Downloading
-	if job, exists := historyJobs.Get(subjectChannelID); exists {
+	updateHistoryJob(subjectChannelID, func(job *historyJob) {
 		job.Status = historyStatusRunning
 		job.Updated = time.Now()
-		historyJobs.Set(subjectChannelID, job)
-	}
+	})
 
 	//#region Cache Files
 
 	openHistoryCache := func(channel string) historyCache {
+		if dryRun {
+			return historyCache{}
+		}
 		if f, err := os.ReadFile(pathCacheHistory + string(os.PathSeparator) + channel + ".json"); err == nil {
 			var ret historyCache
 			if err = json.Unmarshal(f, &ret); err != nil {
@@ -222,6 +468,9 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 	}
 
 	writeHistoryCache := func(channel string, cache historyCache) {
+		if dryRun {
+			return
+		}
 		cacheJson, err := json.Marshal(cache)
 		if err != nil {
 			log.Println(lg("Debug", "History", color.RedString,
@@ -250,6 +499,9 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 	}
 
 	deleteHistoryCache := func(channel string) {
+		if dryRun {
+			return
+		}
 		fp := pathCacheHistory + string(os.PathSeparator) + channel + ".json"
 		if _, err := os.Stat(fp); err == nil {
 			err = os.Remove(fp)
@@ -271,11 +523,10 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 		if sourceConfig == emptySourceConfig {
 			log.Println(lg("History", "", color.HiRedString,
 				logPrefix+"Invalid source: "+channel.ID))
-			if job, exists := historyJobs.Get(subjectChannelID); exists {
+			updateHistoryJob(subjectChannelID, func(job *historyJob) {
 				job.Status = historyStatusErrorRequesting
 				job.Updated = time.Now()
-				historyJobs.Set(subjectChannelID, job)
-			}
+			})
 			continue
 		} else { // Process
 			logHistoryStatus := true
@@ -302,34 +553,13 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 
 			// Date Range Vars
 			rangeContent := ""
-			var beforeTime time.Time
-			var beforeID = before
-			var sinceID = ""
-
-			// Handle Cache File
-			if cache := openHistoryCache(channel.ID); cache != (historyCache{}) {
-				if cache.CompletedSince != "" {
-					if config.Debug {
-						log.Println(lg("Debug", "History", color.GreenString,
-							logPrefix+"Assuming history is completed prior to "+cache.CompletedSince))
-					}
-					since = cache.CompletedSince
-				}
-				if cache.Running {
-					if config.Debug {
-						log.Println(lg("Debug", "History", color.YellowString,
-							logPrefix+"Job was interrupted last run, picking up from "+beforeID))
-					}
-					beforeID = cache.RunningBefore
-				}
-			}
 
 			//#region Date Range Output
 
 			var beforeRange = before
 			if beforeRange != "" {
 				if isDate(beforeRange) { // user has input YYYY-MM-DD
-					beforeRange = discordTimestampToSnowflake("2006-01-02", beforeID)
+					beforeRange = discordTimestampToSnowflake("2006-01-02", beforeRange)
 				} else { // try to parse duration
 					dur, err := time.ParseDuration(beforeRange)
 					if err == nil {
@@ -358,12 +588,52 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 				since = sinceRange
 			}
 
+			if dryRun {
+				rangeContent += fmt.Sprintf("**Dry Run:** nothing is saved, see `%s`\n", config.DryRunReport)
+			}
 			if rangeContent != "" {
 				rangeContent += "\n"
 			}
 
 			//#endregion
 
+			// Handle Cache File
+			channelSince := since
+			sinceFromCache := false
+			var segments []*historySegment
+			cache := openHistoryCache(channel.ID)
+			if cache.CompletedSince != "" {
+				cached64, _ := strconv.ParseInt(cache.CompletedSince, 10, 64)
+				if since64, _ := strconv.ParseInt(since, 10, 64); cached64 > since64 {
+					if config.Debug {
+						log.Println(lg("Debug", "History", color.GreenString,
+							logPrefix+"Assuming history is completed prior to "+cache.CompletedSince))
+					}
+					channelSince = cache.CompletedSince
+					sinceFromCache = true
+				}
+			}
+			if cache.Running {
+				if len(cache.Segments) > 0 {
+					if config.Debug {
+						log.Println(lg("Debug", "History", color.YellowString,
+							logPrefix+"Job was interrupted last run, picking up %d segments", len(cache.Segments)))
+					}
+					for i := range cache.Segments {
+						segments = append(segments, &cache.Segments[i])
+					}
+				} else if cache.RunningBefore != "" { // cached before segments
+					if config.Debug {
+						log.Println(lg("Debug", "History", color.YellowString,
+							logPrefix+"Job was interrupted last run, picking up from "+cache.RunningBefore))
+					}
+					segments = splitHistorySegments(channel.ID, channelSince, cache.RunningBefore, config.HistorySegments)
+				}
+			}
+			if segments == nil {
+				segments = splitHistorySegments(channel.ID, channelSince, before, config.HistorySegments)
+			}
+
 			channelName := getChannelLabel(channel.ID, &channel)
 			if channel.ParentID != "" {
 				channelName = getChannelLabel(channel.ParentID, nil) + " \"" + getChannelLabel(channel.ID, &channel) + "\""
@@ -391,214 +661,249 @@ func handleHistory(commandingMessage *discordgo.Message, subjectChannelID string
 			}
 			log.Println(lg("History", "", color.HiCyanString, logPrefix+"Began checking history for \"%s\"...", sourceName))
 
-			lastMessageID := ""
-		MessageRequestingLoop:
-			for {
-				// Next 100
-				if beforeTime != (time.Time{}) {
-					messageRequestCount++
-					writeHistoryCache(channel.ID, historyCache{
-						Updated:       time.Now(),
-						Running:       true,
-						RunningBefore: beforeID,
-					})
-
-					// Update Status
-					if logHistoryStatus {
-						log.Println(lg("History", "", color.CyanString,
-							logPrefix+"Requesting more, \t%d downloaded (%s), \t%d processed, \tsearching before %s ago (%s)",
-							totalDownloads, humanize.Bytes(uint64(totalFilesize)), totalMessages, timeSinceShort(beforeTime), beforeTime.String()[:10]))
-					}
-					if sendStatus {
-						var status string
-						if totalDownloads == 0 {
-							status = fmt.Sprintf(
-								"``%s:`` **No files downloaded...**\n"+
-									"_%s messages processed, avg %d msg/s_\n\n"+
-									"%s\n\n"+
-									"%s`(%d)` _Processing more messages, please wait..._\n",
-								timeSinceShort(historyStartTime),
-								formatNumber(totalMessages), int(float64(totalMessages)/time.Since(historyStartTime).Seconds()),
-								msgSourceDisplay, rangeContent, messageRequestCount,
-							)
-						} else {
-							status = fmt.Sprintf(
-								"``%s:`` **%s files downloaded...**\n`%s so far, avg %1.1f MB/s`\n"+
-									"_%s messages processed, avg %d msg/s_\n\n"+
-									"%s\n\n"+
-									"%s`(%d)` _Processing more messages, please wait..._\n",
-								timeSinceShort(historyStartTime), formatNumber(totalDownloads),
-								humanize.Bytes(uint64(totalFilesize)), float64(totalFilesize/humanize.MByte)/historyDownloadDuration.Seconds(),
-								formatNumber(totalMessages), int(float64(totalMessages)/time.Since(historyStartTime).Seconds()),
-								msgSourceDisplay, rangeContent, messageRequestCount,
-							)
-						}
-						if responseMsg == nil {
-							log.Println(lg("History", "", color.RedString,
-								logPrefix+"Tried to edit status message but it doesn't exist, sending new one."))
-							if responseMsg, err = replyEmbed(commandingMessage, "Command — History", status); err != nil { // Failed to Edit Status, Send New Message
-								log.Println(lg("History", "", color.HiRedString,
-									logPrefix+"Failed to send replacement status message:\t%s", err))
-							}
-						} else {
-							if !hasPermsToRespond {
-								log.Println(lg("History", "", color.HiRedString,
-									logPrefix+fmtBotSendPerm+" - %s", responseMsg.ChannelID, status))
-							} else {
-								// Edit Status
-								if selfbot {
-									responseMsg, err = bot.ChannelMessageEdit(responseMsg.ChannelID, responseMsg.ID,
-										fmt.Sprintf("**Command — History**\n\n%s", status))
-								} else {
-									responseMsg, err = bot.ChannelMessageEditComplex(&discordgo.MessageEdit{
-										ID:      responseMsg.ID,
-										Channel: responseMsg.ChannelID,
-										Embed:   buildEmbed(responseMsg.ChannelID, "Command — History", status),
-									})
-								}
-								// Failed to Edit Status
-								if err != nil {
-									log.Println(lg("History", "", color.HiRedString,
-										logPrefix+"Failed to edit status message, sending new one:\t%s", err))
-									if responseMsg, err = replyEmbed(responseMsg, "Command — History", status); err != nil { // Failed to Edit Status, Send New Message
-										log.Println(lg("History", "", color.HiRedString,
-											logPrefix+"Failed to send replacement status message:\t%s", err))
-									}
-								}
-							}
-						}
-					}
+			if len(segments) > 1 && logHistoryStatus {
+				log.Println(lg("History", "", color.CyanString, logPrefix+"Fetching %d segments at once...", len(segments)))
+			}
 
-					// Update presence
-					timeLastUpdated = time.Now()
-					if *sourceConfig.PresenceEnabled {
-						go updateDiscordPresence()
+			newestMessageID := int64(0)
+			stop := func() bool {
+				job, exists := getHistoryJob(subjectChannelID)
+				return exists && (job.Status == historyStatusAbortRequested || job.Status == historyStatusAbortCompleted)
+			}
+			process := func(message *discordgo.Message) int64 {
+				timeStartingDownload := time.Now()
+				downloadedFiles := handleMessage(message, &channel, false, true, dryRun)
+				totalsMutex.Lock()
+				defer totalsMutex.Unlock()
+				if len(downloadedFiles) > 0 {
+					totalDownloads += int64(len(downloadedFiles))
+					for _, file := range downloadedFiles {
+						totalFilesize += file.Filesize
 					}
+					historyDownloadDuration += time.Since(timeStartingDownload)
+				}
+				totalMessages++
+				if message64, _ := strconv.ParseInt(message.ID, 10, 64); message64 > newestMessageID {
+					newestMessageID = message64
 				}
+				return int64(len(downloadedFiles))
+			}
 
-				// Request More Messages
-				msg_rq_cnt := 0
-			request_messages:
-				msg_rq_cnt++
-				if config.HistoryRequestDelay > 0 {
-					if logHistoryStatus {
-						log.Println(lg("History", "", color.YellowString, "Delaying next batch request for %d seconds...", config.HistoryRequestDelay))
+			// Update Status
+			updateStatus := func() {
+				segmentStatus := historySegmentsSnapshot(subjectChannelID)
+				requestCount := messageRequestCount
+				segmentContent := ""
+				for i, segment := range segmentStatus {
+					requestCount += segment.Requests
+					if len(segmentStatus) > 1 {
+						segmentContent += fmt.Sprintf("`Segment %d/%d:` %s messages, %s files, _%s_\n",
+							i+1, len(segmentStatus), formatNumber(segment.Messages), formatNumber(segment.Downloads),
+							historySegmentLabel(segment))
 					}
-					time.Sleep(time.Second * time.Duration(config.HistoryRequestDelay))
 				}
-				if messages, fetcherr := bot.ChannelMessages(channel.ID, config.HistoryRequestCount, beforeID, sinceID, ""); fetcherr != nil {
-					// Error requesting messages
-					if sendStatus {
+				if segmentContent != "" {
+					segmentContent += "\n"
+				}
+				totalsMutex.Lock()
+				downloads, filesize, messages, duration := totalDownloads, totalFilesize, totalMessages, historyDownloadDuration
+				totalsMutex.Unlock()
+
+				if sendStatus {
+					var status string
+					if downloads == 0 {
+						status = fmt.Sprintf(
+							"``%s:`` **No files downloaded...**\n"+
+								"_%s messages processed, avg %d msg/s_\n\n"+
+								"%s\n\n"+
+								"%s%s`(%d)` _Processing more messages, please wait..._\n",
+							timeSinceShort(historyStartTime),
+							formatNumber(messages), int(float64(messages)/time.Since(historyStartTime).Seconds()),
+							msgSourceDisplay, segmentContent, rangeContent, requestCount,
+						)
+					} else {
+						status = fmt.Sprintf(
+							"``%s:`` **%s files downloaded...**\n`%s so far, avg %1.1f MB/s`\n"+
+								"_%s messages processed, avg %d msg/s_\n\n"+
+								"%s\n\n"+
+								"%s%s`(%d)` _Processing more messages, please wait..._\n",
+							timeSinceShort(historyStartTime), formatNumber(downloads),
+							humanize.Bytes(uint64(filesize)), float64(filesize/humanize.MByte)/duration.Seconds(),
+							formatNumber(messages), int(float64(messages)/time.Since(historyStartTime).Seconds()),
+							msgSourceDisplay, segmentContent, rangeContent, requestCount,
+						)
+					}
+					if responseMsg == nil {
+						log.Println(lg("History", "", color.RedString,
+							logPrefix+"Tried to edit status message but it doesn't exist, sending new one."))
+						if responseMsg, err = replyEmbed(commandingMessage, "Command — History", status); err != nil { // Failed to Edit Status, Send New Message
+							log.Println(lg("History", "", color.HiRedString,
+								logPrefix+"Failed to send replacement status message:\t%s", err))
+						}
+					} else {
 						if !hasPermsToRespond {
 							log.Println(lg("History", "", color.HiRedString,
-								logPrefix+fmtBotSendPerm, responseMsg.ChannelID))
+								logPrefix+fmtBotSendPerm+" - %s", responseMsg.ChannelID, status))
 						} else {
-							_, senderr := replyEmbed(responseMsg, "Command — History",
-								fmt.Sprintf("Encountered an error requesting messages for %s: %s", channel.ID, fetcherr.Error()))
-							if senderr != nil {
-								log.Println(lg("History", "", color.HiRedString,
-									logPrefix+"Failed to send error message:\t%s", senderr))
+							// Edit Status
+							if selfbot {
+								responseMsg, err = bot.ChannelMessageEdit(responseMsg.ChannelID, responseMsg.ID,
+									fmt.Sprintf("**Command — History**\n\n%s", status))
+							} else {
+								responseMsg, err = bot.ChannelMessageEditComplex(&discordgo.MessageEdit{
+									ID:      responseMsg.ID,
+									Channel: responseMsg.ChannelID,
+									Embed:   buildEmbed(responseMsg.ChannelID, "Command — History", status),
+								})
 							}
-						}
-					}
-					log.Println(lg("History", "", color.HiRedString, logPrefix+"Error requesting messages:\t%s", fetcherr))
-					if job, exists := historyJobs.Get(subjectChannelID); exists {
-						job.Status = historyStatusErrorRequesting
-						job.Updated = time.Now()
-						historyJobs.Set(subjectChannelID, job)
-					}
-					//TODO: delete cahce or handle it differently?
-					break MessageRequestingLoop
-				} else {
-					// No More Messages
-					if len(messages) <= 0 {
-						if msg_rq_cnt > 3 {
-							if job, exists := historyJobs.Get(subjectChannelID); exists {
-								job.Status = historyStatusCompletedNoMoreMessages
-								job.Updated = time.Now()
-								historyJobs.Set(subjectChannelID, job)
+							// Failed to Edit Status
+							if err != nil {
+								log.Println(lg("History", "", color.HiRedString,
+									logPrefix+"Failed to edit status message, sending new one:\t%s", err))
+								if responseMsg, err = replyEmbed(responseMsg, "Command — History", status); err != nil { // Failed to Edit Status, Send New Message
+									log.Println(lg("History", "", color.HiRedString,
+										logPrefix+"Failed to send replacement status message:\t%s", err))
+								}
 							}
-							writeHistoryCache(channel.ID, historyCache{
-								Updated:        time.Now(),
-								Running:        false,
-								RunningBefore:  "",
-								CompletedSince: lastMessageID,
-							})
-							break MessageRequestingLoop
-						} else { // retry to make sure no more
-							time.Sleep(10 * time.Millisecond)
-							goto request_messages
 						}
 					}
+				}
 
-					// Set New Range, this shouldn't be changed regardless of before/since filters. The bot will always go latest to oldest.
-					beforeID = messages[len(messages)-1].ID
-					beforeTime = messages[len(messages)-1].Timestamp
-					sinceID = ""
-
-					// Process Messages
-					if sourceConfig.HistoryTyping != nil && !autorun {
-						if *sourceConfig.HistoryTyping && hasPermsToRespond {
-							bot.ChannelTyping(commandingMessage.ChannelID)
-						}
+				if sourceConfig.HistoryTyping != nil && !autorun {
+					if *sourceConfig.HistoryTyping && hasPermsToRespond {
+						bot.ChannelTyping(commandingMessage.ChannelID)
 					}
-					for _, message := range messages {
-						// Ordered to Cancel
-						if job, exists := historyJobs.Get(subjectChannelID); exists {
-							if job.Status == historyStatusAbortRequested {
-								job.Status = historyStatusAbortCompleted
-								job.Updated = time.Now()
-								historyJobs.Set(subjectChannelID, job)
-								deleteHistoryCache(channel.ID) //TODO: Replace with different variation of writing cache?
-								break MessageRequestingLoop
-							}
-						}
+				}
 
-						lastMessageID = message.ID
+				// Update presence
+				timeLastUpdated.set(time.Now())
+				if *sourceConfig.PresenceEnabled {
+					go updateDiscordPresence()
+				}
+			}
 
-						// Check Message Range
-						message64, _ := strconv.ParseInt(message.ID, 10, 64)
-						if before != "" {
-							before64, _ := strconv.ParseInt(before, 10, 64)
-							if message64 > before64 { // keep scrolling back in messages
-								continue
-							}
-						}
-						if since != "" {
-							since64, _ := strconv.ParseInt(since, 10, 64)
-							if message64 < since64 { // message too old, kill loop
-								if job, exists := historyJobs.Get(subjectChannelID); exists {
-									job.Status = historyStatusCompletedToSinceFilter
-									job.Updated = time.Now()
-									historyJobs.Set(subjectChannelID, job)
-								}
-								deleteHistoryCache(channel.ID) // unsure of consequences of caching when using filters, so deleting to be safe for now.
-								break MessageRequestingLoop
-							}
-						}
+			runningCache := func() historyCache {
+				return historyCache{
+					Updated:        time.Now(),
+					Running:        true,
+					CompletedSince: cache.CompletedSince,
+					Segments:       historySegmentsSnapshot(subjectChannelID),
+				}
+			}
 
-						// Process Message
-						timeStartingDownload := time.Now()
-						downloadedFiles := handleMessage(message, &channel, false, true)
-						if len(downloadedFiles) > 0 {
-							totalDownloads += int64(len(downloadedFiles))
-							for _, file := range downloadedFiles {
-								totalFilesize += file.Filesize
-							}
-							historyDownloadDuration += time.Since(timeStartingDownload)
+			// Fetch & process, reporting as it goes
+			finished := make(chan struct{})
+			go func() {
+				runHistorySegments(subjectChannelID, channel.ID, segments, stop, process, logHistoryStatus, logPrefix)
+				close(finished)
+			}()
+			statusTicker := time.NewTicker(historyStatusInterval)
+		SegmentWaitLoop:
+			for {
+				select {
+				case <-finished:
+					break SegmentWaitLoop
+				case <-statusTicker.C:
+					writeHistoryCache(channel.ID, runningCache())
+					updateJobCounts()
+					updateStatus()
+				}
+			}
+			statusTicker.Stop()
+
+			segmentError := ""
+			for _, segment := range historySegmentsSnapshot(subjectChannelID) {
+				messageRequestCount += segment.Requests
+				if segment.Error != "" && segmentError == "" {
+					segmentError = segment.Error
+				}
+			}
+			if job, exists := getHistoryJob(subjectChannelID); exists && job.Status == historyStatusAbortRequested {
+				// Ordered to Cancel
+				job.Status = historyStatusAbortCompleted
+				job.Updated = time.Now()
+				setHistoryJob(subjectChannelID, job)
+				deleteHistoryCache(channel.ID) //TODO: Replace with different variation of writing cache?
+			} else if stop() {
+				// Cancelled during an earlier channel
+			} else if segmentError != "" {
+				// Error requesting messages, picks up from the segments next time
+				if sendStatus {
+					if !hasPermsToRespond {
+						log.Println(lg("History", "", color.HiRedString,
+							logPrefix+fmtBotSendPerm, responseMsg.ChannelID))
+					} else {
+						_, senderr := replyEmbed(responseMsg, "Command — History",
+							fmt.Sprintf("Encountered an error requesting messages for %s: %s", channel.ID, segmentError))
+						if senderr != nil {
+							log.Println(lg("History", "", color.HiRedString,
+								logPrefix+"Failed to send error message:\t%s", senderr))
 						}
-						totalMessages++
 					}
 				}
+				updateHistoryJob(subjectChannelID, func(job *historyJob) {
+					job.Status = historyStatusErrorRequesting
+					job.Updated = time.Now()
+				})
+				writeHistoryCache(channel.ID, runningCache())
+			} else if channelSince != "" && !sinceFromCache {
+				// Reached Since Filter
+				updateHistoryJob(subjectChannelID, func(job *historyJob) {
+					job.Status = historyStatusCompletedToSinceFilter
+					job.Updated = time.Now()
+				})
+				if cache.CompletedSince != "" { // keep the checkpoint, catch-up & schedules run from it
+					writeHistoryCache(channel.ID, historyCache{
+						Updated:        time.Now(),
+						Running:        false,
+						CompletedSince: cache.CompletedSince,
+					})
+				} else {
+					deleteHistoryCache(channel.ID) // unsure of consequences of caching when using filters, so deleting to be safe for now.
+				}
+			} else {
+				// No More Messages
+				updateHistoryJob(subjectChannelID, func(job *historyJob) {
+					job.Status = historyStatusCompletedNoMoreMessages
+					job.Updated = time.Now()
+				})
+				completedSince := cache.CompletedSince
+				if cached64, _ := strconv.ParseInt(completedSince, 10, 64); newestMessageID > cached64 {
+					completedSince = fmt.Sprint(newestMessageID)
+				}
+				writeHistoryCache(channel.ID, historyCache{
+					Updated:        time.Now(),
+					Running:        false,
+					CompletedSince: completedSince,
+				})
 			}
 
+			updateJobCounts()
+
 			// Final log
-			log.Println(lg("History", "", color.HiGreenString, logPrefix+"Finished history for \"%s\", %s files, %s total",
-				sourceName, formatNumber(totalDownloads), humanize.Bytes(uint64(totalFilesize))))
+			if dryRun {
+				log.Println(lg("History", "", color.HiGreenString, logPrefix+"Finished dry run for \"%s\", %s files planned, %s total, reported to \"%s\"",
+					sourceName, formatNumber(totalDownloads), humanize.Bytes(uint64(totalFilesize)), config.DryRunReport))
+			} else {
+				log.Println(lg("History", "", color.HiGreenString, logPrefix+"Finished history for \"%s\", %s files, %s total",
+					sourceName, formatNumber(totalDownloads), humanize.Bytes(uint64(totalFilesize))))
+			}
+			// Archive Export
+			if !dryRun && sourceConfig.Archive != nil && *sourceConfig.Archive.ExportAfterHistory {
+				if exportPath, count, err := exportArchive(*sourceConfig.Archive, channel.ID); err != nil {
+					if !os.IsNotExist(err) {
+						log.Println(lg("History", "Archive", color.HiRedString,
+							logPrefix+"Failed to export archive \"%s\":\t%s", exportPath, err))
+					}
+				} else {
+					log.Println(lg("History", "Archive", color.HiGreenString,
+						logPrefix+"Exported %s archived messages to \"%s\"", formatNumber(int64(count)), exportPath))
+				}
+			}
 			// Final status update
 			if sendStatus {
 				jobStatus := "Unknown"
-				if job, exists := historyJobs.Get(subjectChannelID); exists {
+				if job, exists := getHistoryJob(subjectChannelID); exists {
 					jobStatus = historyStatusLabel(job.Status)
 				}
 				var status string
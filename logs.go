@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+//#region Source Logs
+
+// LogMessages & LogLinks write free-form lines from prefix/content/suffix in the text format,
+// jsonl & csv write structured records instead, the line settings don't apply to them.
+
+const (
+	sourceLogFormatText  = "text"
+	sourceLogFormatJSONL = "jsonl"
+	sourceLogFormatCSV   = "csv"
+)
+
+func (cfg configurationSourceLog) format() string {
+	if cfg.Format != nil {
+		switch format := strings.ToLower(strings.TrimSpace(*cfg.Format)); format {
+		case sourceLogFormatJSONL, sourceLogFormatCSV:
+			return format
+		}
+	}
+	return sourceLogFormatText
+}
+
+// Adds the extension of the format when missing, swapping out the .txt of the default filename format.
+func (cfg configurationSourceLog) filename(filename string) string {
+	format := cfg.format()
+	extension := "." + format
+	if format == sourceLogFormatText {
+		extension = ".txt"
+	}
+	if !strings.Contains(filename, ".") {
+		return filename + extension
+	}
+	if format != sourceLogFormatText && strings.EqualFold(filepath.Ext(filename), ".txt") {
+		return strings.TrimSuffix(filename, filepath.Ext(filename)) + extension
+	}
+	return filename
+}
+
+type sourceLogRecord struct {
+	Time        time.Time `json:"time"` // when it was logged
+	MessageTime time.Time `json:"messageTime"`
+	ServerID    string    `json:"serverID,omitempty"`
+	ChannelID   string    `json:"channelID"`
+	MessageID   string    `json:"messageID"`
+	AuthorID    string    `json:"authorID,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	Content     string    `json:"content,omitempty"`
+	Attachments []string  `json:"attachments,omitempty"`
+	Edited      bool      `json:"edited,omitempty"`
+	// Links only
+	Link        string `json:"link,omitempty"`
+	Status      string `json:"status,omitempty"` // downloaded, skipped, ignored, failed
+	StatusLabel string `json:"statusLabel,omitempty"`
+	Path        string `json:"path,omitempty"`
+}
+
+var sourceLogHeader = []string{"time", "messageTime", "serverID", "channelID", "messageID", "authorID", "author",
+	"content", "attachments", "edited", "link", "status", "statusLabel", "path"}
+
+func (record sourceLogRecord) csvRecord() []string {
+	return []string{
+		record.Time.Format(time.RFC3339), record.MessageTime.Format(time.RFC3339),
+		record.ServerID, record.ChannelID, record.MessageID, record.AuthorID, record.Author,
+		record.Content, strings.Join(record.Attachments, " "), strconv.FormatBool(record.Edited),
+		record.Link, record.Status, record.StatusLabel, record.Path,
+	}
+}
+
+func newSourceLogRecord(m *discordgo.Message) sourceLogRecord {
+	record := sourceLogRecord{
+		MessageTime: m.Timestamp,
+		ServerID:    m.GuildID,
+		ChannelID:   m.ChannelID,
+		MessageID:   m.ID,
+		Content:     m.Content,
+	}
+	if record.ServerID == "" { // history messages don't include it
+		if channel, err := bot.State.Channel(m.ChannelID); err == nil {
+			record.ServerID = channel.GuildID
+		}
+	}
+	if m.Author != nil {
+		record.AuthorID = m.Author.ID
+		record.Author = m.Author.Username
+	}
+	if contentFmt, err := m.ContentWithMoreMentionsReplaced(bot); err == nil {
+		record.Content = contentFmt
+	}
+	for _, attachment := range m.Attachments {
+		record.Attachments = append(record.Attachments, attachment.URL)
+	}
+	return record
+}
+
+func newSourceLogLinkRecord(download downloadRequestStruct, status downloadStatusStruct) sourceLogRecord {
+	record := newSourceLogRecord(download.Message)
+	record.Link = download.InputURL
+	record.Status = strings.ToLower(getDownloadStatusShort(status.Status))
+	record.StatusLabel = getDownloadStatus(status.Status)
+	if download.Record != nil {
+		record.Path = download.Record.Destination
+	}
+	return record
+}
+
+// Everything but the time it was logged, so a message or link logged again matches.
+func sourceLogKey(fields []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return string(sum[:16])
+}
+
+func (record sourceLogRecord) key() string {
+	return sourceLogKey(record.csvRecord()[1:])
+}
+
+//#region Duplicate Index
+
+// Keys of everything each log holds are kept in the cache as a sorted file searched in place, new keys are
+// journaled next to it & held in memory until there are enough to merge in. Nothing is forgotten, memory only
+// bounds how many keys wait to be merged & how many logs have their index open. Built from the log the first
+// time, text entries can span lines so their keys can only be told apart from then on.
+
+const (
+	sourceLogKeySize    = 16
+	sourceLogPendingMax = 10000 // keys held before merging them into the sorted file
+	sourceLogIndexLogs  = 32    // logs with their index open at once, the least recently written one is closed
+)
+
+type sourceLogIndexEntry struct {
+	keysPath string   // sorted keys
+	keys     *os.File // nil while there are none
+	count    int64
+	pending  map[string]bool // journaled in keysPath.new
+	used     time.Time
+}
+
+var (
+	sourceLogIndex = make(map[string]*sourceLogIndexEntry) // by log path
+	sourceLogMutex sync.Mutex
+)
+
+func (index *sourceLogIndexEntry) has(key string) bool {
+	if index.pending[key] {
+		return true
+	}
+	if index.keys == nil {
+		return false
+	}
+	record := make([]byte, sourceLogKeySize)
+	low, high := int64(0), index.count
+	for low < high {
+		middle := low + (high-low)/2
+		if _, err := index.keys.ReadAt(record, middle*sourceLogKeySize); err != nil {
+			return false
+		}
+		switch compared := strings.Compare(string(record), key); {
+		case compared == 0:
+			return true
+		case compared < 0:
+			low = middle + 1
+		default:
+			high = middle
+		}
+	}
+	return false
+}
+
+// Keys are only merged away from memory once they're written, a failed merge is tried again on the next add.
+func (index *sourceLogIndexEntry) add(key string) error {
+	index.pending[key] = true
+	if len(index.pending) < sourceLogPendingMax {
+		return nil
+	}
+	return index.merge()
+}
+
+func (index *sourceLogIndexEntry) journal(key string) error {
+	f, err := os.OpenFile(index.keysPath+".new", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(key)
+	return err
+}
+
+// Writes the sorted keys with the pending ones merged in & clears the journal.
+func (index *sourceLogIndexEntry) merge() error {
+	pending := make([]string, 0, len(index.pending))
+	for key := range index.pending {
+		pending = append(pending, key)
+	}
+	sort.Strings(pending)
+
+	tmpPath := index.keysPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(out)
+	var count int64
+	write := func(key string) {
+		writer.WriteString(key)
+		count++
+	}
+	if index.keys != nil {
+		reader := bufio.NewReader(io.NewSectionReader(index.keys, 0, index.count*sourceLogKeySize))
+		record := make([]byte, sourceLogKeySize)
+		for {
+			if _, err = io.ReadFull(reader, record); err != nil {
+				break
+			}
+			key := string(record)
+			for len(pending) > 0 && pending[0] < key {
+				write(pending[0])
+				pending = pending[1:]
+			}
+			if len(pending) > 0 && pending[0] == key {
+				pending = pending[1:]
+			}
+			write(key)
+		}
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	for _, key := range pending {
+		write(key)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if index.keys != nil {
+		index.keys.Close()
+		index.keys = nil
+	}
+	if err = os.Rename(tmpPath, index.keysPath); err != nil {
+		return err
+	}
+	index.pending = make(map[string]bool)
+	os.Remove(index.keysPath + ".new")
+	index.keys, err = os.Open(index.keysPath)
+	if err != nil {
+		index.keys = nil
+		return err
+	}
+	index.count = count
+	return nil
+}
+
+func (index *sourceLogIndexEntry) close() {
+	if index.keys != nil {
+		index.keys.Close()
+		index.keys = nil
+	}
+}
+
+func sourceLogKeysPath(logPath string) string {
+	if absolute, err := filepath.Abs(logPath); err == nil {
+		logPath = absolute
+	}
+	sum := sha256.Sum256([]byte(logPath))
+	return pathCacheLogKeys + string(os.PathSeparator) + hex.EncodeToString(sum[:8]) + ".keys"
+}
+
+// Forgets a log that was removed or never written, along with its keys. Called with sourceLogMutex held.
+func removeSourceLogKeys(logPath string) {
+	if index, exists := sourceLogIndex[logPath]; exists {
+		index.close()
+		delete(sourceLogIndex, logPath)
+	}
+	keysPath := sourceLogKeysPath(logPath)
+	os.Remove(keysPath)
+	os.Remove(keysPath + ".new")
+}
+
+// Index of what's in the log, opened the first time it's needed. Called with sourceLogMutex held.
+func sourceLogKeys(logPath string, format string) *sourceLogIndexEntry {
+	if index, exists := sourceLogIndex[logPath]; exists {
+		index.used = time.Now()
+		return index
+	}
+	if len(sourceLogIndex) >= sourceLogIndexLogs {
+		var oldestPath string
+		for path, index := range sourceLogIndex {
+			if oldestPath == "" || index.used.Before(sourceLogIndex[oldestPath].used) {
+				oldestPath = path
+			}
+		}
+		sourceLogIndex[oldestPath].close() // its keys stay on disk
+		delete(sourceLogIndex, oldestPath)
+	}
+	index := &sourceLogIndexEntry{keysPath: sourceLogKeysPath(logPath), pending: make(map[string]bool), used: time.Now()}
+	sourceLogIndex[logPath] = index
+	if err := os.MkdirAll(pathCacheLogKeys, 0755); err != nil {
+		log.Println(lg("Message", "Logs", color.HiRedString,
+			"Error while creating cache folder \"%s\": %s", pathCacheLogKeys, err))
+	}
+
+	keysInfo, keysErr := os.Stat(index.keysPath)
+	journal, journalErr := os.ReadFile(index.keysPath + ".new")
+	if keysErr == nil || journalErr == nil {
+		if keysErr == nil {
+			if f, err := os.Open(index.keysPath); err == nil {
+				index.keys = f
+				index.count = keysInfo.Size() / sourceLogKeySize
+			}
+		}
+		for i := 0; i+sourceLogKeySize <= len(journal); i += sourceLogKeySize { // a torn last key is left out
+			index.pending[string(journal[i:i+sourceLogKeySize])] = true
+		}
+		return index
+	}
+
+	// First time, read the keys out of the log
+	addKey := func(key string) {
+		if err := index.add(key); err != nil {
+			log.Println(lg("Message", "Logs", color.HiRedString, "Failed to save log keys of \"%s\":\t%s", logPath, err))
+		}
+	}
+	f, err := os.Open(logPath)
+	if err != nil {
+		return index
+	}
+	defer f.Close()
+	switch format {
+	case sourceLogFormatCSV:
+		reader := csv.NewReader(f)
+		reader.FieldsPerRecord = -1
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				if _, isParseErr := err.(*csv.ParseError); isParseErr {
+					continue
+				}
+				break
+			}
+			if len(row) == len(sourceLogHeader) && row[0] != sourceLogHeader[0] {
+				addKey(sourceLogKey(row[1:]))
+			}
+		}
+	default:
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if format == sourceLogFormatJSONL {
+				var record sourceLogRecord
+				if json.Unmarshal([]byte(line), &record) == nil {
+					addKey(record.key())
+				}
+			} else if line != "" { // only single line entries can be told apart
+				addKey(sourceLogKey([]string{line}))
+			}
+		}
+	}
+	if err := index.merge(); err != nil {
+		log.Println(lg("Message", "Logs", color.HiRedString, "Failed to save log keys of \"%s\":\t%s", logPath, err))
+	}
+	return index
+}
+
+//#endregion
+
+// Appends to a LogMessages or LogLinks file in its format. The text line starts with its newline like always.
+func appendSourceLog(logPath string, cfg configurationSourceLog, line string, record sourceLogRecord) error {
+	format := cfg.format()
+	key := sourceLogKey([]string{strings.TrimPrefix(line, "\n")})
+	if format != sourceLogFormatText {
+		key = record.key()
+	}
+
+	sourceLogMutex.Lock()
+	defer sourceLogMutex.Unlock()
+
+	_, statErr := os.Stat(logPath)
+	if os.IsNotExist(statErr) { // removed or never written, whatever we knew of it is gone
+		removeSourceLogKeys(logPath)
+	}
+	var index *sourceLogIndexEntry
+	if cfg.FilterDuplicates != nil && *cfg.FilterDuplicates {
+		index = sourceLogKeys(logPath, format)
+		if index.has(key) {
+			return nil
+		}
+	}
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch format {
+	case sourceLogFormatJSONL:
+		record.Time = time.Now()
+		var data []byte
+		if data, err = json.Marshal(record); err == nil {
+			_, err = f.Write(append(data, '\n'))
+		}
+	case sourceLogFormatCSV:
+		record.Time = time.Now()
+		writer := csv.NewWriter(f)
+		if os.IsNotExist(statErr) {
+			writer.Write(sourceLogHeader)
+		}
+		writer.Write(record.csvRecord())
+		writer.Flush()
+		err = writer.Error()
+	default:
+		_, err = f.WriteString(line)
+	}
+	if err == nil && index != nil {
+		keysErr := index.journal(key)
+		if addErr := index.add(key); keysErr == nil {
+			keysErr = addErr
+		}
+		if keysErr != nil {
+			log.Println(lg("Message", "Logs", color.HiRedString, "Failed to save log keys of \"%s\":\t%s", logPath, keysErr))
+		}
+	}
+	return err
+}
+
+// Logs formats that aren't known when settings load, they're written as text.
+func validateSourceLog(label string, name string, cfg *configurationSourceLog) {
+	if cfg == nil || cfg.Format == nil {
+		return
+	}
+	if format := strings.ToLower(strings.TrimSpace(*cfg.Format)); format != "" && format != cfg.format() {
+		log.Println(lg("Settings", "Logs", color.HiRedString,
+			"%s %s format \"%s\" is unknown, expected text, jsonl or csv, writing text", label, name, *cfg.Format))
+	}
+}
+
+//#endregion
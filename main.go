@@ -9,11 +9,11 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/Davincible/goinsta/v3"
-	"github.com/HouzuoGuo/tiedot/db"
 	"github.com/Necroforger/dgrouter/exrouter"
 	"github.com/bwmarrin/discordgo"
 	"github.com/fatih/color"
@@ -32,11 +32,16 @@ var (
 	ddgUpdateAvailable   bool = false
 	autoHistoryInitiated bool = false
 
+	// Flags
+	flagMigrateDatabase bool = false
+	flagConvertFilters  bool = false
+	flagDryRun          bool = false
+
 	// Downloads
-	timeLastUpdated      time.Time
-	timeLastDownload     time.Time
-	timeLastMessage      time.Time
-	cachedDownloadID     int
+	timeLastUpdated      lockedTime
+	timeLastDownload     lockedTime
+	timeLastMessage      lockedTime
+	cachedDownloadID     atomic.Int64
 	configReloadLastTime time.Time
 
 	// Discord
@@ -47,8 +52,9 @@ var (
 	botReady    bool = false
 
 	// Storage
-	myDB         *db.DB
+	myDB         DownloadStore
 	duploCatalog *duplo.Store
+	duploMutex   sync.Mutex // queried & added to from download workers
 
 	// APIs
 	twitterConnected   bool = false
@@ -90,8 +96,17 @@ func init() {
 
 	historyJobs = orderedmap.New[string, historyJob]()
 
-	if len(os.Args) > 1 {
-		configFileBase = os.Args[1]
+	for _, arg := range os.Args[1:] {
+		switch {
+		case arg == "--migrate-database":
+			flagMigrateDatabase = true
+		case arg == "--convert-filters":
+			flagConvertFilters = true
+		case arg == "--dry-run":
+			flagDryRun = true
+		case !strings.HasPrefix(arg, "--"):
+			configFileBase = arg
+		}
 	}
 	//#endregion
 
@@ -107,6 +122,17 @@ func main() {
 	//#region <<< CRITICAL INIT >>>
 
 	loadConfig()
+	if flagMigrateDatabase {
+		if err := migrateDatabase(); err != nil {
+			log.Println(lg("Database", "Migrate", color.HiRedString, "Migration failed:\t%s", err))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if flagConvertFilters {
+		printFiltersAsRules()
+		os.Exit(0)
+	}
 	openDatabase()
 
 	//#endregion
@@ -121,6 +147,10 @@ func main() {
 	if config.DebugExtra {
 		log.Println(lg("DEBUG2", "", color.YellowString, "EXTRA DEBUGGING OUTPUT ENABLED ... some in-depth troubleshooting data..."))
 	}
+	if config.DryRun {
+		log.Println(lg("DRY RUN", "", color.HiMagentaString, "DRY RUN ENABLED ... nothing will be saved, planned downloads are reported to \"%s\"",
+			config.DryRunReport))
+	}
 
 	mainWg.Wait() // wait because credentials from config
 
@@ -148,21 +178,31 @@ func main() {
 	//#region [Loops] History Job Processing
 	go func() {
 		for {
+			// Jobs from the last run, once logged in
+			if botReady {
+				historyJobsRestore.Do(restoreHistoryJobs)
+			}
+			// Recurring jobs, after the startup ones
+			if autoHistoryInitiated {
+				checkHistorySchedules()
+			}
+
 			newJobCount := 0
+			jobs := historyJobsSnapshot()
 			// Empty Local Cache
 			nhistoryJobCnt,
 				nhistoryJobCntWaiting,
 				nhistoryJobCntRunning,
 				nhistoryJobCntAborted,
 				nhistoryJobCntErrored,
-				nhistoryJobCntCompleted := historyJobs.Len(), 0, 0, 0, 0, 0
+				nhistoryJobCntCompleted := len(jobs), 0, 0, 0, 0, 0
 
 			//MARKER: history jobs launch
 			// do we even bother?
 			if nhistoryJobCnt > 0 {
 				// New Cache
-				for pair := historyJobs.Oldest(); pair != nil; pair = pair.Next() {
-					job := pair.Value
+				for _, entry := range jobs {
+					job := entry.Job
 					if job.Status == historyStatusWaiting {
 						nhistoryJobCntWaiting++
 					} else if job.Status == historyStatusRunning {
@@ -181,12 +221,12 @@ func main() {
 					openSlots := config.HistoryMaxJobs - nhistoryJobCntRunning
 					newJobs := make([]historyJob, openSlots)
 					// Find Jobs
-					for pair := historyJobs.Oldest(); pair != nil; pair = pair.Next() {
+					for _, entry := range jobs {
 						if newJobCount == openSlots {
 							break
 						}
-						if pair.Value.Status == historyStatusWaiting {
-							newJobs = append(newJobs, pair.Value)
+						if entry.Job.Status == historyStatusWaiting {
+							newJobs = append(newJobs, entry.Job)
 							newJobCount++
 						}
 					}
@@ -209,12 +249,14 @@ func main() {
 			historyJobCntErrored = nhistoryJobCntErrored
 			historyJobCntCompleted = nhistoryJobCntCompleted
 
+			saveHistoryJobs()
+
 			// Wait before checking again
 			time.Sleep(time.Duration(config.HistoryManagerRate) * time.Second)
 
 			// Auto Exit
 			if config.AutoHistoryExit && autoHistoryInitiated &&
-				historyJobs.Len() > 0 && newJobCount == 0 && historyJobCntWaiting == 0 && historyJobCntRunning == 0 {
+				len(jobs) > 0 && newJobCount == 0 && historyJobCntWaiting == 0 && historyJobCntRunning == 0 {
 				log.Println(lg("History", "", color.HiCyanString, "Exiting due to auto history completion..."))
 				properExit()
 			}
@@ -267,7 +309,18 @@ func main() {
 
 	//#endregion
 
+	//#region Download Queue
+	downloadQueue.start()
+	//#endregion
+
+	if config.APIEnabled {
+		startAPI()
+	}
+
+	go dbBackfillDownloads()
+
 	//#region Autorun History
+	historyJobsRestore.Do(restoreHistoryJobs) // before anything else is queued
 	type arh struct{ channel, before, since string }
 	var autoHistoryChannels []arh
 	// Compile list of channels to autorun history
@@ -287,7 +340,7 @@ func main() {
 	// Process auto history
 	for _, ah := range autoHistoryChannels {
 		//MARKER: history jobs queued from auto
-		if job, exists := historyJobs.Get(ah.channel); !exists ||
+		if job, exists := getHistoryJob(ah.channel); !exists ||
 			(job.Status != historyStatusRunning && job.Status != historyStatusAbortRequested) {
 			job.Status = historyStatusWaiting
 			job.OriginChannel = "AUTORUN"
@@ -296,13 +349,16 @@ func main() {
 			job.TargetChannelID = ah.channel
 			job.TargetBefore = ah.before
 			job.TargetSince = ah.since
+			job.DownloadCount, job.DownloadSize = 0, 0
 			job.Updated = time.Now()
 			job.Added = time.Now()
-			historyJobs.Set(ah.channel, job)
+			setHistoryJob(ah.channel, job)
 			//TODO: signals for this and typical history cmd??
 		}
 	}
 	autoHistoryInitiated = true
+	// Whatever came in while offline, autoruns above take priority
+	queueCatchUpHistory("startup", true)
 	if len(autoHistoryChannels) > 0 {
 		log.Println(lg("History", "Autorun", color.HiYellowString,
 			"History Autoruns completed (for %d channel%s)",
@@ -316,6 +372,8 @@ func main() {
 	tickerCheckup := time.NewTicker(time.Duration(config.CheckupRate) * time.Minute)
 	tickerPresence := time.NewTicker(time.Duration(config.PresenceRefreshRate) * time.Minute)
 	tickerConnection := time.NewTicker(time.Duration(config.ConnectionCheckRate) * time.Minute)
+	tickerGallery := time.NewTicker(time.Minute)
+	tickerCheckpoints := time.NewTicker(time.Minute)
 	go func() {
 		for {
 			select {
@@ -324,25 +382,22 @@ func main() {
 				if config.Debug {
 					//MARKER: history jobs polled for waiting count in checkup
 					historyJobsWaiting := 0
-					if historyJobs.Len() > 0 {
-						for jobPair := historyJobs.Oldest(); jobPair != nil; jobPair.Next() {
-							job := jobPair.Value
-							if job.Status == historyStatusWaiting {
-								historyJobsWaiting++
-							}
+					for _, entry := range historyJobsSnapshot() {
+						if entry.Job.Status == historyStatusWaiting {
+							historyJobsWaiting++
 						}
 					}
 					str := fmt.Sprintf("... %dms latency,\t\tlast discord heartbeat %s ago,\t\t%s uptime",
 						bot.HeartbeatLatency().Milliseconds(),
 						timeSinceShort(bot.LastHeartbeatSent),
 						timeSinceShort(startTime))
-					if !timeLastMessage.IsZero() {
+					if !timeLastMessage.get().IsZero() {
 						str += fmt.Sprintf(",\tlast message %s ago",
-							timeSinceShort(timeLastMessage))
+							timeSinceShort(timeLastMessage.get()))
 					}
-					if !timeLastDownload.IsZero() {
+					if !timeLastDownload.get().IsZero() {
 						str += fmt.Sprintf(",\tlast download %s ago",
-							timeSinceShort(timeLastDownload))
+							timeSinceShort(timeLastDownload.get()))
 					}
 					if historyJobsWaiting > 0 {
 						str += fmt.Sprintf(",\t%d history jobs waiting", historyJobsWaiting)
@@ -354,6 +409,12 @@ func main() {
 				// If bot experiences connection interruption the status will go blank until updated by message, this fixes that
 				go updateDiscordPresence()
 
+			case <-tickerGallery.C:
+				go buildDueGalleries()
+
+			case <-tickerCheckpoints.C:
+				saveCheckpoints()
+
 			case <-tickerConnection.C:
 				if config.ConnectionCheck {
 					doReconnect := func() {
@@ -370,6 +431,7 @@ func main() {
 							botLoad()
 							log.Println(lg("Discord", "", color.HiGreenString,
 								"Reconnected! The bot *should* resume working..."))
+							queueCatchUpHistory("reconnecting", false)
 							// Log Status
 							sendStatusMessage(sendStatusReconnect)
 						}
@@ -548,6 +610,10 @@ func main() {
 
 	sendStatusMessage(sendStatusExit) // not goroutine because we want to wait to send this before logout
 
+	saveCheckpoints()
+	saveHistoryJobs()
+	logPendingPostDownloadCommands()
+
 	// Log out of twitter if authenticated.
 	if twitterScraper != nil {
 		if twitterScraper.IsLoggedIn() {
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+)
+
+//#region Media Probing
+
+// Only the headers are read, images through the image package, MP4/MOV & WebM/MKV containers by hand.
+
+type mediaInfo struct {
+	Width    int
+	Height   int
+	Duration time.Duration // 0 when unknown or not a timed format
+}
+
+var errMediaUnknown = errors.New("unrecognized media format")
+
+func probeMediaFile(path string) (mediaInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return mediaInfo{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return mediaInfo{}, err
+	}
+
+	magic := make([]byte, 16)
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+	switch {
+	case len(magic) >= 12 && bytes.Equal(magic[0:4], []byte("RIFF")) && bytes.Equal(magic[8:12], []byte("WEBP")):
+		return probeWebP(f)
+	case len(magic) >= 8 && isMP4BoxType(magic[4:8]):
+		return probeMP4(f, info.Size())
+	case len(magic) >= 4 && bytes.Equal(magic[0:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return probeEBML(f, info.Size())
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return mediaInfo{}, err
+	}
+	imageConfig, _, err := image.DecodeConfig(bufio.NewReader(f))
+	if err != nil {
+		return mediaInfo{}, errMediaUnknown
+	}
+	return mediaInfo{Width: imageConfig.Width, Height: imageConfig.Height}, nil
+}
+
+//#region WebP
+
+func probeWebP(r io.ReaderAt) (mediaInfo, error) {
+	header := make([]byte, 30)
+	n, err := r.ReadAt(header, 12)
+	if err != nil && err != io.EOF {
+		return mediaInfo{}, err
+	}
+	if n < 18 { // the dimensions of each kind are within these
+		return mediaInfo{}, errMediaUnknown
+	}
+	le := binary.LittleEndian
+	switch string(header[0:4]) {
+	case "VP8X": // extended, canvas size
+		return mediaInfo{
+			Width:  int(uint32(header[12])|uint32(header[13])<<8|uint32(header[14])<<16) + 1,
+			Height: int(uint32(header[15])|uint32(header[16])<<8|uint32(header[17])<<16) + 1,
+		}, nil
+	case "VP8L": // lossless, 14 bit dimensions after the signature byte
+		bits := le.Uint32(header[9:13])
+		return mediaInfo{Width: int(bits&0x3FFF) + 1, Height: int(bits>>14&0x3FFF) + 1}, nil
+	case "VP8 ": // lossy, dimensions follow the key frame start code
+		if header[11] != 0x9D || header[12] != 0x01 || header[13] != 0x2A {
+			return mediaInfo{}, errMediaUnknown
+		}
+		return mediaInfo{Width: int(le.Uint16(header[14:16]) & 0x3FFF), Height: int(le.Uint16(header[16:18]) & 0x3FFF)}, nil
+	}
+	return mediaInfo{}, errMediaUnknown
+}
+
+//#endregion
+
+//#region MP4
+
+func isMP4BoxType(boxType []byte) bool {
+	switch string(boxType) {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide", "pnot":
+		return true
+	}
+	return false
+}
+
+type mp4Box struct {
+	kind   string
+	offset int64 // of the payload
+	size   int64 // of the payload
+}
+
+// Boxes directly within [start, end), the moov box is often written at the end of the file.
+func mp4Boxes(r io.ReaderAt, start int64, end int64) []mp4Box {
+	var boxes []mp4Box
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0: // to the end
+			size = end - offset
+		case 1: // 64 bit size follows
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return boxes
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			break
+		}
+		boxes = append(boxes, mp4Box{string(header[4:8]), offset + headerSize, size - headerSize})
+		offset += size
+	}
+	return boxes
+}
+
+func mp4Find(boxes []mp4Box, kind string) (mp4Box, bool) {
+	for _, box := range boxes {
+		if box.kind == kind {
+			return box, true
+		}
+	}
+	return mp4Box{}, false
+}
+
+func probeMP4(r io.ReaderAt, size int64) (mediaInfo, error) {
+	var info mediaInfo
+	moov, found := mp4Find(mp4Boxes(r, 0, size), "moov")
+	if !found {
+		return info, errMediaUnknown
+	}
+	children := mp4Boxes(r, moov.offset, moov.offset+moov.size)
+
+	// Movie header
+	if mvhd, found := mp4Find(children, "mvhd"); found && mvhd.size >= 20 { // version 0 is the shorter one
+		payload := make([]byte, min(mvhd.size, 32))
+		if _, err := r.ReadAt(payload, mvhd.offset); err == nil {
+			var timescale, duration uint64
+			if payload[0] == 1 {
+				if len(payload) >= 32 {
+					timescale = uint64(binary.BigEndian.Uint32(payload[20:24]))
+					duration = binary.BigEndian.Uint64(payload[24:32])
+				}
+			} else {
+				timescale = uint64(binary.BigEndian.Uint32(payload[12:16]))
+				duration = uint64(binary.BigEndian.Uint32(payload[16:20]))
+			}
+			if timescale > 0 && duration != math.MaxUint32 && duration != math.MaxUint64 {
+				info.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+			}
+		}
+	}
+
+	// Track headers, the largest visual track wins
+	for _, trak := range children {
+		if trak.kind != "trak" {
+			continue
+		}
+		tkhd, found := mp4Find(mp4Boxes(r, trak.offset, trak.offset+trak.size), "tkhd")
+		if !found || tkhd.size < 84 {
+			continue
+		}
+		dimensions := make([]byte, 8) // 16.16 fixed point, at the very end
+		if _, err := r.ReadAt(dimensions, tkhd.offset+tkhd.size-8); err != nil {
+			continue
+		}
+		width := int(binary.BigEndian.Uint32(dimensions[0:4]) >> 16)
+		height := int(binary.BigEndian.Uint32(dimensions[4:8]) >> 16)
+		if width*height > info.Width*info.Height {
+			info.Width, info.Height = width, height
+		}
+	}
+	return info, nil
+}
+
+//#endregion
+
+//#region WebM / Matroska
+
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654AE6B
+	ebmlTrackEntry    = 0xAE
+	ebmlVideo         = 0xE0
+	ebmlPixelWidth    = 0xB0
+	ebmlPixelHeight   = 0xBA
+	ebmlCluster       = 0x1F43B675
+)
+
+type ebmlElement struct {
+	id     uint64
+	offset int64 // of the data
+	size   int64 // -1 when unknown
+}
+
+// Reads a variable length integer, keeping the length marker for IDs.
+func ebmlVarint(r io.ReaderAt, offset int64, keepMarker bool) (value uint64, length int, unknown bool, err error) {
+	first := make([]byte, 1)
+	if _, err = r.ReadAt(first, offset); err != nil {
+		return
+	}
+	for length = 1; length <= 8; length++ {
+		if first[0]&(0x80>>(length-1)) != 0 {
+			break
+		}
+	}
+	if length > 8 {
+		return 0, 0, false, errMediaUnknown
+	}
+	data := make([]byte, length)
+	if _, err = r.ReadAt(data, offset); err != nil {
+		return
+	}
+	if !keepMarker {
+		data[0] &= byte(0xFF >> length)
+	}
+	allOnes := true
+	for i, b := range data {
+		value = value<<8 | uint64(b)
+		if (i == 0 && b != byte(0xFF>>length)) || (i > 0 && b != 0xFF) {
+			allOnes = false
+		}
+	}
+	return value, length, !keepMarker && allOnes, nil
+}
+
+func ebmlElements(r io.ReaderAt, start int64, end int64, stop func(ebmlElement) bool) {
+	for offset := start; offset < end; {
+		id, idLength, _, err := ebmlVarint(r, offset, true)
+		if err != nil {
+			return
+		}
+		size, sizeLength, unknown, err := ebmlVarint(r, offset+int64(idLength), false)
+		if err != nil {
+			return
+		}
+		element := ebmlElement{id: id, offset: offset + int64(idLength+sizeLength), size: int64(size)}
+		if unknown {
+			element.size = -1
+		}
+		if stop(element) || element.size < 0 {
+			return
+		}
+		offset = element.offset + element.size
+	}
+}
+
+func ebmlUint(r io.ReaderAt, element ebmlElement) uint64 {
+	if element.size <= 0 || element.size > 8 {
+		return 0
+	}
+	data := make([]byte, element.size)
+	if _, err := r.ReadAt(data, element.offset); err != nil {
+		return 0
+	}
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func ebmlFloat(r io.ReaderAt, element ebmlElement) float64 {
+	switch element.size {
+	case 4:
+		return float64(math.Float32frombits(uint32(ebmlUint(r, element))))
+	case 8:
+		return math.Float64frombits(ebmlUint(r, element))
+	}
+	return 0
+}
+
+func probeEBML(r io.ReaderAt, size int64) (mediaInfo, error) {
+	var info mediaInfo
+	var segment *ebmlElement
+	ebmlElements(r, 0, size, func(element ebmlElement) bool {
+		if element.id == ebmlSegment {
+			segment = &element
+			return true
+		}
+		return false
+	})
+	if segment == nil {
+		return info, errMediaUnknown
+	}
+	segmentEnd := size
+	if segment.size >= 0 {
+		segmentEnd = min(segment.offset+segment.size, size)
+	}
+
+	timecodeScale := uint64(1000000)
+	var duration float64
+	seenInfo, seenTracks := false, false
+	ebmlElements(r, segment.offset, segmentEnd, func(element ebmlElement) bool {
+		switch element.id {
+		case ebmlInfo:
+			seenInfo = true
+			ebmlElements(r, element.offset, element.offset+element.size, func(child ebmlElement) bool {
+				switch child.id {
+				case ebmlTimecodeScale:
+					timecodeScale = ebmlUint(r, child)
+				case ebmlDuration:
+					duration = ebmlFloat(r, child)
+				}
+				return false
+			})
+		case ebmlTracks:
+			seenTracks = true
+			ebmlElements(r, element.offset, element.offset+element.size, func(entry ebmlElement) bool {
+				if entry.id != ebmlTrackEntry {
+					return false
+				}
+				ebmlElements(r, entry.offset, entry.offset+entry.size, func(video ebmlElement) bool {
+					if video.id != ebmlVideo {
+						return false
+					}
+					var width, height int
+					ebmlElements(r, video.offset, video.offset+video.size, func(pixel ebmlElement) bool {
+						switch pixel.id {
+						case ebmlPixelWidth:
+							width = int(ebmlUint(r, pixel))
+						case ebmlPixelHeight:
+							height = int(ebmlUint(r, pixel))
+						}
+						return false
+					})
+					if width*height > info.Width*info.Height {
+						info.Width, info.Height = width, height
+					}
+					return false
+				})
+				return false
+			})
+		case ebmlCluster: // media data from here on
+			return true
+		}
+		return seenInfo && seenTracks
+	})
+	if !seenInfo && !seenTracks {
+		return info, errMediaUnknown
+	}
+	if duration > 0 {
+		info.Duration = time.Duration(duration * float64(timecodeScale))
+	}
+	return info, nil
+}
+
+//#endregion
+
+//#region Limits
+
+// Why the size breaks the source's limits, empty when it doesn't or isn't known.
+func filterSizeViolation(filters *configurationSourceFilters, size int64) string {
+	if filters == nil || size < 0 {
+		return ""
+	}
+	if filters.MinSize != nil && size < *filters.MinSize {
+		return fmt.Sprintf("%s, under %s", humanize.Bytes(uint64(size)), humanize.Bytes(uint64(*filters.MinSize)))
+	}
+	if filters.MaxSize != nil && *filters.MaxSize > 0 && size > *filters.MaxSize {
+		return fmt.Sprintf("%s, over %s", humanize.Bytes(uint64(size)), humanize.Bytes(uint64(*filters.MaxSize)))
+	}
+	return ""
+}
+
+// Which limit the file breaks and why, empty when it doesn't or the headers couldn't be read.
+func filterMediaViolation(filters *configurationSourceFilters, path string, contentTypeBase string) (downloadStatus, string) {
+	if filters == nil {
+		return downloadSuccess, ""
+	}
+	visual := contentTypeBase == "image" || contentTypeBase == "video"
+	timed := contentTypeBase == "video" || contentTypeBase == "audio"
+	checkDimensions := visual && (filters.MinWidth != nil || filters.MinHeight != nil)
+	checkDuration := timed && filters.MaxDuration != nil && *filters.MaxDuration > 0
+	if !checkDimensions && !checkDuration {
+		return downloadSuccess, ""
+	}
+	info, err := probeMediaFile(path)
+	if err != nil {
+		if config.Debug {
+			log.Println(lg("Debug", "Media", color.YellowString,
+				"Couldn't read media headers of \"%s\", limits not applied:\t%s", path, err))
+		}
+		return downloadSuccess, ""
+	}
+	if checkDimensions && info.Width > 0 && info.Height > 0 {
+		if (filters.MinWidth != nil && info.Width < *filters.MinWidth) ||
+			(filters.MinHeight != nil && info.Height < *filters.MinHeight) {
+			minWidth, minHeight := 0, 0
+			if filters.MinWidth != nil {
+				minWidth = *filters.MinWidth
+			}
+			if filters.MinHeight != nil {
+				minHeight = *filters.MinHeight
+			}
+			return downloadSkippedUnpermittedDimensions,
+				fmt.Sprintf("%dx%d, under %dx%d", info.Width, info.Height, minWidth, minHeight)
+		}
+	}
+	if checkDuration && info.Duration > time.Duration(*filters.MaxDuration)*time.Second {
+		return downloadSkippedUnpermittedDuration,
+			fmt.Sprintf("%s long, over %ds", info.Duration.Round(time.Second), *filters.MaxDuration)
+	}
+	return downloadSuccess, ""
+}
+
+//#endregion
+
+//#endregion
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testMP4Box(kind string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(box, kind...), body...)
+}
+
+func testMP4(width, height int, timescale, duration uint32) []byte {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:16], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:20], duration)
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:80], uint32(width)<<16)
+	binary.BigEndian.PutUint32(tkhd[80:84], uint32(height)<<16)
+	return append(testMP4Box("ftyp", []byte("isom\x00\x00\x02\x00")),
+		testMP4Box("moov", testMP4Box("mvhd", mvhd), testMP4Box("trak", testMP4Box("tkhd", tkhd)))...)
+}
+
+// Elements with sizes under 127 bytes, the segment's size is unknown like a live recording.
+func testEBMLElement(id []byte, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	return append(append(append([]byte{}, id...), 0x80|byte(len(body))), body...)
+}
+
+func testWebM(width, height int, duration float64) []byte {
+	info := testEBMLElement([]byte{0x15, 0x49, 0xA9, 0x66},
+		testEBMLElement([]byte{0x2A, 0xD7, 0xB1}, []byte{0x0F, 0x42, 0x40}),
+		testEBMLElement([]byte{0x44, 0x89}, binary.BigEndian.AppendUint64(nil, math.Float64bits(duration))))
+	tracks := testEBMLElement([]byte{0x16, 0x54, 0xAE, 0x6B},
+		testEBMLElement([]byte{0xAE},
+			testEBMLElement([]byte{0xE0},
+				testEBMLElement([]byte{0xB0}, binary.BigEndian.AppendUint16(nil, uint16(width))),
+				testEBMLElement([]byte{0xBA}, binary.BigEndian.AppendUint16(nil, uint16(height))))))
+	header := testEBMLElement([]byte{0x1A, 0x45, 0xDF, 0xA3})
+	segment := append([]byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, info...)
+	return append(append(header, segment...), tracks...)
+}
+
+func testWebP(width, height int) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x0A\x00\x00\x00\x00\x00\x00\x00")
+	data = append(data, byte(width-1), byte((width-1)>>8), byte((width-1)>>16))
+	return append(data, byte(height-1), byte((height-1)>>8), byte((height-1)>>16))
+}
+
+func probeMediaBytes(t testing.TB, data []byte) (mediaInfo, error) {
+	path := filepath.Join(t.TempDir(), "media")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return probeMediaFile(path)
+}
+
+func TestProbeMediaFile(t *testing.T) {
+	emptyMvhd := append(testMP4Box("ftyp", []byte("isom")), testMP4Box("moov", testMP4Box("mvhd"))...)
+	shortMvhd := append(testMP4Box("ftyp", []byte("isom")), testMP4Box("moov", testMP4Box("mvhd", []byte{1, 0, 0}))...)
+	truncatedV1 := append(testMP4Box("ftyp", []byte("isom")), testMP4Box("moov", testMP4Box("mvhd", append([]byte{1}, make([]byte, 23)...)))...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    mediaInfo
+		wantErr bool
+	}{
+		{"mp4", testMP4(1920, 1080, 1000, 5500), mediaInfo{1920, 1080, 5500 * time.Millisecond}, false},
+		{"mp4 empty mvhd", emptyMvhd, mediaInfo{}, false},
+		{"mp4 short mvhd", shortMvhd, mediaInfo{}, false},
+		{"mp4 truncated version 1 mvhd", truncatedV1, mediaInfo{}, false},
+		{"mp4 truncated", testMP4(640, 480, 1000, 1000)[:40], mediaInfo{}, true},
+		{"mp4 header only", testMP4Box("ftyp")[:8], mediaInfo{}, true},
+		{"webm", testWebM(640, 360, 2500), mediaInfo{640, 360, 2500 * time.Millisecond}, false},
+		{"webm truncated", testWebM(640, 360, 2500)[:30], mediaInfo{}, false},
+		{"webm header only", testWebM(640, 360, 2500)[:5], mediaInfo{}, true},
+		{"webp", testWebP(300, 200), mediaInfo{Width: 300, Height: 200}, false},
+		{"webp truncated", testWebP(300, 200)[:16], mediaInfo{}, true},
+		{"unknown", []byte("not media at all"), mediaInfo{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := probeMediaBytes(t, test.data)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func FuzzProbeMediaFile(f *testing.F) {
+	for _, seed := range [][]byte{testMP4(1920, 1080, 1000, 5500), testWebM(640, 360, 2500), testWebP(300, 200)} {
+		f.Add(seed)
+		for _, cut := range []int{5, 12, 16, 24, 40} {
+			f.Add(seed[:min(cut, len(seed))])
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		probeMediaBytes(t, data) // mustn't panic
+	})
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+//#region File Metadata
+
+// Discord context of a saved file, in a .json sidecar & embedded as XMP in JPEG & PNG files
+// (PNG also gets plain text chunks), so photo managers can index it.
+// Sources linking duplicate content don't embed, their files are shared by every message linking them,
+// sidecars are per link instead. Embedded files are recorded by the hash after embedding, so other sources
+// never link to a file carrying a different message's context.
+
+func linksDuplicateContent(policy string) bool {
+	return policy == duplicateContentHardlink || policy == duplicateContentSymlink
+}
+
+// Warns about embedding that's switched on but won't happen.
+func validateMetadata(label string, metadata *configurationSourceMetadata, duplicateContent string) {
+	if metadata == nil || !linksDuplicateContent(duplicateContent) {
+		return
+	}
+	if metadata.Embed == nil || *metadata.Embed {
+		log.Println(lg("Settings", "Metadata", color.HiYellowString,
+			"%s metadata.embed is ignored since duplicateContent is %s, sidecars still carry it", label, duplicateContent))
+	}
+}
+
+type fileMetadata struct {
+	MessageID   string    `json:"messageID"`
+	MessageLink string    `json:"messageLink,omitempty"`
+	MessageTime time.Time `json:"messageTime"`
+	AuthorID    string    `json:"authorID,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	ChannelID   string    `json:"channelID"`
+	ChannelName string    `json:"channelName,omitempty"`
+	ServerID    string    `json:"serverID,omitempty"`
+	ServerName  string    `json:"serverName,omitempty"`
+	URL         string    `json:"url"`
+	Caption     string    `json:"caption,omitempty"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType,omitempty"`
+	Hash        string    `json:"hash,omitempty"`
+	Saved       time.Time `json:"saved"`
+}
+
+func newFileMetadata(download downloadRequestStruct, serverName string, channelName string) fileMetadata {
+	m := download.Message
+	metadata := fileMetadata{
+		MessageID:   m.ID,
+		MessageTime: m.Timestamp,
+		ChannelID:   m.ChannelID,
+		ChannelName: channelName,
+		ServerID:    m.GuildID,
+		ServerName:  serverName,
+		URL:         download.InputURL,
+		Caption:     m.Content,
+		Filename:    download.Filename,
+		Saved:       time.Now(),
+	}
+	if record := download.Record; record != nil {
+		metadata.ContentType = record.ContentType
+		metadata.Hash = record.Hash
+		if metadata.ServerID == "" {
+			metadata.ServerID = record.GuildID
+		}
+	}
+	if m.Author != nil {
+		metadata.AuthorID = m.Author.ID
+		metadata.Author = m.Author.Username
+	}
+	if contentFmt, err := m.ContentWithMoreMentionsReplaced(bot); err == nil {
+		metadata.Caption = contentFmt
+	}
+	if m.ID != "" && m.ChannelID != "" {
+		guild := metadata.ServerID
+		if guild == "" {
+			guild = "@me"
+		}
+		metadata.MessageLink = fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guild, m.ChannelID, m.ID)
+	}
+	return metadata
+}
+
+func writeMetadataSidecar(filePath string, metadata fileMetadata) error {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(metadata); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath+".json", data.Bytes(), 0644)
+}
+
+// Rewrites the file with the metadata embedded, other formats are left alone.
+func embedMetadata(filePath string, contentType string, metadata fileMetadata) error {
+	var embed func(data []byte, metadata fileMetadata) ([]byte, error)
+	switch strings.TrimSpace(strings.Split(contentType, ";")[0]) {
+	case "image/jpeg":
+		embed = embedMetadataJPEG
+	case "image/png":
+		embed = embedMetadataPNG
+	default:
+		return nil
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	if data, err = embed(data, metadata); err != nil {
+		return err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	tmpPath := filePath + ".tmp"
+	if err = os.WriteFile(tmpPath, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// Dublin Core & XMP basics photo managers know, the rest under our own namespace.
+func (metadata fileMetadata) xmp() []byte {
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("    xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"\n")
+	b.WriteString("    xmlns:ddg=\"" + projectRepoURL + "/ns/1.0/\">\n")
+	if metadata.Caption != "" {
+		b.WriteString("   <dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">" +
+			xmlEscape(metadata.Caption) + "</rdf:li></rdf:Alt></dc:description>\n")
+	}
+	if metadata.Author != "" {
+		b.WriteString("   <dc:creator><rdf:Seq><rdf:li>" + xmlEscape(metadata.Author) + "</rdf:li></rdf:Seq></dc:creator>\n")
+	}
+	var subjects []string
+	for _, subject := range []string{metadata.ServerName, metadata.ChannelName} {
+		if subject != "" {
+			subjects = append(subjects, "<rdf:li>"+xmlEscape(subject)+"</rdf:li>")
+		}
+	}
+	if len(subjects) > 0 {
+		b.WriteString("   <dc:subject><rdf:Bag>" + strings.Join(subjects, "") + "</rdf:Bag></dc:subject>\n")
+	}
+	b.WriteString("   <dc:source>" + xmlEscape(metadata.URL) + "</dc:source>\n")
+	if metadata.MessageLink != "" {
+		b.WriteString("   <dc:identifier>" + xmlEscape(metadata.MessageLink) + "</dc:identifier>\n")
+	}
+	if !metadata.MessageTime.IsZero() {
+		b.WriteString("   <xmp:CreateDate>" + metadata.MessageTime.Format(time.RFC3339) + "</xmp:CreateDate>\n")
+	}
+	b.WriteString("   <xmp:CreatorTool>" + xmlEscape(projectLabel+" v"+projectVersion) + "</xmp:CreatorTool>\n")
+	for _, field := range [][2]string{
+		{"MessageID", metadata.MessageID},
+		{"AuthorID", metadata.AuthorID},
+		{"ChannelID", metadata.ChannelID},
+		{"ChannelName", metadata.ChannelName},
+		{"ServerID", metadata.ServerID},
+		{"ServerName", metadata.ServerName},
+	} {
+		if field[1] != "" {
+			b.WriteString("   <ddg:" + field[0] + ">" + xmlEscape(field[1]) + "</ddg:" + field[0] + ">\n")
+		}
+	}
+	b.WriteString("  </rdf:Description>\n </rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"w\"?>")
+	return []byte(b.String())
+}
+
+//#region JPEG
+
+const jpegXMPNamespace = "http://ns.adobe.com/xap/1.0/\x00"
+
+// Our XMP goes in an APP1 segment after the leading APP0/APP1 ones (JFIF, EXIF), replacing any XMP there was.
+func embedMetadataJPEG(data []byte, metadata fileMetadata) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a JPEG")
+	}
+	packet := metadata.xmp()
+	if len(jpegXMPNamespace)+len(packet)+2 > 0xFFFF { // a segment can't hold more, the caption is the only long part
+		metadata.Caption = ""
+		packet = metadata.xmp()
+		if len(jpegXMPNamespace)+len(packet)+2 > 0xFFFF {
+			return nil, errors.New("metadata too large for a JPEG segment")
+		}
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)+len(packet)+64))
+	out.Write(data[:2])
+	offset := 2
+	for offset+4 <= len(data) && data[offset] == 0xFF && (data[offset+1] == 0xE0 || data[offset+1] == 0xE1) {
+		length := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		if !bytes.HasPrefix(data[offset+4:end], []byte(jpegXMPNamespace)) {
+			out.Write(data[offset:end])
+		}
+		offset = end
+	}
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(out, binary.BigEndian, uint16(len(jpegXMPNamespace)+len(packet)+2))
+	out.WriteString(jpegXMPNamespace)
+	out.Write(packet)
+	out.Write(data[offset:])
+	return out.Bytes(), nil
+}
+
+//#endregion
+
+//#region PNG
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+
+func pngChunk(kind string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[:4], uint32(len(data)))
+	copy(chunk[4:8], kind)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+var pngTextKeywords = map[string]bool{"Description": true, "Author": true, "Source": true, "Comment": true}
+
+// Latin-1 only, anything else is left for the XMP.
+func pngTextChunk(keyword string, text string) []byte {
+	latin := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r > 0xFF {
+			return nil
+		}
+		latin = append(latin, byte(r))
+	}
+	return pngChunk("tEXt", append([]byte(keyword+"\x00"), latin...))
+}
+
+// XMP in an uncompressed iTXt chunk & plain tEXt chunks before the image data, replacing any there were.
+func embedMetadataPNG(data []byte, metadata fileMetadata) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG")
+	}
+	var chunks [][]byte
+	chunks = append(chunks, pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), metadata.xmp()...)))
+	for _, text := range [][2]string{
+		{"Description", metadata.Caption},
+		{"Author", metadata.Author},
+		{"Source", metadata.URL},
+		{"Comment", metadata.MessageLink},
+	} {
+		if text[1] != "" {
+			if chunk := pngTextChunk(text[0], text[1]); chunk != nil {
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)+4096))
+	out.Write(pngSignature)
+	offset := len(pngSignature)
+	inserted := false
+	for offset+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		kind := string(data[offset+4 : offset+8])
+		end := offset + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("malformed PNG chunk")
+		}
+		if !inserted && (kind == "IDAT" || kind == "IEND") {
+			for _, chunk := range chunks {
+				out.Write(chunk)
+			}
+			inserted = true
+		}
+		if kind == "iTXt" && bytes.HasPrefix(data[offset+8:end], []byte("XML:com.adobe.xmp\x00")) ||
+			kind == "tEXt" && pngTextKeywords[string(bytes.SplitN(data[offset+8:end], []byte{0}, 2)[0])] {
+			offset = end
+			continue
+		}
+		out.Write(data[offset:end])
+		offset = end
+	}
+	if !inserted {
+		return nil, errors.New("PNG has no image data")
+	}
+	return out.Bytes(), nil
+}
+
+//#endregion
+
+//#endregion
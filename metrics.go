@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//#region Prometheus Metrics
+
+// Written out by hand in the text exposition format, nothing here needs the full client library.
+
+var metricsDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type metricsHistogram struct {
+	buckets []uint64 // cumulative counts are built when written
+	count   uint64
+	sum     float64
+}
+
+type metricsStruct struct {
+	mutex     sync.Mutex
+	downloads map[[4]string]uint64 // result, status, domain, source
+	bytes     map[[2]string]uint64 // domain, source
+	attempts  map[string]*metricsHistogram
+	retries   uint64
+}
+
+var metrics = &metricsStruct{
+	downloads: make(map[[4]string]uint64),
+	bytes:     make(map[[2]string]uint64),
+	attempts:  make(map[string]*metricsHistogram),
+}
+
+func metricsSourceLabel(download downloadRequestStruct) string {
+	if download.EmojiCmd {
+		return "emojis"
+	}
+	if download.Message == nil {
+		return "none"
+	}
+	return sourceLabel(getSource(download.Message))
+}
+
+func (m *metricsStruct) observeAttempt(status downloadStatus, took time.Duration) {
+	result := strings.ToLower(getDownloadStatusShort(status))
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	histogram, exists := m.attempts[result]
+	if !exists {
+		histogram = &metricsHistogram{buckets: make([]uint64, len(metricsDurationBuckets))}
+		m.attempts[result] = histogram
+	}
+	seconds := took.Seconds()
+	for i, bound := range metricsDurationBuckets {
+		if seconds <= bound {
+			histogram.buckets[i]++
+			break
+		}
+	}
+	histogram.count++
+	histogram.sum += seconds
+}
+
+func (m *metricsStruct) observeRetry() {
+	m.mutex.Lock()
+	m.retries++
+	m.mutex.Unlock()
+}
+
+func (m *metricsStruct) observeDownload(download downloadRequestStruct, status downloadStatus, filesize int64) {
+	domain := ""
+	if download.Record != nil {
+		domain = strings.ToLower(download.Record.Domain)
+	}
+	source := metricsSourceLabel(download)
+	key := [4]string{strings.ToLower(getDownloadStatusShort(status)), getDownloadStatus(status), domain, source}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.downloads[key]++
+	if status == downloadSuccess && filesize > 0 {
+		m.bytes[[2]string{domain, source}] += uint64(filesize)
+	}
+}
+
+//#region Output
+
+type metricsWriter struct {
+	strings.Builder
+}
+
+func metricsEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func metricsLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, metricsEscape(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (w *metricsWriter) header(name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w *metricsWriter) sample(name string, names []string, values []string, value interface{}) {
+	fmt.Fprintf(w, "%s%s %v\n", name, metricsLabels(names, values), value)
+}
+
+func (w *metricsWriter) gauge(name string, help string, value interface{}) {
+	w.header(name, "gauge", help)
+	w.sample(name, nil, nil, value)
+}
+
+// Folders (tiedot) are summed, SQLite includes its write-ahead log.
+func metricsDatabaseSize() int64 {
+	if myDB == nil {
+		return 0
+	}
+	var size int64
+	for _, path := range []string{myDB.Path(), myDB.Path() + "-wal"} {
+		filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info, err := entry.Info(); err == nil && !entry.IsDir() {
+				size += info.Size()
+			}
+			return nil
+		})
+	}
+	return size
+}
+
+func (m *metricsStruct) write(w *metricsWriter) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	w.header("ddg_downloads_total", "counter", "Finished downloads since startup by outcome, domain and source.")
+	downloadKeys := make([][4]string, 0, len(m.downloads))
+	for key := range m.downloads {
+		downloadKeys = append(downloadKeys, key)
+	}
+	sort.Slice(downloadKeys, func(i, j int) bool {
+		return strings.Join(downloadKeys[i][:], "\x00") < strings.Join(downloadKeys[j][:], "\x00")
+	})
+	for _, key := range downloadKeys {
+		w.sample("ddg_downloads_total", []string{"result", "status", "domain", "source"}, key[:], m.downloads[key])
+	}
+
+	w.header("ddg_download_bytes_total", "counter", "Bytes of downloaded files written since startup.")
+	byteKeys := make([][2]string, 0, len(m.bytes))
+	for key := range m.bytes {
+		byteKeys = append(byteKeys, key)
+	}
+	sort.Slice(byteKeys, func(i, j int) bool {
+		return byteKeys[i][0]+"\x00"+byteKeys[i][1] < byteKeys[j][0]+"\x00"+byteKeys[j][1]
+	})
+	for _, key := range byteKeys {
+		w.sample("ddg_download_bytes_total", []string{"domain", "source"}, key[:], m.bytes[key])
+	}
+
+	w.header("ddg_download_attempt_duration_seconds", "histogram", "Duration of single download attempts by outcome.")
+	results := make([]string, 0, len(m.attempts))
+	for result := range m.attempts {
+		results = append(results, result)
+	}
+	sort.Strings(results)
+	for _, result := range results {
+		histogram := m.attempts[result]
+		var cumulative uint64
+		for i, bound := range metricsDurationBuckets {
+			cumulative += histogram.buckets[i]
+			w.sample("ddg_download_attempt_duration_seconds_bucket", []string{"result", "le"},
+				[]string{result, fmt.Sprint(bound)}, cumulative)
+		}
+		w.sample("ddg_download_attempt_duration_seconds_bucket", []string{"result", "le"},
+			[]string{result, "+Inf"}, histogram.count)
+		w.sample("ddg_download_attempt_duration_seconds_sum", []string{"result"}, []string{result}, histogram.sum)
+		w.sample("ddg_download_attempt_duration_seconds_count", []string{"result"}, []string{result}, histogram.count)
+	}
+
+	w.header("ddg_download_retries_total", "counter", "Download attempts made after a failed first attempt.")
+	w.sample("ddg_download_retries_total", nil, nil, m.retries)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	out := &metricsWriter{}
+	metrics.write(out)
+
+	waiting, running := downloadQueue.counts()
+	out.header("ddg_queue_downloads", "gauge", "Downloads in the queue by state.")
+	out.sample("ddg_queue_downloads", []string{"state"}, []string{"waiting"}, waiting)
+	out.sample("ddg_queue_downloads", []string{"state"}, []string{"running"}, running)
+
+	historyCounts := make(map[historyStatus]int)
+	for _, entry := range historyJobsSnapshot() {
+		historyCounts[entry.Job.Status]++
+	}
+	out.header("ddg_history_jobs", "gauge", "History jobs by status.")
+	for status := historyStatusWaiting; status <= historyStatusCompletedToSinceFilter; status++ {
+		out.sample("ddg_history_jobs", []string{"status"}, []string{historyStatusName(status)}, historyCounts[status])
+	}
+
+	if bot != nil {
+		out.gauge("ddg_gateway_heartbeat_latency_seconds", "Latency of the last Discord gateway heartbeat.",
+			bot.HeartbeatLatency().Seconds())
+		connected := 0
+		if bot.DataReady {
+			connected = 1
+		}
+		out.gauge("ddg_gateway_connected", "Whether the Discord gateway connection is ready.", connected)
+		if bot.State != nil {
+			out.gauge("ddg_servers", "Servers the bot has access to.", len(bot.State.Guilds))
+		}
+	}
+
+	if myDB != nil {
+		out.gauge("ddg_database_size_bytes", "Size of the database on disk.", metricsDatabaseSize())
+		if records, err := myDB.CountRecords(); err == nil {
+			out.gauge("ddg_database_records", "Download records stored in the database, every outcome.", records)
+		}
+	}
+
+	out.gauge("ddg_start_time_seconds", "Unix time the bot was started.", startTime.Unix())
+	if !timeLastDownload.get().IsZero() {
+		out.gauge("ddg_last_download_time_seconds", "Unix time of the last successful download.", timeLastDownload.get().Unix())
+	}
+	out.gauge("ddg_download_id", "Current download counter, database entries at startup plus attempts since.", cachedDownloadID.Load())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(out.String()))
+}
+
+//#endregion
+
+//#endregion
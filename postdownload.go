@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+//#region Post-Download Commands
+
+const (
+	postDownloadOutputMax = 8 * 1024
+	postDownloadQueueMax  = 1000 // waiting to run, a slow command can't pile up goroutines behind a backfill
+)
+
+type postDownloadJob struct {
+	command  []string
+	download downloadRequestStruct
+}
+
+var (
+	postDownloadQueue       = make(chan postDownloadJob, postDownloadQueueMax)
+	postDownloadWorkersOnce sync.Once
+)
+
+// Workers are started once from the settings at the first run, changing the concurrency needs a restart.
+func queuePostDownloadCommand(command []string, download downloadRequestStruct) {
+	postDownloadWorkersOnce.Do(func() {
+		for i := 0; i < config.PostDownloadConcurrency; i++ {
+			go func() {
+				for job := range postDownloadQueue {
+					runPostDownloadCommand(job.command, job.download)
+				}
+			}()
+		}
+	})
+	select {
+	case postDownloadQueue <- postDownloadJob{command, download}:
+	default:
+		log.Println(lg("Download", "PostCommand", color.HiRedString,
+			"Dropped %s for %s, %d commands are already waiting", command[0], download.InputURL, postDownloadQueueMax))
+	}
+}
+
+// Commands still waiting won't run once the program exits.
+func logPendingPostDownloadCommands() {
+	if pending := len(postDownloadQueue); pending > 0 {
+		log.Println(lg("Download", "PostCommand", color.HiRedString,
+			"%d post-download command%s never ran", pending, pluralS(pending)))
+	}
+}
+
+// Keeps the start of the output, a noisy command can't eat memory.
+type postDownloadOutput struct {
+	mutex     sync.Mutex
+	buffer    bytes.Buffer
+	truncated bool
+}
+
+func (o *postDownloadOutput) Write(p []byte) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if room := postDownloadOutputMax - o.buffer.Len(); room < len(p) {
+		o.buffer.Write(p[:max(room, 0)])
+		o.truncated = true
+	} else {
+		o.buffer.Write(p)
+	}
+	return len(p), nil
+}
+
+func (o *postDownloadOutput) String() string {
+	output := strings.TrimSpace(o.buffer.String())
+	if o.truncated {
+		output += "\n[output truncated]"
+	}
+	if output != "" {
+		output = "\n" + output
+	}
+	return output
+}
+
+// Details go in DDG_* environment variables and as JSON on stdin, same shape as webhook downloads.
+func runPostDownloadCommand(command []string, download downloadRequestStruct) {
+	if len(command) == 0 || command[0] == "" {
+		return
+	}
+	data := newWebhookDownload(download, mDownloadStatus(downloadSuccess))
+	if absPath, err := filepath.Abs(data.Path); err == nil {
+		data.Path = absPath
+	}
+	if data.ThumbPath != "" {
+		if absPath, err := filepath.Abs(data.ThumbPath); err == nil {
+			data.ThumbPath = absPath
+		}
+	}
+	input, err := json.Marshal(data)
+	if err != nil {
+		log.Println(lg("Download", "PostCommand", color.HiRedString, "Failed to encode details for %s:\t%s",
+			data.Path, err))
+		return
+	}
+
+	timeout := time.Duration(config.PostDownloadTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"DDG_FILE_PATH="+data.Path,
+		"DDG_FILE_NAME="+data.Filename,
+		"DDG_THUMB_PATH="+data.ThumbPath,
+		fmt.Sprintf("DDG_FILE_SIZE=%d", data.Filesize),
+		"DDG_CONTENT_TYPE="+data.ContentType,
+		"DDG_HASH="+data.Hash,
+		"DDG_URL="+data.URL,
+		"DDG_DOMAIN="+data.Domain,
+		"DDG_SERVER_ID="+data.ServerID,
+		"DDG_CHANNEL_ID="+data.ChannelID,
+		"DDG_MESSAGE_ID="+data.MessageID,
+		"DDG_USER_ID="+data.UserID,
+		fmt.Sprintf("DDG_HISTORY=%t", data.History),
+	)
+	cmd.Stdin = bytes.NewReader(input)
+	output := &postDownloadOutput{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.WaitDelay = 5 * time.Second // children holding the pipes open can't stall us past the timeout
+
+	runStart := time.Now()
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Println(lg("Download", "PostCommand", color.HiRedString, "%s timed out after %s for %s%s",
+			command[0], timeout, data.Path, output))
+	} else if err != nil {
+		log.Println(lg("Download", "PostCommand", color.HiRedString, "%s failed for %s:\t%s%s",
+			command[0], data.Path, err, output))
+	} else if outputString := output.String(); outputString != "" {
+		log.Println(lg("Download", "PostCommand", color.HiCyanString, "%s finished for %s (took %s)%s",
+			command[0], data.Path, timeSinceShort(runStart), outputString))
+	} else if config.Verbose {
+		log.Println(lg("Verbose", "PostCommand", color.HiBlueString, "%s finished for %s (took %s)",
+			command[0], data.Path, timeSinceShort(runStart)))
+	}
+}
+
+//#endregion
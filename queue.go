@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+//#region Download Queue
+
+// Higher runs first.
+type queuePriority int
+
+const (
+	queuePriorityHistory queuePriority = iota
+	queuePriorityLive
+)
+
+type queueResult struct {
+	Status   downloadStatusStruct
+	Filesize int64
+}
+
+type queuedDownload struct {
+	DatabaseID int                   `json:"-"`
+	Request    downloadRequestStruct `json:"request"`
+	Priority   queuePriority         `json:"priority"`
+	Queued     time.Time             `json:"queued"`
+	Domain     string                `json:"domain"`
+
+	result chan queueResult // nil when nothing is waiting on it
+}
+
+type downloadQueueStruct struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	pending []*queuedDownload
+	active  map[string]int // running downloads per domain
+	running int
+	started bool
+}
+
+var downloadQueue = newDownloadQueue()
+
+func newDownloadQueue() *downloadQueueStruct {
+	q := &downloadQueueStruct{
+		active: make(map[string]int),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// Max concurrent downloads for a domain, matching the domain itself or any subdomain of a configured one. 0 is unlimited.
+func queueDomainLimit(domain string) int {
+	limit := 0
+	matched := ""
+	for key, value := range config.DownloadDomainLimits {
+		key = strings.ToLower(key)
+		if (domain == key || strings.HasSuffix(domain, "."+key)) && len(key) > len(matched) {
+			limit = value
+			matched = key
+		}
+	}
+	return limit
+}
+
+// Adds to the queue & database, returns a channel receiving the result if wait is true.
+func (q *downloadQueueStruct) add(request downloadRequestStruct, priority queuePriority, wait bool) <-chan queueResult {
+	item := &queuedDownload{
+		Request:  request,
+		Priority: priority,
+		Queued:   time.Now(),
+	}
+	if parsedURL, err := url.Parse(request.InputURL); err == nil {
+		item.Domain = strings.ToLower(parsedURL.Hostname())
+	}
+	if wait {
+		item.result = make(chan queueResult, 1)
+	}
+
+	if !request.DryRun { // dry runs leave the database alone
+		if itemJSON, err := json.Marshal(item); err != nil {
+			log.Println(lg("Queue", "", color.HiRedString, "Failed to encode queue item for %s:\t%s", request.InputURL, err))
+		} else if id, err := dbInsertQueueItem(string(itemJSON)); err != nil {
+			log.Println(lg("Queue", "", color.HiRedString, "Failed to store queue item for %s:\t%s", request.InputURL, err))
+		} else {
+			item.DatabaseID = id
+		}
+	}
+
+	q.mutex.Lock()
+	q.pending = append(q.pending, item)
+	q.mutex.Unlock()
+	q.cond.Signal()
+
+	return item.result
+}
+
+// Takes the highest priority item whose domain isn't at its limit, oldest first within a priority.
+func (q *downloadQueueStruct) next() *queuedDownload {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for {
+		chosen := -1
+		for i, item := range q.pending {
+			if limit := queueDomainLimit(item.Domain); limit > 0 && q.active[item.Domain] >= limit {
+				continue
+			}
+			if chosen == -1 || item.Priority > q.pending[chosen].Priority {
+				chosen = i
+			}
+		}
+		if chosen != -1 {
+			item := q.pending[chosen]
+			q.pending = append(q.pending[:chosen], q.pending[chosen+1:]...)
+			q.active[item.Domain]++
+			q.running++
+			return item
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *downloadQueueStruct) done(item *queuedDownload) {
+	q.mutex.Lock()
+	q.active[item.Domain]--
+	if q.active[item.Domain] <= 0 {
+		delete(q.active, item.Domain)
+	}
+	q.running--
+	q.mutex.Unlock()
+	q.cond.Broadcast() // a domain slot may have opened for any waiting worker
+}
+
+func (q *downloadQueueStruct) counts() (pending int, running int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.pending), q.running
+}
+
+func (q *downloadQueueStruct) worker() {
+	for {
+		item := q.next()
+		item.Request.StartTime = time.Now()
+		status, filesize := item.Request.handleDownload()
+		if item.DatabaseID != 0 {
+			if err := dbDeleteQueueItem(item.DatabaseID); err != nil {
+				log.Println(lg("Queue", "", color.HiRedString, "Failed to remove finished queue item %d:\t%s", item.DatabaseID, err))
+			}
+		}
+		q.done(item)
+		if item.result != nil {
+			item.result <- queueResult{Status: status, Filesize: filesize}
+		}
+	}
+}
+
+// Restores items left over from the last run, then starts the workers.
+func (q *downloadQueueStruct) start() {
+	q.mutex.Lock()
+	if q.started {
+		q.mutex.Unlock()
+		return
+	}
+	q.started = true
+	q.mutex.Unlock()
+
+	q.mutex.Lock()
+	alreadyPending := make(map[int]bool)
+	for _, item := range q.pending {
+		alreadyPending[item.DatabaseID] = true
+	}
+	q.mutex.Unlock()
+
+	restored := 0
+	for _, item := range dbGetQueueItems() {
+		if alreadyPending[item.DatabaseID] { // added since startup
+			continue
+		}
+		// Drop anything that finished right before the last exit
+		if item.Request.Message != nil && !item.Request.EmojiCmd {
+			if len(pruneCompletedLinks(map[string]string{item.Request.InputURL: item.Request.Filename}, item.Request.Message)) == 0 {
+				dbDeleteQueueItem(item.DatabaseID)
+				continue
+			}
+			if item.Request.Channel == nil {
+				item.Request.Channel, _ = bot.State.Channel(item.Request.Message.ChannelID)
+			}
+		}
+		q.mutex.Lock()
+		q.pending = append(q.pending, item)
+		q.mutex.Unlock()
+		restored++
+	}
+	if restored > 0 {
+		log.Println(lg("Queue", "", color.HiYellowString, "Restored %d queued download%s from last run...",
+			restored, pluralS(restored)))
+	}
+
+	for i := 0; i < config.DownloadWorkers; i++ {
+		go q.worker()
+	}
+	if config.Verbose {
+		log.Println(lg("Verbose", "Queue", color.HiBlueString, "Started %d download worker%s...",
+			config.DownloadWorkers, pluralS(config.DownloadWorkers)))
+	}
+}
+
+//#endregion
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"mime"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+//#region Routes
+
+// Routes are checked in order, the first one matching sends the download to its destination instead of the source's.
+// Every criteria set has to match, a list matches when any entry does. Entries take the same re: & glob: patterns
+// as filter lists, domains take "*.example.com" too.
+
+// Content types are "image", "video/*" or "video/mp4" style, anything else is matched as a filter entry.
+func routeContentTypeMatches(entries []string, contentType string, contentTypeBase string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry, "/*")
+		if !strings.Contains(base, "/") && !strings.Contains(base, ":") {
+			if strings.EqualFold(base, contentTypeBase) {
+				return true
+			}
+		} else if filterEqualsAny(mediaType, []string{entry}) {
+			return true
+		}
+	}
+	return false
+}
+
+func routeMatches(route configurationRoute, message *discordgo.Message, domain string, extension string,
+	contentType string, contentTypeBase string) bool {
+	if route.ContentTypes != nil && !routeContentTypeMatches(*route.ContentTypes, contentType, contentTypeBase) {
+		return false
+	}
+	if route.Extensions != nil && !filterEqualsAny(extension, *route.Extensions) {
+		return false
+	}
+	if route.Domains != nil && !filterDomainAny(domain, *route.Domains) {
+		return false
+	}
+	if route.Users != nil || route.Roles != nil || route.Phrases != nil {
+		if message == nil || message.Author == nil {
+			return false
+		}
+		if route.Users != nil && !filterEqualsAny(message.Author.ID, *route.Users) {
+			return false
+		}
+		if route.Phrases != nil {
+			if _, found := filterContainsAny(message.Content, *route.Phrases); !found {
+				return false
+			}
+		}
+		if route.Roles != nil {
+			matched := false
+			for _, role := range (&ruleContext{Message: message}).memberRoles() {
+				if filterEqualsAny(role, *route.Roles) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// First matching route, or nil to fall through to the source destination.
+func matchRoute(routes []configurationRoute, message *discordgo.Message, domain string, extension string,
+	contentType string, contentTypeBase string) *configurationRoute {
+	for i := range routes {
+		if routes[i].Destination == "" {
+			continue
+		}
+		if routeMatches(routes[i], message, domain, extension, contentType, contentTypeBase) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
+
+// Logs routes that can't work when settings load.
+func validateRoutes(label string, routes []configurationRoute) {
+	for i, route := range routes {
+		if route.Destination == "" {
+			log.Println(lg("Settings", "Routes", color.HiRedString,
+				"%s route %d has no destination and will be ignored", label, i+1))
+		}
+		for _, list := range []filterList{
+			{"contentTypes", route.ContentTypes, filterModeExact},
+			{"extensions", route.Extensions, filterModeExact},
+			{"domains", route.Domains, filterModeDomain},
+			{"users", route.Users, filterModeExact},
+			{"roles", route.Roles, filterModeExact},
+			{"phrases", route.Phrases, filterModeContains},
+		} {
+			if list.entries == nil {
+				continue
+			}
+			for _, entry := range *list.entries {
+				if _, err := compileFilterEntry(entry, list.mode); err != nil {
+					log.Println(lg("Settings", "Routes", color.HiRedString,
+						"%s route %d %s pattern \"%s\" is invalid and will be ignored:\t%s", label, i+1, list.name, entry, err))
+				}
+			}
+		}
+	}
+}
+
+//#endregion
@@ -0,0 +1,896 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+//#region Rules
+
+// Rules are checked in order, the first whose expression matches decides what happens to a link:
+// save (download, skipping the filter lists), skip, or route (save to another destination).
+// When no rule matches, the filter lists decide as they always have.
+//
+// Expressions compare fields with literals, e.g.
+//	domain == "cdn.discordapp.com" and size > 10MB
+//	not (roles in ["123", "456"]) or message contains ["#keep", "#save"]
+//	contentType startsWith "video/" && !history
+//	filename matches '^IMG_\d+'
+//
+// Operators: == != < <= > >= contains startsWith endsWith matches in, and/&& or/|| not/!
+// A list on the right of contains/startsWith/endsWith matches any of its items,
+// list fields (roles, reactions) match when any item does. Comparisons with a size the server
+// didn't give never match.
+
+const (
+	ruleActionSave  = "save"
+	ruleActionSkip  = "skip"
+	ruleActionRoute = "route"
+)
+
+type ruleType int
+
+const (
+	ruleTypeString ruleType = iota
+	ruleTypeNumber
+	ruleTypeBool
+	ruleTypeList
+)
+
+func (t ruleType) String() string {
+	switch t {
+	case ruleTypeString:
+		return "text"
+	case ruleTypeNumber:
+		return "number"
+	case ruleTypeBool:
+		return "true/false"
+	case ruleTypeList:
+		return "list"
+	}
+	return "unknown"
+}
+
+// Everything a rule can see. Response fields are only set once the download has been requested.
+type ruleContext struct {
+	Message *discordgo.Message
+	Link    string
+	Domain  string
+	History bool
+
+	Response    bool
+	Filename    string
+	Extension   string
+	ContentType string
+	Size        int64 // -1 when the server didn't say
+
+	roles       []string
+	rolesLoaded bool
+}
+
+func (ctx *ruleContext) memberRoles() []string {
+	if !ctx.rolesLoaded {
+		ctx.rolesLoaded = true
+		if ctx.Message != nil && ctx.Message.Author != nil && ctx.Message.GuildID != "" {
+			member := ctx.Message.Member
+			if member == nil {
+				member, _ = bot.GuildMember(ctx.Message.GuildID, ctx.Message.Author.ID)
+			}
+			if member != nil {
+				ctx.roles = member.Roles
+			}
+		}
+	}
+	return ctx.roles
+}
+
+type ruleField struct {
+	kind     ruleType
+	response bool // only known after the response
+	get      func(ctx *ruleContext) interface{}
+}
+
+var ruleFields = map[string]ruleField{
+	"message": {ruleTypeString, false, func(ctx *ruleContext) interface{} {
+		if ctx.Message == nil {
+			return ""
+		}
+		return ctx.Message.Content
+	}},
+	"author": {ruleTypeString, false, func(ctx *ruleContext) interface{} {
+		if ctx.Message == nil || ctx.Message.Author == nil {
+			return ""
+		}
+		return ctx.Message.Author.ID
+	}},
+	"authorName": {ruleTypeString, false, func(ctx *ruleContext) interface{} {
+		if ctx.Message == nil || ctx.Message.Author == nil {
+			return ""
+		}
+		return ctx.Message.Author.Username
+	}},
+	"bot": {ruleTypeBool, false, func(ctx *ruleContext) interface{} {
+		return ctx.Message != nil && ctx.Message.Author != nil && ctx.Message.Author.Bot
+	}},
+	"roles": {ruleTypeList, false, func(ctx *ruleContext) interface{} {
+		return ctx.memberRoles()
+	}},
+	"reactions": {ruleTypeList, false, func(ctx *ruleContext) interface{} {
+		var reactions []string
+		if ctx.Message != nil {
+			for _, reaction := range ctx.Message.Reactions {
+				if reaction.Emoji != nil {
+					if reaction.Emoji.ID != "" {
+						reactions = append(reactions, reaction.Emoji.ID)
+					}
+					reactions = append(reactions, reaction.Emoji.Name)
+				}
+			}
+		}
+		return reactions
+	}},
+	"server": {ruleTypeString, false, func(ctx *ruleContext) interface{} {
+		if ctx.Message == nil {
+			return ""
+		}
+		return ctx.Message.GuildID
+	}},
+	"channel": {ruleTypeString, false, func(ctx *ruleContext) interface{} {
+		if ctx.Message == nil {
+			return ""
+		}
+		return ctx.Message.ChannelID
+	}},
+	"link":    {ruleTypeString, false, func(ctx *ruleContext) interface{} { return ctx.Link }},
+	"domain":  {ruleTypeString, false, func(ctx *ruleContext) interface{} { return ctx.Domain }},
+	"history": {ruleTypeBool, false, func(ctx *ruleContext) interface{} { return ctx.History }},
+	//
+	"filename":    {ruleTypeString, true, func(ctx *ruleContext) interface{} { return ctx.Filename }},
+	"extension":   {ruleTypeString, true, func(ctx *ruleContext) interface{} { return ctx.Extension }},
+	"contentType": {ruleTypeString, true, func(ctx *ruleContext) interface{} { return ctx.ContentType }},
+	"size": {ruleTypeNumber, true, func(ctx *ruleContext) interface{} {
+		if ctx.Size < 0 {
+			return math.NaN() // unknown, no comparison holds
+		}
+		return float64(ctx.Size)
+	}},
+}
+
+// Same way tryDownload works it out, lowercase with the dot and swapped like it will be.
+func ruleExtension(filename string, contentType string) string {
+	extension := strings.ToLower(filepath.Ext(filename))
+	if filepath.Ext(filename) == "" {
+		if possibleExtension, _ := mime.ExtensionsByType(contentType); len(possibleExtension) > 0 {
+			extension = possibleExtension[0]
+		}
+	}
+	if extension == ".jfif" || extension == ".jpglarge" || extension == ".jpeglarge" {
+		extension = ".jpg"
+	}
+	return extension
+}
+
+//#region Parsing
+
+type ruleTokenKind int
+
+const (
+	ruleTokenEnd ruleTokenKind = iota
+	ruleTokenIdent
+	ruleTokenString
+	ruleTokenNumber
+	ruleTokenSymbol
+)
+
+type ruleToken struct {
+	kind   ruleTokenKind
+	text   string
+	value  interface{} // parsed literal
+	offset int
+}
+
+var ruleSizeSuffixes = map[string]float64{
+	"b":  1,
+	"kb": 1000, "mb": 1000 * 1000, "gb": 1000 * 1000 * 1000, "tb": 1000 * 1000 * 1000 * 1000,
+	"kib": 1024, "mib": 1024 * 1024, "gib": 1024 * 1024 * 1024, "tib": 1024 * 1024 * 1024 * 1024,
+}
+
+func ruleTokenize(expression string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenIdent, text: string(runes[start:i]), offset: start})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			number, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad number \"%s\" at %d", string(runes[start:i]), start)
+			}
+			suffixStart := i
+			for i < len(runes) && unicode.IsLetter(runes[i]) {
+				i++
+			}
+			if suffix := strings.ToLower(string(runes[suffixStart:i])); suffix != "" {
+				multiplier, ok := ruleSizeSuffixes[suffix]
+				if !ok {
+					return nil, fmt.Errorf("unknown size unit \"%s\" at %d", suffix, suffixStart)
+				}
+				number *= multiplier
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenNumber, text: string(runes[start:i]), value: number, offset: start})
+		case r == '"':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated text starting at %d", start)
+			}
+			i++
+			value, err := strconv.Unquote(string(runes[start:i]))
+			if err != nil {
+				return nil, fmt.Errorf("bad text at %d: %s", start, err)
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenString, text: string(runes[start:i]), value: value, offset: start})
+		case r == '\'': // raw, handy for regular expressions
+			start := i
+			i++
+			for i < len(runes) && runes[i] != '\'' {
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated text starting at %d", start)
+			}
+			i++
+			tokens = append(tokens, ruleToken{kind: ruleTokenString, text: string(runes[start:i]),
+				value: string(runes[start+1 : i-1]), offset: start})
+		default:
+			symbol := string(r)
+			if i+1 < len(runes) {
+				switch pair := string(runes[i : i+2]); pair {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					symbol = pair
+				}
+			}
+			if len(symbol) == 1 && !strings.ContainsRune("()[],<>!", r) {
+				return nil, fmt.Errorf("unexpected \"%s\" at %d", symbol, i)
+			}
+			tokens = append(tokens, ruleToken{kind: ruleTokenSymbol, text: symbol, offset: i})
+			i += len(symbol)
+		}
+	}
+	return append(tokens, ruleToken{kind: ruleTokenEnd, offset: len(runes)}), nil
+}
+
+type ruleNode struct {
+	kind     ruleType
+	response bool // needs response fields
+	literal  bool
+	eval     func(ctx *ruleContext) interface{}
+}
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+func (p *ruleParser) peek() ruleToken { return p.tokens[p.pos] }
+
+func (p *ruleParser) next() ruleToken {
+	token := p.tokens[p.pos]
+	if token.kind != ruleTokenEnd {
+		p.pos++
+	}
+	return token
+}
+
+func (p *ruleParser) isKeyword(words ...string) bool {
+	token := p.peek()
+	if token.kind != ruleTokenIdent && token.kind != ruleTokenSymbol {
+		return false
+	}
+	for _, word := range words {
+		if strings.EqualFold(token.text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ruleParser) expect(symbol string) error {
+	if token := p.next(); token.kind != ruleTokenSymbol || token.text != symbol {
+		return fmt.Errorf("expected \"%s\" at %d", symbol, token.offset)
+	}
+	return nil
+}
+
+func ruleBoolOperand(node *ruleNode, operator string) error {
+	if node.kind != ruleTypeBool {
+		return fmt.Errorf("%s needs true/false on both sides, got %s", operator, node.kind)
+	}
+	return nil
+}
+
+func (p *ruleParser) parseOr() (*ruleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or", "||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err = ruleBoolOperand(left, "or"); err != nil {
+			return nil, err
+		}
+		if err = ruleBoolOperand(right, "or"); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		left = &ruleNode{kind: ruleTypeBool, response: left.response || right.response,
+			eval: func(ctx *ruleContext) interface{} { return l(ctx).(bool) || r(ctx).(bool) }}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (*ruleNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and", "&&") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err = ruleBoolOperand(left, "and"); err != nil {
+			return nil, err
+		}
+		if err = ruleBoolOperand(right, "and"); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		left = &ruleNode{kind: ruleTypeBool, response: left.response || right.response,
+			eval: func(ctx *ruleContext) interface{} { return l(ctx).(bool) && r(ctx).(bool) }}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseNot() (*ruleNode, error) {
+	if p.isKeyword("not", "!") {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err = ruleBoolOperand(node, "not"); err != nil {
+			return nil, err
+		}
+		inner := node.eval
+		return &ruleNode{kind: ruleTypeBool, response: node.response,
+			eval: func(ctx *ruleContext) interface{} { return !inner(ctx).(bool) }}, nil
+	}
+	return p.parseComparison()
+}
+
+var ruleOperators = []string{"==", "!=", "<=", ">=", "<", ">", "contains", "startsWith", "endsWith", "matches", "in"}
+
+func (p *ruleParser) parseComparison() (*ruleNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	operator := ""
+	for _, candidate := range ruleOperators {
+		if p.isKeyword(candidate) {
+			operator = candidate
+			break
+		}
+	}
+	if operator == "" {
+		return left, nil
+	}
+	operatorToken := p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	node, err := ruleCompare(operator, left, right)
+	if err != nil {
+		return nil, fmt.Errorf("%s (at %d)", err, operatorToken.offset)
+	}
+	node.response = left.response || right.response
+	return node, nil
+}
+
+func (p *ruleParser) parsePrimary() (*ruleNode, error) {
+	token := p.next()
+	switch token.kind {
+	case ruleTokenString:
+		value := token.value.(string)
+		return &ruleNode{kind: ruleTypeString, literal: true, eval: func(*ruleContext) interface{} { return value }}, nil
+	case ruleTokenNumber:
+		value := token.value.(float64)
+		return &ruleNode{kind: ruleTypeNumber, literal: true, eval: func(*ruleContext) interface{} { return value }}, nil
+	case ruleTokenIdent:
+		switch strings.ToLower(token.text) {
+		case "true", "false":
+			value := strings.EqualFold(token.text, "true")
+			return &ruleNode{kind: ruleTypeBool, literal: true, eval: func(*ruleContext) interface{} { return value }}, nil
+		}
+		field, exists := ruleFields[token.text]
+		if !exists {
+			return nil, fmt.Errorf("unknown field \"%s\" at %d", token.text, token.offset)
+		}
+		return &ruleNode{kind: field.kind, response: field.response, eval: field.get}, nil
+	case ruleTokenSymbol:
+		switch token.text {
+		case "(":
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		case "[":
+			var items []string
+			for {
+				item := p.next()
+				if item.kind == ruleTokenSymbol && item.text == "]" && len(items) == 0 {
+					break
+				}
+				switch item.kind {
+				case ruleTokenString:
+					items = append(items, item.value.(string))
+				case ruleTokenNumber:
+					items = append(items, strings.TrimPrefix(item.text, "+"))
+				default:
+					return nil, fmt.Errorf("lists can only hold text or numbers, found \"%s\" at %d", item.text, item.offset)
+				}
+				separator := p.next()
+				if separator.kind == ruleTokenSymbol && separator.text == "]" {
+					break
+				}
+				if separator.kind != ruleTokenSymbol || separator.text != "," {
+					return nil, fmt.Errorf("expected \",\" or \"]\" at %d", separator.offset)
+				}
+			}
+			return &ruleNode{kind: ruleTypeList, literal: true, eval: func(*ruleContext) interface{} { return items }}, nil
+		}
+	case ruleTokenEnd:
+		return nil, fmt.Errorf("expression ended early")
+	}
+	return nil, fmt.Errorf("unexpected \"%s\" at %d", token.text, token.offset)
+}
+
+// Applies test to the left value (or each item of a list) against the right value (or each item), any pair passing.
+func ruleAny(left interface{}, right interface{}, test func(a string, b string) bool) bool {
+	lefts, ok := left.([]string)
+	if !ok {
+		lefts = []string{left.(string)}
+	}
+	rights, ok := right.([]string)
+	if !ok {
+		rights = []string{right.(string)}
+	}
+	for _, a := range lefts {
+		for _, b := range rights {
+			if test(a, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ruleCompare(operator string, left *ruleNode, right *ruleNode) (*ruleNode, error) {
+	l, r := left.eval, right.eval
+	mismatch := fmt.Errorf("can't use %s between %s and %s", operator, left.kind, right.kind)
+	textual := func(node *ruleNode) bool { return node.kind == ruleTypeString || node.kind == ruleTypeList }
+	node := &ruleNode{kind: ruleTypeBool}
+
+	switch operator {
+	case "==", "!=":
+		if left.kind != right.kind || left.kind == ruleTypeList {
+			return nil, mismatch
+		}
+		negate := operator == "!="
+		node.eval = func(ctx *ruleContext) interface{} {
+			a, b := l(ctx), r(ctx)
+			if left.kind == ruleTypeNumber && (math.IsNaN(a.(float64)) || math.IsNaN(b.(float64))) {
+				return false
+			}
+			return (a == b) != negate
+		}
+	case "<", "<=", ">", ">=":
+		if left.kind != ruleTypeNumber || right.kind != ruleTypeNumber {
+			return nil, mismatch
+		}
+		node.eval = func(ctx *ruleContext) interface{} {
+			a, b := l(ctx).(float64), r(ctx).(float64)
+			switch operator {
+			case "<":
+				return a < b
+			case "<=":
+				return a <= b
+			case ">":
+				return a > b
+			}
+			return a >= b
+		}
+	case "contains":
+		if !textual(left) || !textual(right) {
+			return nil, mismatch
+		}
+		if left.kind == ruleTypeList { // membership
+			node.eval = func(ctx *ruleContext) interface{} {
+				return ruleAny(l(ctx), r(ctx), func(a, b string) bool { return a == b })
+			}
+		} else {
+			node.eval = func(ctx *ruleContext) interface{} {
+				return ruleAny(l(ctx), r(ctx), func(a, b string) bool { return b != "" && strings.Contains(a, b) })
+			}
+		}
+	case "in":
+		if !textual(left) || !textual(right) {
+			return nil, mismatch
+		}
+		if right.kind == ruleTypeString { // substring of
+			node.eval = func(ctx *ruleContext) interface{} {
+				return ruleAny(l(ctx), r(ctx), func(a, b string) bool { return a != "" && strings.Contains(b, a) })
+			}
+		} else {
+			node.eval = func(ctx *ruleContext) interface{} {
+				return ruleAny(l(ctx), r(ctx), func(a, b string) bool { return a == b })
+			}
+		}
+	case "startsWith", "endsWith":
+		if !textual(left) || !textual(right) {
+			return nil, mismatch
+		}
+		test := strings.HasPrefix
+		if operator == "endsWith" {
+			test = strings.HasSuffix
+		}
+		node.eval = func(ctx *ruleContext) interface{} {
+			return ruleAny(l(ctx), r(ctx), func(a, b string) bool { return b != "" && test(a, b) })
+		}
+	case "matches":
+		if !textual(left) || right.kind != ruleTypeString || !right.literal {
+			return nil, fmt.Errorf("matches needs text on the left and a quoted pattern on the right")
+		}
+		pattern, err := regexp.Compile(r(nil).(string))
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern: %s", err)
+		}
+		node.eval = func(ctx *ruleContext) interface{} {
+			return ruleAny(l(ctx), "", func(a, _ string) bool { return pattern.MatchString(a) })
+		}
+	}
+	return node, nil
+}
+
+//#endregion
+
+//#region Compiling & Evaluating
+
+type ruleCompiled struct {
+	node *ruleNode
+	err  error
+}
+
+var (
+	ruleCache      = make(map[string]ruleCompiled)
+	ruleCacheMutex sync.Mutex
+)
+
+// Compiled once per distinct expression, errors included so they're only reported once.
+func compileRule(expression string) (*ruleNode, error) {
+	ruleCacheMutex.Lock()
+	defer ruleCacheMutex.Unlock()
+	if compiled, exists := ruleCache[expression]; exists {
+		return compiled.node, compiled.err
+	}
+	node, err := parseRuleExpression(expression)
+	ruleCache[expression] = ruleCompiled{node, err}
+	return node, err
+}
+
+func parseRuleExpression(expression string) (*ruleNode, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+	tokens, err := ruleTokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	parser := &ruleParser{tokens: tokens}
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.kind != ruleTokenEnd {
+		return nil, fmt.Errorf("unexpected \"%s\" at %d", token.text, token.offset)
+	}
+	if node.kind != ruleTypeBool {
+		return nil, fmt.Errorf("expression must be true/false, not %s", node.kind)
+	}
+	return node, nil
+}
+
+func validateRule(rule configurationRule) error {
+	switch strings.ToLower(rule.Action) {
+	case ruleActionSave, ruleActionSkip:
+	case ruleActionRoute:
+		if rule.Destination == "" {
+			return fmt.Errorf("route needs a destination")
+		}
+	default:
+		return fmt.Errorf("unknown action \"%s\", use save, skip or route", rule.Action)
+	}
+	_, err := compileRule(rule.When)
+	return err
+}
+
+// Logs every broken rule when settings load, they never match at runtime.
+func validateRules(label string, rules []configurationRule) {
+	for i, rule := range rules {
+		if err := validateRule(rule); err != nil {
+			log.Println(lg("Settings", "Rules", color.HiRedString, "%s rule %d is invalid and will be ignored:\t%s\n\t\t\t%s",
+				label, i+1, err, rule.When))
+		}
+	}
+}
+
+// First matching rule, or nil. Deferred means a rule needing response fields was reached
+// before anything matched, so nothing can be decided yet.
+func evaluateRules(rules []configurationRule, ctx *ruleContext) (match *configurationRule, deferred bool) {
+	for i := range rules {
+		if validateRule(rules[i]) != nil {
+			continue
+		}
+		node, _ := compileRule(rules[i].When)
+		if node.response && !ctx.Response {
+			return nil, true
+		}
+		if node.eval(ctx).(bool) {
+			return &rules[i], false
+		}
+	}
+	return nil, false
+}
+
+func sourceHasRules(sourceConfig configurationSource) bool {
+	return sourceConfig.Rules != nil && len(*sourceConfig.Rules) > 0
+}
+
+//#endregion
+
+//#region Converting Filters
+
+func ruleQuoteList(items []string) string {
+	quoted := []string{}
+	for _, item := range items {
+		quoted = append(quoted, strconv.Quote(item))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func ruleAnd(a string, b string) string {
+	switch {
+	case a == "false" || b == "false":
+		return "false"
+	case a == "true":
+		return b
+	case b == "true":
+		return a
+	}
+	return "(" + a + ") and (" + b + ")"
+}
+
+func ruleOr(a string, b string) string {
+	switch {
+	case a == "true" || b == "true":
+		return "true"
+	case a == "false":
+		return b
+	case b == "false":
+		return a
+	}
+	return "(" + a + ") or (" + b + ")"
+}
+
+func ruleNot(a string) string {
+	switch a {
+	case "true":
+		return "false"
+	case "false":
+		return "true"
+	}
+	if inner, ok := ruleUnwrapNot(a); ok {
+		return inner
+	}
+	return "not (" + a + ")"
+}
+
+// Inside of "not (...)" when the parentheses wrap everything after it.
+func ruleUnwrapNot(expression string) (string, bool) {
+	if !strings.HasPrefix(expression, "not (") || !strings.HasSuffix(expression, ")") {
+		return "", false
+	}
+	depth, quoted := 0, false
+	for i := len("not "); i < len(expression); i++ {
+		switch c := expression[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == '(':
+			depth++
+		case !quoted && c == ')':
+			depth--
+			if depth == 0 && i != len(expression)-1 {
+				return "", false
+			}
+		}
+	}
+	return expression[len("not (") : len(expression)-1], true
+}
+
+// Mirrors a block/allow pass: allowed lists being set means ignored by default (links aside),
+// then each pair in order blocks on a blocked match and rescues on an allowed match, the last one winning.
+type ruleFilterPair struct {
+	blocked *[]string
+	allowed *[]string
+	match   func(items []string) string
+}
+
+func ruleFilterPass(pairs []ruleFilterPair, allowedBlocksByDefault bool) string {
+	pass := "true"
+	for _, pair := range pairs {
+		if pair.allowed != nil && allowedBlocksByDefault {
+			pass = "false"
+		}
+	}
+	for _, pair := range pairs {
+		if pair.blocked != nil {
+			pass = ruleAnd(ruleNot(pair.match(*pair.blocked)), pass)
+		}
+		if pair.allowed != nil {
+			pass = ruleOr(pair.match(*pair.allowed), pass)
+		}
+	}
+	return pass
+}
+
+// Expression matching any entry of a filter list, patterns included.
+func ruleFilterMatch(field string, items []string, mode filterMode) string {
+	var plain []string
+	when := "false"
+	for _, item := range items {
+		switch {
+		case strings.HasPrefix(item, filterPrefixRegex):
+			when = ruleOr(when, field+" matches "+strconv.Quote(strings.TrimPrefix(item, filterPrefixRegex)))
+		case strings.HasPrefix(item, filterPrefixGlob):
+			when = ruleOr(when, field+" matches "+
+				strconv.Quote(filterGlobRegex(strings.TrimPrefix(item, filterPrefixGlob), mode != filterModeContains)))
+		case mode == filterModeDomain && strings.HasPrefix(item, "*."):
+			when = ruleOr(when, field+" endsWith "+strconv.Quote(item[1:]))
+		case mode == filterModeContains && strings.TrimSpace(item) == "":
+		default:
+			plain = append(plain, item)
+		}
+	}
+	if len(plain) > 0 {
+		operator := " in "
+		if mode == filterModeContains {
+			operator = " contains "
+		}
+		when = ruleOr(field+operator+ruleQuoteList(plain), when)
+	}
+	return when
+}
+
+// Skip rules equivalent to the filter lists, one per stage they're applied in.
+func filtersToRules(filters *configurationSourceFilters) []configurationRule {
+	rules := []configurationRule{}
+	if filters == nil {
+		return rules
+	}
+	containsAny := func(field string) func([]string) string {
+		return func(items []string) string { return ruleFilterMatch(field, items, filterModeContains) }
+	}
+	inList := func(field string) func([]string) string {
+		return func(items []string) string { return ruleFilterMatch(field, items, filterModeExact) }
+	}
+	stages := [][]ruleFilterPair{
+		{ // message
+			{filters.BlockedPhrases, filters.AllowedPhrases, containsAny("message")},
+			{filters.BlockedUsers, filters.AllowedUsers, inList("author")},
+			{filters.BlockedRoles, filters.AllowedRoles, inList("roles")},
+		},
+		{{filters.BlockedLinkContent, filters.AllowedLinkContent, containsAny("link")}},
+		{{filters.BlockedDomains, filters.AllowedDomains, func(items []string) string {
+			return ruleFilterMatch("domain", items, filterModeDomain)
+		}}},
+		{{filters.BlockedFilenames, filters.AllowedFilenames, containsAny("filename")}},
+		{{filters.BlockedReactions, filters.AllowedReactions, inList("reactions")}},
+		{{filters.BlockedExtensions, filters.AllowedExtensions, inList("extension")}},
+	}
+	for i, stage := range stages {
+		if when := ruleNot(ruleFilterPass(stage, i != 1)); when != "false" {
+			rules = append(rules, configurationRule{When: when, Action: ruleActionSkip})
+		}
+	}
+	return rules
+}
+
+// For --convert-filters, prints rules for every source with filter lists then exits.
+func printFiltersAsRules() {
+	type convertedSource struct {
+		Source string              `json:"source"`
+		Rules  []configurationRule `json:"rules"`
+	}
+	var converted []convertedSource
+	add := func(label string, filters *configurationSourceFilters) {
+		if rules := filtersToRules(filters); len(rules) > 0 {
+			converted = append(converted, convertedSource{label, rules})
+		}
+	}
+	add("global", config.Filters)
+	for _, source := range config.Servers {
+		add(sourceLabel(source), source.Filters)
+	}
+	for _, source := range config.Categories {
+		add(sourceLabel(source), source.Filters)
+	}
+	for _, source := range config.Channels {
+		add(sourceLabel(source), source.Filters)
+	}
+	for _, source := range config.Users {
+		add(sourceLabel(source), source.Filters)
+	}
+	if config.All != nil {
+		add("all", config.All.Filters)
+	}
+	output, _ := json.MarshalIndent(converted, "", "\t")
+	fmt.Println(string(output))
+}
+
+//#endregion
+
+//#endregion
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func testRuleContext(size int64) *ruleContext {
+	return &ruleContext{
+		Message: &discordgo.Message{
+			Content:   "hello #keep this",
+			ChannelID: "200",
+			GuildID:   "100",
+			Author:    &discordgo.User{ID: "42", Username: "someone"},
+			Member:    &discordgo.Member{Roles: []string{"r1", "r2"}},
+			Reactions: []*discordgo.MessageReactions{{Emoji: &discordgo.Emoji{Name: "👍"}}},
+		},
+		Link:        "https://cdn.discordapp.com/attachments/1/2/IMG_12.png",
+		Domain:      "cdn.discordapp.com",
+		Response:    true,
+		Filename:    "IMG_12.png",
+		Extension:   ".png",
+		ContentType: "image/png",
+		Size:        size,
+	}
+}
+
+func TestParseRuleExpressionErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"   ",
+		"size >",
+		"size > 10XB",
+		"nope == 1",
+		"domain == 1",
+		"roles == roles",
+		"size contains 1",
+		"message matches domain",
+		"filename matches '('",
+		"(bot",
+		"bot)",
+		"domain",
+		"bot bot",
+		"\"unterminated",
+		"'unterminated",
+		"roles in [\"a\", bot]",
+		"roles in [\"a\" \"b\"]",
+		"not domain",
+		"bot and domain",
+		"size @ 1",
+	} {
+		if _, err := parseRuleExpression(expression); err == nil {
+			t.Errorf("%q parsed, want an error", expression)
+		}
+	}
+}
+
+func TestEvaluateRuleExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		size       int64
+		want       bool
+	}{
+		// Precedence, not binds tighter than and, and tighter than or
+		{"true or false and false", 0, true},
+		{"(true or false) and false", 0, false},
+		{"not false and false", 0, false},
+		{"not (false and false)", 0, true},
+		{"!true || true", 0, true},
+		{"false || true && !false", 0, true},
+		{"not not true", 0, true},
+		{"TRUE and True", 0, true},
+
+		// Fields & operators
+		{`domain == "cdn.discordapp.com" and size > 10MB`, 12000000, true},
+		{`domain != "cdn.discordapp.com"`, 0, false},
+		{"size > 20MB", 12000000, false},
+		{"size <= 12MB", 12000000, true},
+		{"size >= 1.5KiB", 1536, true},
+		{"size < 1.5KiB", 1536, false},
+		{"size == 0", 0, true},
+		{`roles in ["r2", "r9"]`, 0, true},
+		{`roles in ["r9"]`, 0, false},
+		{`roles contains "r1"`, 0, true},
+		{`reactions contains "👍"`, 0, true},
+		{`message contains ["#keep", "#save"]`, 0, true},
+		{`message contains ["#save"]`, 0, false},
+		{`message contains ""`, 0, false},
+		{`contentType startsWith "video/"`, 0, false},
+		{`contentType startsWith ["video/", "image/"]`, 0, true},
+		{`link endsWith ".png"`, 0, true},
+		{`filename matches '^IMG_\d+'`, 0, true},
+		{`filename matches "^\\d+"`, 0, false},
+		{`"cdn" in domain`, 0, true},
+		{`extension in [".png", ".jpg"]`, 0, true},
+		{`author in [42, 43]`, 0, true},
+		{`authorName == "someone" && !bot && !history`, 0, true},
+		{`server == "100" and channel == "200"`, 0, true},
+
+		// Unknown sizes never compare
+		{"size < 20MB", -1, false},
+		{"size > 0", -1, false},
+		{"size == 0", -1, false},
+		{"size != 0", -1, false},
+		{"not (size < 20MB)", -1, true},
+	}
+	for _, test := range tests {
+		node, err := parseRuleExpression(test.expression)
+		if err != nil {
+			t.Errorf("%q: %s", test.expression, err)
+			continue
+		}
+		if got := node.eval(testRuleContext(test.size)).(bool); got != test.want {
+			t.Errorf("%q with size %d = %v, want %v", test.expression, test.size, got, test.want)
+		}
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	rules := []configurationRule{
+		{When: "broken ==", Action: ruleActionSkip},
+		{When: `author == "1"`, Action: ruleActionSkip},
+		{When: "size > 10MB", Action: ruleActionRoute, Destination: "big"},
+		{When: "true", Action: ruleActionSave},
+	}
+
+	ctx := testRuleContext(12000000)
+	ctx.Response = false
+	if match, deferred := evaluateRules(rules, ctx); match != nil || !deferred {
+		t.Errorf("before the response got %v deferred %v, want it deferred", match, deferred)
+	}
+
+	ctx.Response = true
+	if match, _ := evaluateRules(rules, ctx); match == nil || match.Destination != "big" {
+		t.Errorf("got %v, want the route rule", match)
+	}
+
+	ctx.Size = -1
+	if match, _ := evaluateRules(rules, ctx); match == nil || match.Action != ruleActionSave {
+		t.Errorf("with an unknown size got %v, want the save rule", match)
+	}
+}
+
+func TestFiltersToRules(t *testing.T) {
+	blocked := []string{"spoiler"}
+	allowed := []string{".png", ".jpg"}
+	rules := filtersToRules(&configurationSourceFilters{BlockedPhrases: &blocked, AllowedExtensions: &allowed})
+	if len(rules) != 2 {
+		t.Fatalf("got %d rules, want 2: %v", len(rules), rules)
+	}
+	for _, rule := range rules {
+		if err := validateRule(rule); err != nil {
+			t.Errorf("%q is invalid: %s", rule.When, err)
+		}
+	}
+	ctx := testRuleContext(0)
+	if match, _ := evaluateRules(rules, ctx); match != nil {
+		t.Errorf("allowed png skipped by %q", match.When)
+	}
+	ctx.Extension = ".gif"
+	if match, _ := evaluateRules(rules, ctx); match == nil {
+		t.Errorf("gif wasn't skipped")
+	}
+	ctx.Extension = ".png"
+	ctx.Message.Content = "a spoiler"
+	if match, _ := evaluateRules(rules, ctx); match == nil {
+		t.Errorf("blocked phrase wasn't skipped")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+//#region Thumbnails
+
+// JPEG previews of saved JPEG, PNG & GIF images, made when they're saved if the source has thumbnails set.
+// In the tree location they mirror the file under .thumbs in the destination it was saved to,
+// the sidecar location puts them next to the file.
+
+const (
+	thumbnailLocationTree    = "tree"
+	thumbnailLocationSidecar = "sidecar"
+	thumbnailFolder          = ".thumbs"
+	imageDecodeMaxPixels     = 64 * 1000 * 1000 // 256MB decoded
+)
+
+func canThumbnail(contentType string) bool {
+	switch strings.TrimSpace(strings.Split(contentType, ";")[0]) {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Where the thumbnail of the file saved at filePath under root goes.
+func thumbnailPath(thumbnails configurationSourceThumbnails, root string, filePath string) string {
+	name := filepath.Base(filePath) // whole name, pic.png & pic.jpg don't share one
+	if strings.ToLower(*thumbnails.Location) == thumbnailLocationSidecar {
+		return filepath.Join(filepath.Dir(filePath), name+".thumb.jpg")
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(filePath))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = ""
+	}
+	return filepath.Join(root, thumbnailFolder, rel, name+".jpg")
+}
+
+// Scales the image down to fit size on its longest side, smaller images keep their size.
+func makeThumbnail(sourcePath string, thumbPath string, size int) error {
+	img, err := decodeImageFile(sourcePath)
+	if err != nil {
+		return err
+	}
+	thumb := resize.Thumbnail(uint(size), uint(size), img, resize.Bilinear)
+	flat := image.NewRGBA(thumb.Bounds()) // transparency onto white, JPEG has none
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), thumb, thumb.Bounds().Min, draw.Over)
+
+	if err = os.MkdirAll(filepath.Dir(thumbPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := thumbPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err = jpeg.Encode(out, flat, &jpeg.Options{Quality: 85}); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, thumbPath)
+}
+
+//#endregion
@@ -14,12 +14,17 @@ var (
 
 	pathCache             = "cache"
 	pathCacheHistory      = pathCache + string(os.PathSeparator) + "history"
+	pathCacheHistoryJobs  = pathCache + string(os.PathSeparator) + "history-jobs.json"
+	pathCacheCheckpoints  = pathCache + string(os.PathSeparator) + "checkpoints.json"
+	pathCacheLogKeys      = pathCache + string(os.PathSeparator) + "log-keys"
 	pathCacheSettingsJSON = pathCache + string(os.PathSeparator) + "settings.json"
 	pathCacheSettingsYAML = pathCache + string(os.PathSeparator) + "settings.yaml"
 	pathCacheDuplo        = pathCache + string(os.PathSeparator) + ".duplo"
 	pathCacheTwitter      = pathCache + string(os.PathSeparator) + "twitter.json"
 	pathCacheInstagram    = pathCache + string(os.PathSeparator) + "instagram.json"
 	pathConstants         = pathCache + string(os.PathSeparator) + "constants.json"
+	pathCacheWebhooks     = pathCache + string(os.PathSeparator) + "webhooks.jsonl"
+	pathCacheDryRun       = pathCache + string(os.PathSeparator) + "dry-run.jsonl"
 	pathDatabaseBase      = "database"
 	pathDatabaseBackups   = "backups"
 
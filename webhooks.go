@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/fatih/color"
+)
+
+//#region Webhooks
+
+const (
+	webhookEventDownloadSuccess = "download.success"
+	webhookEventDownloadSkipped = "download.skipped"
+	webhookEventDownloadFailed  = "download.failed"
+	webhookEventHistoryStatus   = "history.status"
+
+	webhookBackoffMax = 5 * time.Minute
+)
+
+type webhookPayload struct {
+	Event    string           `json:"event"`
+	Delivery string           `json:"delivery"`
+	Time     time.Time        `json:"time"`
+	Download *webhookDownload `json:"download,omitempty"`
+	History  *apiHistoryJob   `json:"history,omitempty"`
+}
+
+type webhookDownloadStatus struct {
+	Code   int    `json:"code"`
+	Result string `json:"result"` // downloaded, skipped, failed
+	Label  string `json:"label"`
+	Error  string `json:"error,omitempty"`
+}
+
+type webhookDownload struct {
+	Status      webhookDownloadStatus `json:"status"`
+	URL         string                `json:"url"`
+	Filename    string                `json:"filename,omitempty"`
+	Path        string                `json:"path,omitempty"`
+	ThumbPath   string                `json:"thumbPath,omitempty"`
+	Filesize    int64                 `json:"filesize"`
+	ContentType string                `json:"contentType,omitempty"`
+	Hash        string                `json:"hash,omitempty"`
+	Domain      string                `json:"domain,omitempty"`
+	ServerID    string                `json:"serverID,omitempty"`
+	ChannelID   string                `json:"channelID,omitempty"`
+	MessageID   string                `json:"messageID,omitempty"`
+	UserID      string                `json:"userID,omitempty"`
+	History     bool                  `json:"history"`
+}
+
+// One line per delivery attempt in pathCacheWebhooks.
+type webhookJournalEntry struct {
+	Time      time.Time `json:"time"`
+	Delivery  string    `json:"delivery"`
+	Event     string    `json:"event"`
+	URL       string    `json:"url"`
+	Attempt   int       `json:"attempt"`
+	Code      int       `json:"code,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Took      int64     `json:"tookMs"`
+	Delivered bool      `json:"delivered"`
+	GaveUp    bool      `json:"gaveUp,omitempty"`
+}
+
+var webhookJournalMutex sync.Mutex
+
+// Source webhooks when the message belongs to a source, otherwise the global ones.
+func webhooksFor(message *discordgo.Message) []configurationWebhook {
+	if message != nil {
+		if sourceConfig := getSource(message); sourceConfig != emptySourceConfig {
+			if sourceConfig.Webhooks != nil {
+				return *sourceConfig.Webhooks
+			}
+			return nil
+		}
+	}
+	return config.Webhooks
+}
+
+// Matches exact events, "download.*" style prefixes, or everything when none are set.
+func webhookWants(hook configurationWebhook, event string) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, want := range hook.Events {
+		want = strings.ToLower(strings.TrimSpace(want))
+		if want == event || want == "*" ||
+			(strings.HasSuffix(want, ".*") && strings.HasPrefix(event, strings.TrimSuffix(want, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func webhookDeliveryID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sendWebhookEvent(hooks []configurationWebhook, payload webhookPayload) {
+	for _, hook := range hooks {
+		if hook.URL == "" || !webhookWants(hook, payload.Event) {
+			continue
+		}
+		payload.Delivery = webhookDeliveryID()
+		payload.Time = time.Now()
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Println(lg("Webhook", "", color.HiRedString, "Failed to encode %s event:\t%s", payload.Event, err))
+			return
+		}
+		go webhookDeliver(hook, payload.Event, payload.Delivery, body)
+	}
+}
+
+func webhookDownloadEvent(download downloadRequestStruct, status downloadStatusStruct) {
+	var event string
+	switch {
+	case status.Status == downloadIgnored:
+		return
+	case isDownloadFailed(status.Status):
+		event = webhookEventDownloadFailed
+	case status.Status >= downloadSkipped:
+		event = webhookEventDownloadSkipped
+	default:
+		event = webhookEventDownloadSuccess
+	}
+	var hooks []configurationWebhook
+	if download.EmojiCmd {
+		hooks = config.Webhooks
+	} else {
+		hooks = webhooksFor(download.Message)
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	sendWebhookEvent(hooks, webhookPayload{Event: event, Download: newWebhookDownload(download, status)})
+}
+
+// File & message details of a finished download, shared with post-download commands.
+func newWebhookDownload(download downloadRequestStruct, status downloadStatusStruct) *webhookDownload {
+	data := &webhookDownload{
+		Status: webhookDownloadStatus{
+			Code:   int(status.Status),
+			Result: strings.ToLower(getDownloadStatusShort(status.Status)),
+			Label:  getDownloadStatus(status.Status),
+		},
+		URL:     download.InputURL,
+		History: download.HistoryCmd,
+	}
+	if status.Error != nil {
+		data.Status.Error = status.Error.Error()
+	}
+	if record := download.Record; record != nil {
+		data.Filename = record.Filename
+		data.Path = record.Destination
+		data.ThumbPath = record.ThumbPath
+		data.Filesize = record.Filesize
+		data.ContentType = record.ContentType
+		data.Hash = record.Hash
+		data.Domain = record.Domain
+		data.ServerID = record.GuildID
+		data.ChannelID = record.ChannelID
+		data.MessageID = record.MessageID
+		data.UserID = record.UserID
+	}
+	return data
+}
+
+func webhookHistoryEvent(channelID string, job historyJob) {
+	hooks := webhooksFor(&discordgo.Message{ChannelID: channelID})
+	if len(hooks) == 0 {
+		return
+	}
+	history := apiHistoryJobFrom(channelID, job)
+	sendWebhookEvent(hooks, webhookPayload{Event: webhookEventHistoryStatus, History: &history})
+}
+
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Retries network errors, 408, 429 & 5xx with exponential backoff, honoring Retry-After.
+func webhookDeliver(hook configurationWebhook, event string, delivery string, body []byte) {
+	client := &http.Client{Timeout: time.Duration(config.WebhookTimeout) * time.Second}
+	backoff := 2 * time.Second
+	for attempt := 1; attempt <= config.WebhookRetryMax; attempt++ {
+		entry := webhookJournalEntry{
+			Time:     time.Now(),
+			Delivery: delivery,
+			Event:    event,
+			URL:      hook.URL,
+			Attempt:  attempt,
+		}
+		retry := true
+		var retryAfter time.Duration
+
+		request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			entry.Error = err.Error()
+			retry = false
+		} else {
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("User-Agent", fmt.Sprintf("%s/%s", projectName, projectVersion))
+			request.Header.Set("X-DDG-Event", event)
+			request.Header.Set("X-DDG-Delivery", delivery)
+			if hook.Secret != "" {
+				request.Header.Set("X-DDG-Signature-256", webhookSignature(hook.Secret, body))
+			}
+			for key, value := range hook.Headers {
+				request.Header.Set(key, value)
+			}
+
+			response, err := client.Do(request)
+			if err != nil {
+				entry.Error = err.Error()
+			} else {
+				io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+				response.Body.Close()
+				entry.Code = response.StatusCode
+				if response.StatusCode >= 200 && response.StatusCode < 300 {
+					entry.Delivered = true
+					retry = false
+				} else {
+					entry.Error = response.Status
+					retry = response.StatusCode == http.StatusRequestTimeout ||
+						response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+					if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && seconds > 0 {
+						retryAfter = time.Duration(seconds) * time.Second
+					}
+				}
+			}
+		}
+		entry.Took = time.Since(entry.Time).Milliseconds()
+		entry.GaveUp = !entry.Delivered && (!retry || attempt == config.WebhookRetryMax)
+		webhookJournal(entry)
+
+		if entry.Delivered {
+			if config.Debug {
+				log.Println(lg("Debug", "Webhook", color.HiGreenString, "Delivered %s to %s (attempt %d)",
+					event, hook.URL, attempt))
+			}
+			return
+		}
+		if entry.GaveUp {
+			log.Println(lg("Webhook", "", color.HiRedString, "Gave up delivering %s to %s after %d attempt%s:\t%s",
+				event, hook.URL, attempt, pluralS(attempt), entry.Error))
+			return
+		}
+		wait := max(backoff, retryAfter)
+		time.Sleep(min(wait, webhookBackoffMax))
+		backoff *= 2
+	}
+}
+
+func webhookJournal(entry webhookJournalEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	webhookJournalMutex.Lock()
+	defer webhookJournalMutex.Unlock()
+	if err := os.MkdirAll(filepath.Dir(pathCacheWebhooks), 0755); err != nil {
+		log.Println(lg("Webhook", "", color.HiRedString, "Failed to create journal folder:\t%s", err))
+		return
+	}
+	f, err := os.OpenFile(pathCacheWebhooks, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Println(lg("Webhook", "", color.HiRedString, "Failed to open delivery journal:\t%s", err))
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+//#endregion